@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hysios/x/mq"
+)
+
+// record is the JSON line written for a message
+type record struct {
+	Topic   string            `json:"topic"`
+	ID      string            `json:"id,omitempty"`
+	Key     string            `json:"key,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Payload is embedded as is when it's JSON, otherwise as a string
+	Payload json.RawMessage `json:"payload"`
+	// ReplayedTo is the topic a replayed message was published to
+	ReplayedTo string `json:"replayed_to,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+func newRecord(topic string, msg mq.Message) record {
+	return record{
+		Topic:   topic,
+		ID:      mq.IDOf(msg),
+		Key:     mq.KeyOf(msg),
+		Headers: mq.HeadersOf(msg),
+		Payload: payloadJSON(msg.Payload()),
+	}
+}
+
+func payloadJSON(payload []byte) json.RawMessage {
+	if json.Valid(payload) {
+		return payload
+	}
+
+	data, _ := json.Marshal(string(payload))
+	return data
+}
+
+// printer writes JSON lines, or indented JSON with pretty
+type printer struct {
+	enc *json.Encoder
+}
+
+func newPrinter(out io.Writer, pretty bool) *printer {
+	var enc = json.NewEncoder(out)
+	if pretty {
+		enc.SetIndent("", "  ")
+	}
+	return &printer{enc: enc}
+}
+
+func (p *printer) print(r record) error {
+	return p.enc.Encode(r)
+}
+
+// headerFlags collects repeated key=value headers
+type headerFlags map[string]string
+
+func (h headerFlags) String() string {
+	return fmt.Sprint(map[string]string(h))
+}
+
+func (h headerFlags) Set(v string) error {
+	key, val, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("expect key=value, got %q", v)
+	}
+	h[key] = val
+	return nil
+}
+
+var errTopicRequired = errors.New("-topic is required")
+
+// publish publishes the payload argument, the -file or stdin
+func publish(ctx context.Context, d mq.Driver, args []string, out io.Writer) error {
+	var (
+		fs      = flag.NewFlagSet("publish", flag.ExitOnError)
+		topic   = fs.String("topic", "", "topic to publish to")
+		key     = fs.String("key", "", "partition key")
+		id      = fs.String("id", "", "message id")
+		file    = fs.String("file", "", "read the payload from file, - for stdin")
+		delay   = fs.Duration("delay", 0, "deliver the message after delay")
+		headers = make(headerFlags)
+	)
+	fs.Var(headers, "header", "message header key=value, may be repeated")
+	fs.Parse(args)
+
+	if *topic == "" {
+		return errTopicRequired
+	}
+
+	var (
+		payload []byte
+		err     error
+	)
+	switch {
+	case *file == "-" || (*file == "" && fs.NArg() == 0):
+		payload, err = io.ReadAll(os.Stdin)
+	case *file != "":
+		payload, err = os.ReadFile(*file)
+	default:
+		payload = []byte(strings.Join(fs.Args(), " "))
+	}
+	if err != nil {
+		return err
+	}
+
+	var opts = []mq.PubOpt{mq.WithContext(ctx), mq.Headers(headers)}
+	if *key != "" {
+		opts = append(opts, mq.Key(*key))
+	}
+	if *id != "" {
+		opts = append(opts, mq.MessageID(*id))
+	}
+	if *delay > 0 {
+		opts = append(opts, mq.Delay(*delay))
+	}
+
+	if err := d.Publish(*topic, payload, opts...); err != nil {
+		return err
+	}
+
+	return newPrinter(out, false).print(record{
+		Topic:   *topic,
+		ID:      *id,
+		Key:     *key,
+		Headers: headers,
+		Payload: payloadJSON(payload),
+	})
+}
+
+// tail prints and acks the messages until interrupted
+func tail(ctx context.Context, d mq.Driver, args []string, out io.Writer) error {
+	var (
+		fs     = flag.NewFlagSet("tail", flag.ExitOnError)
+		topic  = fs.String("topic", "", "topic to tail")
+		queue  = fs.String("queue", "", "queue or consumer group, a temporary one when empty")
+		n      = fs.Int("n", 0, "exit after n messages, 0 tails forever")
+		pretty = fs.Bool("pretty", false, "indent the output")
+	)
+	fs.Parse(args)
+
+	if *topic == "" {
+		return errTopicRequired
+	}
+
+	var p = newPrinter(out, *pretty)
+	return receive(ctx, d, *topic, *queue, *n, 0, func(msg mq.Message) error {
+		msg.Ack()
+		return p.print(newRecord(*topic, msg))
+	})
+}
+
+// peek prints the queued messages and nacks them once done, so they stay in the queue
+func peek(ctx context.Context, d mq.Driver, args []string, out io.Writer) error {
+	var (
+		fs     = flag.NewFlagSet("peek", flag.ExitOnError)
+		topic  = fs.String("topic", "", "topic of the queue")
+		queue  = fs.String("queue", "", "queue or consumer group to peek")
+		n      = fs.Int("n", 10, "max number of messages")
+		wait   = fs.Duration("wait", 2*time.Second, "stop after being idle for wait")
+		pretty = fs.Bool("pretty", false, "indent the output")
+		seen   []mq.Message
+	)
+	fs.Parse(args)
+
+	if *topic == "" {
+		return errTopicRequired
+	}
+
+	defer func() {
+		for _, msg := range seen {
+			mq.Nack(msg)
+		}
+	}()
+
+	var p = newPrinter(out, *pretty)
+	return receive(ctx, d, *topic, *queue, *n, *wait, func(msg mq.Message) error {
+		seen = append(seen, msg)
+		return p.print(newRecord(*topic, msg))
+	})
+}
+
+// drain prints and acks the queued messages until the queue is idle
+func drain(ctx context.Context, d mq.Driver, args []string, out io.Writer) error {
+	var (
+		fs     = flag.NewFlagSet("drain", flag.ExitOnError)
+		topic  = fs.String("topic", "", "topic of the queue")
+		queue  = fs.String("queue", "", "queue or consumer group to drain")
+		n      = fs.Int("n", 0, "max number of messages, 0 drains all")
+		wait   = fs.Duration("wait", 2*time.Second, "stop after being idle for wait")
+		pretty = fs.Bool("pretty", false, "indent the output")
+	)
+	fs.Parse(args)
+
+	if *topic == "" {
+		return errTopicRequired
+	}
+
+	var p = newPrinter(out, *pretty)
+	return receive(ctx, d, *topic, *queue, *n, *wait, func(msg mq.Message) error {
+		msg.Ack()
+		return p.print(newRecord(*topic, msg))
+	})
+}
+
+// replay republishes the dead letters onto -to or the topic of their OriginalTopicHeader
+func replay(ctx context.Context, d mq.Driver, args []string, out io.Writer) error {
+	var (
+		fs    = flag.NewFlagSet("replay", flag.ExitOnError)
+		from  = fs.String("from", "", "dead-letter topic")
+		queue = fs.String("queue", "", "queue or consumer group of the dead letters")
+		to    = fs.String("to", "", "topic to replay to, defaults to the "+mq.OriginalTopicHeader+" header")
+		n     = fs.Int("n", 0, "max number of messages, 0 replays all")
+		wait  = fs.Duration("wait", 2*time.Second, "stop after being idle for wait")
+		// failed messages are nacked once done, so they aren't replayed again in the loop
+		failed []mq.Message
+	)
+	fs.Parse(args)
+
+	if *from == "" {
+		return errors.New("-from is required")
+	}
+
+	defer func() {
+		for _, msg := range failed {
+			mq.Nack(msg)
+		}
+	}()
+
+	var p = newPrinter(out, false)
+	return receive(ctx, d, *from, *queue, *n, *wait, func(msg mq.Message) error {
+		var (
+			r       = newRecord(*from, msg)
+			target  = *to
+			headers = make(map[string]string)
+		)
+
+		for k, v := range r.Headers {
+			if k == mq.OriginalTopicHeader {
+				if target == "" {
+					target = v
+				}
+				continue
+			}
+			headers[k] = v
+		}
+
+		// the replay gets a new id, the original one would be dropped as a duplicate
+		if r.ID != "" && headers[mq.OriginalIDHeader] == "" {
+			headers[mq.OriginalIDHeader] = r.ID
+		}
+
+		var err error
+		if target == "" {
+			err = errors.New("no original topic")
+		} else {
+			err = d.Publish(target, msg.Payload(), mq.WithContext(ctx), mq.Headers(headers), mq.Key(r.Key))
+		}
+
+		if err != nil {
+			failed = append(failed, msg)
+			r.Error = err.Error()
+		} else {
+			msg.Ack()
+			r.ReplayedTo = target
+		}
+		return p.print(r)
+	})
+}
+
+// receive calls fn for the messages of topic until n messages were received, no
+// message arrived for wait, or ctx is done. Zero n or wait disables the limit.
+// An empty queue is a temporary one.
+func receive(ctx context.Context, d mq.Driver, topic, queue string, n int, wait time.Duration, fn func(msg mq.Message) error) error {
+	// the subscription, a temporary queue with it, ends with the command
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var opts = []mq.SubOpt{mq.Until(ctx)}
+	if queue != "" {
+		opts = append(opts, mq.Queue(queue))
+	}
+
+	msgs, err := d.Subscribe(topic, opts...)
+	if err != nil {
+		return err
+	}
+
+	var idle <-chan time.Time
+	for count := 0; n == 0 || count < n; count++ {
+		if wait > 0 {
+			idle = time.After(wait)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-idle:
+			return nil
+		case msg, ok := <-msgs:
+			if !ok {
+				return nil
+			}
+			if err := fn(msg); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hysios/x/mq"
+	"github.com/hysios/x/mq/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func records(t *testing.T, out *bytes.Buffer) []record {
+	var rs []record
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var r record
+		assert.NoError(t, json.Unmarshal([]byte(line), &r))
+		rs = append(rs, r)
+	}
+	out.Reset()
+	return rs
+}
+
+func TestPublishPeekDrain(t *testing.T) {
+	var (
+		d   = memory.Open(memory.DefaultConfig)
+		ctx = context.Background()
+		out bytes.Buffer
+	)
+
+	// the memory driver only delivers to existing subscriptions
+	_, err := d.Subscribe("orders", mq.Queue("billing"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, publish(ctx, d, []string{"-topic", "orders", "-key", "u1", "-header", "trace=t1", `{"id":1}`}, &out))
+	assert.NoError(t, publish(ctx, d, []string{"-topic", "orders", "plain text"}, &out))
+	assert.Len(t, records(t, &out), 2)
+
+	assert.NoError(t, peek(ctx, d, []string{"-topic", "orders", "-queue", "billing", "-wait", "50ms"}, &out))
+	var rs = records(t, &out)
+	assert.Len(t, rs, 2)
+	assert.JSONEq(t, `{"id":1}`, string(rs[0].Payload))
+	assert.Equal(t, "u1", rs[0].Key)
+	assert.Equal(t, "t1", rs[0].Headers["trace"])
+	assert.JSONEq(t, `"plain text"`, string(rs[1].Payload))
+
+	// peeked messages are still queued
+	assert.NoError(t, drain(ctx, d, []string{"-topic", "orders", "-queue", "billing", "-wait", "50ms"}, &out))
+	assert.Len(t, records(t, &out), 2)
+
+	assert.NoError(t, drain(ctx, d, []string{"-topic", "orders", "-queue", "billing", "-wait", "50ms"}, &out))
+	assert.Empty(t, out.String())
+}
+
+func TestReplay(t *testing.T) {
+	var (
+		d   = memory.Open(memory.DefaultConfig)
+		ctx = context.Background()
+		out bytes.Buffer
+	)
+
+	orders, _ := d.Subscribe("orders", mq.Queue("billing"))
+	_, _ = d.Subscribe("orders.dlq", mq.Queue("dlq"))
+
+	var deadLetter = mq.DeadLetterTo(d, "orders.dlq")
+	deadLetter(ctx, mq.Meta{Topic: "orders", Message: &testMessage{payload: []byte("bad")}}, nil)
+	assert.NoError(t, d.Publish("orders.dlq", []byte("lost")))
+
+	assert.NoError(t, replay(ctx, d, []string{"-from", "orders.dlq", "-queue", "dlq", "-wait", "50ms"}, &out))
+	var rs = records(t, &out)
+	assert.Len(t, rs, 2)
+	assert.Equal(t, "orders", rs[0].ReplayedTo)
+	assert.NotEmpty(t, rs[1].Error)
+
+	msg := <-orders
+	assert.Equal(t, "bad", string(msg.Payload()))
+	assert.NotContains(t, mq.HeadersOf(msg), mq.OriginalTopicHeader)
+	// a new id, the dead letter's is kept
+	assert.Equal(t, rs[0].ID, mq.HeadersOf(msg)[mq.OriginalIDHeader])
+	assert.NotEqual(t, rs[0].ID, mq.IDOf(msg))
+}
+
+type testMessage struct {
+	payload []byte
+}
+
+func (m *testMessage) Payload() []byte { return m.payload }
+func (m *testMessage) Ack() bool       { return true }
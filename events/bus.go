@@ -0,0 +1,504 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/hysios/x/events/common"
+	"github.com/hysios/x/events/driver"
+)
+
+var (
+	// ErrBusClosed is returned by a closed Bus
+	ErrBusClosed = errors.New("events: bus closed")
+	// ErrDuplicateHandler is returned when a handler name is already added to the router
+	ErrDuplicateHandler = errors.New("events: duplicate handler")
+)
+
+// Bus publishes and subscribes through one driver. The publisher and the
+// subscriber are created on first use and reused until Close, so a process
+// can keep several buses on different brokers.
+type Bus struct {
+	driver driver.Driver
+	opt    BusOption
+
+	mu     sync.Mutex
+	pub    common.Publisher
+	sub    common.Subscriber
+	groups map[string]common.Subscriber
+	// listeners are the temporary subscribers of Listen
+	listeners map[common.Subscriber]bool
+	closed    bool
+
+	rmu    sync.Mutex
+	r      *Router
+	runCtx context.Context
+}
+
+type BusOption struct {
+	// Publisher decorates the publisher of the driver, e.g. with schema.Publisher
+	Publisher func(common.Publisher) common.Publisher
+	// Router are the options of the router of the bus
+	Router []RouterOpt
+}
+
+type BusOpt func(*BusOption)
+
+var DefaultBusOption = BusOption{}
+
+// WithPublisher decorates the publisher of the bus
+func WithPublisher(decorate func(common.Publisher) common.Publisher) BusOpt {
+	return func(o *BusOption) {
+		o.Publisher = decorate
+	}
+}
+
+// WithRouterOptions sets the options of the router of the bus
+func WithRouterOptions(opts ...RouterOpt) BusOpt {
+	return func(o *BusOption) {
+		o.Router = append(o.Router, opts...)
+	}
+}
+
+// NewBus
+func NewBus(d driver.Driver, opts ...BusOpt) *Bus {
+	var opt = DefaultBusOption
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return &Bus{driver: d, opt: opt}
+}
+
+// Publisher returns the publisher of the bus
+func (b *Bus) Publisher() (common.Publisher, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrBusClosed
+	}
+
+	if b.pub == nil {
+		pub, err := b.driver.CreatePublisher()
+		if err != nil {
+			return nil, err
+		}
+		if b.opt.Publisher != nil {
+			pub = b.opt.Publisher(pub)
+		}
+		b.pub = pub
+	}
+	return b.pub, nil
+}
+
+// Subscriber returns the subscriber of the bus
+func (b *Bus) Subscriber() (common.Subscriber, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrBusClosed
+	}
+
+	if b.sub == nil {
+		sub, err := b.driver.CreateSubscriber()
+		if err != nil {
+			return nil, err
+		}
+		b.sub = sub
+	}
+	return b.sub, nil
+}
+
+// GroupSubscriber returns the subscriber of the queue group, the subscriber of
+// the bus when the driver isn't a driver.GroupDriver
+func (b *Bus) GroupSubscriber(group string) (common.Subscriber, error) {
+	gd, ok := b.driver.(driver.GroupDriver)
+	if !ok || group == "" {
+		return b.Subscriber()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrBusClosed
+	}
+
+	if sub, ok := b.groups[group]; ok {
+		return sub, nil
+	}
+
+	sub, err := gd.CreateGroupSubscriber(group)
+	if err != nil {
+		return nil, err
+	}
+	if b.groups == nil {
+		b.groups = make(map[string]common.Subscriber)
+	}
+	b.groups[group] = sub
+	return sub, nil
+}
+
+// Publish publishes messages to topic, the trace of every message context is written into its metadata
+func (b *Bus) Publish(topic string, messages ...*common.Message) (err error) {
+	var end = inject(topic, messages)
+	defer func() { end(err) }()
+
+	pub, err := b.Publisher()
+	if err != nil {
+		return err
+	}
+	return pub.Publish(topic, messages...)
+}
+
+// Subscribe subscribes to topic, the channel is closed with ctx or the bus
+func (b *Bus) Subscribe(ctx context.Context, topic string) (<-chan *common.Message, error) {
+	sub, err := b.Subscriber()
+	if err != nil {
+		return nil, err
+	}
+	return sub.Subscribe(ctx, topic)
+}
+
+// ListenOption configures Listen
+type ListenOption struct {
+	// From is the id of the message the listener starts at
+	From string
+}
+
+type ListenOpt func(*ListenOption)
+
+// ListenFrom starts the listener at the message id, on the drivers able to
+// resume (see driver.ResumeDriver). The others start at the end of the topic.
+func ListenFrom(id string) ListenOpt {
+	return func(o *ListenOption) {
+		o.From = id
+	}
+}
+
+// Listen subscribes to topic with a temporary group of its own, so the
+// listener receives every new message without taking them from the handlers.
+// The group is removed once ctx is done. The drivers without groups deliver
+// every message to every subscription, the subscriber of the bus is used.
+func (b *Bus) Listen(ctx context.Context, topic string, opts ...ListenOpt) (<-chan *common.Message, error) {
+	var opt ListenOption
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	var (
+		group = "listen_" + watermill.NewShortUUID()
+		sub   common.Subscriber
+		err   error
+	)
+	switch d := b.driver.(type) {
+	case driver.ResumeDriver:
+		if opt.From != "" {
+			sub, err = d.CreateResumeSubscriber(group, opt.From)
+		} else {
+			sub, err = d.CreateTemporarySubscriber(group)
+		}
+	case driver.TemporaryDriver:
+		sub, err = d.CreateTemporarySubscriber(group)
+	case driver.GroupDriver:
+		sub, err = d.CreateGroupSubscriber(group)
+	default:
+		return b.Subscribe(ctx, topic)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		sub.Close()
+		return nil, ErrBusClosed
+	}
+	if b.listeners == nil {
+		b.listeners = make(map[common.Subscriber]bool)
+	}
+	b.listeners[sub] = true
+	b.mu.Unlock()
+
+	msgs, err := sub.Subscribe(ctx, topic)
+	if err != nil {
+		b.unlisten(sub)
+		return nil, err
+	}
+
+	context.AfterFunc(ctx, func() { b.unlisten(sub) })
+	return msgs, nil
+}
+
+// unlisten closes sub unless the bus already did
+func (b *Bus) unlisten(sub common.Subscriber) {
+	b.mu.Lock()
+	var ok = b.listeners[sub]
+	delete(b.listeners, sub)
+	b.mu.Unlock()
+
+	if ok {
+		sub.Close()
+	}
+}
+
+// Close closes the router, the publisher, the subscriber and the driver when it's an io.Closer
+func (b *Bus) Close() error {
+	var errs []error
+
+	// the running handlers may still publish
+	b.rmu.Lock()
+	if b.r != nil && b.runCtx != nil {
+		errs = append(errs, b.r.Close())
+	}
+	b.rmu.Unlock()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return errors.Join(errs...)
+	}
+	b.closed = true
+
+	if b.pub != nil {
+		errs = append(errs, b.pub.Close())
+	}
+	if b.sub != nil {
+		errs = append(errs, b.sub.Close())
+	}
+	for _, sub := range b.groups {
+		errs = append(errs, sub.Close())
+	}
+	for sub := range b.listeners {
+		errs = append(errs, sub.Close())
+	}
+	b.listeners = nil
+	if c, ok := b.driver.(io.Closer); ok {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+var (
+	defaultBus     *Bus
+	defaultBusLock sync.Mutex
+)
+
+// DefaultBus returns the bus set by SetDefaultBus, a bus on driver.Global otherwise
+func DefaultBus() *Bus {
+	defaultBusLock.Lock()
+	defer defaultBusLock.Unlock()
+
+	if defaultBus == nil {
+		defaultBus = NewBus(driver.Global)
+	}
+	return defaultBus
+}
+
+// SetDefaultBus sets the bus of the package functions and of the routers without Bus
+func SetDefaultBus(b *Bus) {
+	defaultBusLock.Lock()
+	defer defaultBusLock.Unlock()
+
+	defaultBus = b
+}
+
+// Router returns the router of the bus, the handlers of On are added to it
+func (b *Bus) Router() *Router {
+	return b.router(nil)
+}
+
+// router returns the router of the bus, it's built with opts when there is none
+func (b *Bus) router(opts []RouterOpt) *Router {
+	b.rmu.Lock()
+	defer b.rmu.Unlock()
+
+	if b.r == nil {
+		b.r = NewRouter(append(b.opt.Router[:len(b.opt.Router):len(b.opt.Router)], opts...)...)
+		b.r.Bus = b
+	}
+	return b.r
+}
+
+// forget drops r, the router of the bus after r is stopped is a new one
+func (b *Bus) forget(r *Router) {
+	b.rmu.Lock()
+	defer b.rmu.Unlock()
+
+	if b.r == r {
+		b.r = nil
+		b.runCtx = nil
+	}
+}
+
+// Start runs the router of the bus in the background until ctx is done, it
+// returns once the router is running
+func (b *Bus) Start(ctx context.Context) (*Router, error) {
+	return b.start(ctx, nil)
+}
+
+func (b *Bus) start(ctx context.Context, opts []RouterOpt) (*Router, error) {
+	var r = b.router(opts)
+	if r.Ready() == nil {
+		return r, nil
+	}
+
+	var errc = make(chan error, 1)
+	go func() { errc <- b.Run(ctx) }()
+
+	select {
+	case <-r.Router.Running():
+		return r, nil
+	case err := <-errc:
+		if err == nil {
+			err = ErrNotRunning
+		}
+		return nil, err
+	}
+}
+
+// Run runs the router of the bus until ctx is done or the bus is closed
+func (b *Bus) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		r    = b.Router()
+		errc = make(chan error, 1)
+	)
+
+	b.rmu.Lock()
+	b.runCtx = ctx
+	b.rmu.Unlock()
+
+	go func() { errc <- r.Run(ctx) }()
+
+	select {
+	case <-r.Running():
+	case err := <-errc:
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		r.Close()
+		return <-errc
+	case err := <-errc:
+		return err
+	}
+}
+
+// Send encodes payload as JSON and publishes it to topic, the message carries ctx
+func (b *Bus) Send(ctx context.Context, topic string, payload interface{}) error {
+	msg, err := NewMessage(payload)
+	if err != nil {
+		return err
+	}
+	msg.SetContext(ctx)
+
+	return b.Publish(topic, msg)
+}
+
+// Send sends payload to topic on the DefaultBus
+func Send(ctx context.Context, topic string, payload interface{}) error {
+	return DefaultBus().Send(ctx, topic, payload)
+}
+
+// AddHandler adds the handler name of topic to the router of bus, it consumes
+// with the subscriber of group. The handler is started when the router is running.
+// It fails with ErrDuplicateHandler when name is already added.
+func (b *Bus) AddHandler(name, topic, group string, h message.NoPublishHandlerFunc) error {
+	var r = b.Router()
+
+	b.rmu.Lock()
+	defer b.rmu.Unlock()
+
+	if _, ok := r.Handlers()[name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateHandler, name)
+	}
+
+	sub, err := b.GroupSubscriber(group)
+	if err != nil {
+		return err
+	}
+
+	r.AddNoPublisherHandler(name, topic, sub, h)
+
+	if b.runCtx == nil {
+		return nil
+	}
+
+	// the handlers added to a running router have to be started
+	select {
+	case <-r.Running():
+		return r.RunHandlers(b.runCtx)
+	case <-b.runCtx.Done():
+		return b.runCtx.Err()
+	}
+}
+
+type OnOption struct {
+	// Name of the handler and of its group, <topic>.<T> when empty
+	Name string
+}
+
+type OnOpt func(*OnOption)
+
+// WithHandlerName names the handler and its group, the handlers of a topic with
+// the same payload type need different names
+func WithHandlerName(name string) OnOpt {
+	return func(o *OnOption) {
+		o.Name = name
+	}
+}
+
+// On adds a handler of topic to the router of bus, the payloads are decoded
+// from JSON into T. Payloads that can't be decoded are logged and dropped.
+// Every handler consumes with its own group named after the handler, so the
+// handlers of a topic all get every message. The name is kept across restarts,
+// a durable group resumes where it stopped; a second handler with the same
+// name fails with ErrDuplicateHandler.
+func On[T any](bus *Bus, topic string, fn func(ctx context.Context, v T) error, opts ...OnOpt) error {
+	var opt OnOption
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	var name = opt.Name
+	if name == "" {
+		name = fmt.Sprintf("%s.%s", topic, reflect.TypeOf((*T)(nil)).Elem())
+	}
+
+	return bus.AddHandler(name, topic, groupName(name), func(msg *common.Message) error {
+		var v T
+		if err := json.Unmarshal(msg.Payload, &v); err != nil {
+			bus.Router().Logger().Error("events: decode payload", err, watermill.LogFields{"handler": name, "message_uuid": msg.UUID})
+			return nil
+		}
+		return fn(msg.Context(), v)
+	})
+}
+
+// groupName keeps the letters, digits, - and _ of name, the brokers restrict
+// the characters of their queue and consumer names
+func groupName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,169 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/hysios/x/events/common"
+	"github.com/hysios/x/events/driver/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeDriver struct {
+	pubs, subs int
+	closed     bool
+	published  map[string][]*common.Message
+}
+
+func (d *fakeDriver) CreatePublisher() (common.Publisher, error) {
+	d.pubs++
+	return &fakePubSub{d: d}, nil
+}
+
+func (d *fakeDriver) CreateSubscriber() (common.Subscriber, error) {
+	d.subs++
+	return &fakePubSub{d: d}, nil
+}
+
+func (d *fakeDriver) Close() error {
+	d.closed = true
+	return nil
+}
+
+type fakePubSub struct {
+	d      *fakeDriver
+	closed bool
+}
+
+func (p *fakePubSub) Publish(topic string, messages ...*common.Message) error {
+	if p.d.published == nil {
+		p.d.published = make(map[string][]*common.Message)
+	}
+	p.d.published[topic] = append(p.d.published[topic], messages...)
+	return nil
+}
+
+func (p *fakePubSub) Subscribe(ctx context.Context, topic string) (<-chan *common.Message, error) {
+	return make(chan *common.Message), nil
+}
+
+func (p *fakePubSub) Close() error {
+	if p.closed {
+		return errors.New("closed twice")
+	}
+	p.closed = true
+	return nil
+}
+
+func TestBus(t *testing.T) {
+	var (
+		d1, d2 = &fakeDriver{}, &fakeDriver{}
+		b1, b2 = NewBus(d1), NewBus(d2)
+	)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, b1.Publish("orders", message.NewMessage(watermill.NewUUID(), []byte(`{}`))))
+	}
+	assert.NoError(t, b2.Publish("users", message.NewMessage(watermill.NewUUID(), []byte(`{}`))))
+	_, err := b1.Subscribe(context.Background(), "orders")
+	assert.NoError(t, err)
+	_, err = b1.Subscribe(context.Background(), "users")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, d1.pubs)
+	assert.Equal(t, 1, d1.subs)
+	assert.Len(t, d1.published["orders"], 3)
+	assert.Len(t, d2.published["users"], 1)
+	assert.Empty(t, d2.published["orders"])
+
+	pub, _ := b1.Publisher()
+	assert.NoError(t, b1.Close())
+	assert.NoError(t, b1.Close())
+	assert.True(t, pub.(*fakePubSub).closed)
+	assert.True(t, d1.closed)
+	assert.False(t, d2.closed)
+
+	assert.ErrorIs(t, b1.Publish("orders", message.NewMessage(watermill.NewUUID(), nil)), ErrBusClosed)
+}
+
+func TestBusPublisher(t *testing.T) {
+	var (
+		d     = &fakeDriver{}
+		count int
+		b     = NewBus(d, WithPublisher(func(pub common.Publisher) common.Publisher {
+			count++
+			return pub
+		}))
+	)
+
+	b.Publisher()
+	b.Publisher()
+	assert.Equal(t, 1, count)
+}
+
+func TestRouterBus(t *testing.T) {
+	var (
+		d = &fakeDriver{}
+		r = &Router{Bus: NewBus(d)}
+	)
+
+	assert.NoError(t, r.Publish("orders", message.NewMessage(watermill.NewUUID(), []byte(`{}`))))
+	assert.Len(t, d.published["orders"], 1)
+}
+
+type user struct {
+	Name string `json:"name"`
+}
+
+func TestSendOn(t *testing.T) {
+	var (
+		bus         = NewBus(memory.New(memory.DefaultConfig))
+		ctx, cancel = context.WithCancel(context.Background())
+		created     = make(chan user, 1)
+		deleted     = make(chan user, 1)
+		done        = make(chan error)
+	)
+	defer bus.Close()
+
+	assert.NoError(t, On(bus, "user.create", func(ctx context.Context, u user) error {
+		created <- u
+		return nil
+	}))
+
+	go func() { done <- bus.Run(ctx) }()
+	<-bus.Router().Running()
+
+	// handlers can be added to the running router
+	assert.NoError(t, On(bus, "user.delete", func(ctx context.Context, u user) error {
+		deleted <- u
+		return nil
+	}))
+
+	assert.NoError(t, bus.Publish("user.create", message.NewMessage(watermill.NewUUID(), []byte(`not json`))))
+	assert.NoError(t, bus.Send(ctx, "user.create", user{Name: "bob"}))
+	assert.NoError(t, bus.Send(ctx, "user.delete", &user{Name: "alice"}))
+	assert.Error(t, bus.Send(ctx, "user.create", make(chan int)))
+
+	for _, ch := range []chan user{created, deleted} {
+		select {
+		case u := <-ch:
+			assert.NotEmpty(t, u.Name)
+		case <-time.After(time.Second):
+			t.Fatal("handle timeout")
+		}
+	}
+	assert.Empty(t, created)
+
+	// the handlers are named after the topic and the payload type
+	assert.Contains(t, bus.Router().Handlers(), "user.create.events.user")
+	err := On(bus, "user.create", func(ctx context.Context, u user) error { return nil })
+	assert.ErrorIs(t, err, ErrDuplicateHandler)
+	assert.NoError(t, On(bus, "user.create", func(ctx context.Context, u user) error { return nil }, WithHandlerName("user.audit")))
+
+	cancel()
+	assert.NoError(t, <-done)
+}
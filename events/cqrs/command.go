@@ -0,0 +1,57 @@
+package cqrs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hysios/x/events"
+)
+
+// ErrDuplicateHandler is returned when a command already has a handler
+var ErrDuplicateHandler = errors.New("cqrs: duplicate command handler")
+
+// CommandBus sends commands to their handler
+type CommandBus struct {
+	bus *events.Bus
+	opt Option
+
+	mu       sync.Mutex
+	handlers map[string]bool
+}
+
+// NewCommandBus
+func NewCommandBus(bus *events.Bus, opts ...Opt) *CommandBus {
+	return &CommandBus{
+		bus:      bus,
+		opt:      newOption(opts),
+		handlers: make(map[string]bool),
+	}
+}
+
+// Send publishes cmd to the topic of its type
+func (c *CommandBus) Send(ctx context.Context, cmd interface{}) error {
+	var name = Name(cmd)
+	return publish(ctx, c.bus, c.opt.CommandTopic(name), name, cmd)
+}
+
+// HandleCommand sets the handler of the commands C. The instances of a service
+// share the command queue, every command is handled once.
+func HandleCommand[C any](c *CommandBus, fn func(ctx context.Context, cmd C) error) error {
+	var name = nameOf[C]()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.handlers[name] {
+		return fmt.Errorf("%w: %s", ErrDuplicateHandler, name)
+	}
+
+	var topic = c.opt.CommandTopic(name)
+	if err := c.bus.AddHandler("command."+name, topic, name, handler(c.bus, name, fn)); err != nil {
+		return err
+	}
+	c.handlers[name] = true
+	return nil
+}
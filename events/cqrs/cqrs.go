@@ -0,0 +1,117 @@
+// Package cqrs sends commands and publishes events with an events.Bus. Commands
+// are routed by their Go type name to exactly one handler, events are fanned
+// out to every handler, each handler consuming with its own queue group.
+//
+//	commands := cqrs.NewCommandBus(bus)
+//	cqrs.HandleCommand(commands, func(ctx context.Context, cmd CreateOrder) error { ... })
+//	commands.Send(ctx, CreateOrder{ID: 1})
+//
+// The handlers run on the router of the bus, with its middlewares.
+package cqrs
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	"github.com/hysios/x/events"
+	"github.com/hysios/x/events/common"
+)
+
+// NameHeader is the metadata carrying the name of a command or an event
+const NameHeader = "name"
+
+type Option struct {
+	// CommandTopic is the topic of the commands named name
+	CommandTopic func(name string) string
+	// EventTopic is the topic of the events named name
+	EventTopic func(name string) string
+}
+
+type Opt func(*Option)
+
+var DefaultOption = Option{
+	CommandTopic: func(name string) string { return "commands." + name },
+	EventTopic:   func(name string) string { return "events." + name },
+}
+
+// WithCommandTopic
+func WithCommandTopic(topic func(name string) string) Opt {
+	return func(o *Option) {
+		o.CommandTopic = topic
+	}
+}
+
+// WithEventTopic
+func WithEventTopic(topic func(name string) string) Opt {
+	return func(o *Option) {
+		o.EventTopic = topic
+	}
+}
+
+func newOption(opts []Opt) Option {
+	var opt = DefaultOption
+	for _, o := range opts {
+		o(&opt)
+	}
+	return opt
+}
+
+// Name returns the name of the type of v, like orders.CreateOrder. Pointers have the name of their element.
+func Name(v interface{}) string {
+	return typeName(reflect.TypeOf(v))
+}
+
+func typeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.String()
+}
+
+func nameOf[T any]() string {
+	return typeName(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+type correlationKey struct{}
+
+// CorrelationID returns the correlation id of the message being handled. The
+// commands and events sent with the handler ctx keep it.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// publish encodes v into a message of the correlation id of ctx
+func publish(ctx context.Context, bus *events.Bus, topic, name string, v interface{}) error {
+	msg, err := events.NewMessage(v)
+	if err != nil {
+		return err
+	}
+	msg.SetContext(ctx)
+	msg.Metadata.Set(NameHeader, name)
+
+	var id = CorrelationID(ctx)
+	if id == "" {
+		id = watermill.NewUUID()
+	}
+	middleware.SetCorrelationID(id, msg)
+
+	return bus.Publish(topic, msg)
+}
+
+// handler decodes the payloads into T, payloads that can't be decoded are logged and dropped
+func handler[T any](bus *events.Bus, name string, fn func(ctx context.Context, v T) error) func(msg *common.Message) error {
+	return func(msg *common.Message) error {
+		var v T
+		if err := json.Unmarshal(msg.Payload, &v); err != nil {
+			bus.Router().Logger().Error("cqrs: decode payload", err, watermill.LogFields{"handler": name, "message_uuid": msg.UUID})
+			return nil
+		}
+
+		var ctx = context.WithValue(msg.Context(), correlationKey{}, middleware.MessageCorrelationID(msg))
+		return fn(ctx, v)
+	}
+}
@@ -0,0 +1,82 @@
+package cqrs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hysios/x/events"
+	"github.com/hysios/x/events/driver/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+type CreateOrder struct {
+	ID int `json:"id"`
+}
+
+type OrderCreated struct {
+	ID int `json:"id"`
+}
+
+func TestName(t *testing.T) {
+	assert.Equal(t, "cqrs.CreateOrder", Name(CreateOrder{}))
+	assert.Equal(t, "cqrs.CreateOrder", Name(&CreateOrder{}))
+	assert.Equal(t, "cqrs.CreateOrder", nameOf[*CreateOrder]())
+}
+
+func TestCommandsAndEvents(t *testing.T) {
+	var (
+		bus         = events.NewBus(memory.New(memory.DefaultConfig))
+		commands    = NewCommandBus(bus)
+		evts        = NewEventBus(bus)
+		ctx, cancel = context.WithCancel(context.Background())
+		done        = make(chan error)
+		billing     = make(chan string, 1)
+		shipping    = make(chan string, 1)
+	)
+	defer bus.Close()
+
+	assert.NoError(t, HandleCommand(commands, func(ctx context.Context, cmd CreateOrder) error {
+		return evts.Publish(ctx, OrderCreated{ID: cmd.ID})
+	}))
+	assert.ErrorIs(t, HandleCommand(commands, func(ctx context.Context, cmd *CreateOrder) error {
+		return nil
+	}), ErrDuplicateHandler)
+
+	assert.NoError(t, OnEvent(evts, "billing", func(ctx context.Context, evt OrderCreated) error {
+		assert.Equal(t, 1, evt.ID)
+		billing <- CorrelationID(ctx)
+		return nil
+	}))
+	assert.NoError(t, OnEvent(evts, "shipping", func(ctx context.Context, evt *OrderCreated) error {
+		assert.Equal(t, 1, evt.ID)
+		shipping <- CorrelationID(ctx)
+		return nil
+	}))
+
+	go func() { done <- bus.Run(ctx) }()
+	<-bus.Router().Running()
+
+	assert.NoError(t, commands.Send(ctx, &CreateOrder{ID: 1}))
+
+	var ids []string
+	for _, ch := range []chan string{billing, shipping} {
+		select {
+		case id := <-ch:
+			ids = append(ids, id)
+		case <-time.After(time.Second):
+			t.Fatal("handle timeout")
+		}
+	}
+	assert.NotEmpty(t, ids[0])
+	assert.Equal(t, ids[0], ids[1])
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestTopics(t *testing.T) {
+	var opt = newOption([]Opt{WithCommandTopic(func(name string) string { return "cmd." + name })})
+	assert.Equal(t, "cmd.x", opt.CommandTopic("x"))
+	assert.Equal(t, "events.x", opt.EventTopic("x"))
+}
@@ -0,0 +1,34 @@
+package cqrs
+
+import (
+	"context"
+
+	"github.com/hysios/x/events"
+)
+
+// EventBus publishes events to their handlers
+type EventBus struct {
+	bus *events.Bus
+	opt Option
+}
+
+// NewEventBus
+func NewEventBus(bus *events.Bus, opts ...Opt) *EventBus {
+	return &EventBus{bus: bus, opt: newOption(opts)}
+}
+
+// Publish publishes evt to the topic of its type
+func (e *EventBus) Publish(ctx context.Context, evt interface{}) error {
+	var name = Name(evt)
+	return publish(ctx, e.bus, e.opt.EventTopic(name), name, evt)
+}
+
+// OnEvent adds the handler name of the events E. Every handler receives all the
+// events, its queue group is name so the instances of a service share them.
+func OnEvent[E any](e *EventBus, name string, fn func(ctx context.Context, evt E) error) error {
+	var (
+		event = nameOf[E]()
+		topic = e.opt.EventTopic(event)
+	)
+	return e.bus.AddHandler("event."+event+"."+name, topic, name, handler(e.bus, name, fn))
+}
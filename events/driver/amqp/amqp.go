@@ -9,44 +9,48 @@ import (
 	"github.com/hysios/x/events/driver"
 )
 
-type amqpDriver struct{}
+type amqpDriver struct {
+	cfg Config
+}
+
+// New creates a driver with cfg, the zero fields are taken from DefaultConfig
+func New(cfg Config) driver.Driver {
+	return &amqpDriver{cfg: mergeConfig(cfg)}
+}
 
 // CreatePublisher implements driver.Driver.
 func (a *amqpDriver) CreatePublisher() (common.Publisher, error) {
-	return amqp.NewPublisher(amqp.Config{
-		Connection: amqp.ConnectionConfig{
-			AmqpURI: DefaultConfig.URL,
-			Reconnect: &amqp.ReconnectConfig{
-				BackoffInitialInterval:     DefaultConfig.BackoffInitialInterval,
-				BackoffRandomizationFactor: DefaultConfig.BackoffRandomizationFactor,
-				BackoffMultiplier:          DefaultConfig.BackoffMultiplier,
-				BackoffMaxInterval:         DefaultConfig.BackoffMaxInterval,
-			},
-		},
-		Exchange: amqp.ExchangeConfig{
-			GenerateName: func(topic string) string {
-				return topic
-			},
-			Type:    "topic",
-			Durable: true,
-		},
-		Queue: amqp.QueueConfig{
-			Durable: true,
-		},
-		Marshaler: DefaultConfig.Marshaler,
-	}, watermill.NewStdLogger(false, false))
+	return amqp.NewPublisher(a.config(amqp.GenerateQueueNameTopicName), a.cfg.Logger)
 }
 
 // CreateSubscriber implements driver.Driver.
 func (a *amqpDriver) CreateSubscriber() (common.Subscriber, error) {
-	return amqp.NewSubscriber(amqp.Config{
+	return amqp.NewSubscriber(a.config(amqp.GenerateQueueNameTopicName), a.cfg.Logger)
+}
+
+// CreateGroupSubscriber implements driver.GroupDriver, every group has its own queue
+func (a *amqpDriver) CreateGroupSubscriber(group string) (common.Subscriber, error) {
+	return amqp.NewSubscriber(a.config(amqp.GenerateQueueNameTopicNameWithSuffix(group)), a.cfg.Logger)
+}
+
+// CreateTemporarySubscriber implements driver.TemporaryDriver, the queue of the
+// group isn't durable and is deleted with its last consumer
+func (a *amqpDriver) CreateTemporarySubscriber(group string) (common.Subscriber, error) {
+	var cfg = a.config(amqp.GenerateQueueNameTopicNameWithSuffix(group))
+	cfg.Queue.Durable = false
+	cfg.Queue.AutoDelete = true
+	return amqp.NewSubscriber(cfg, a.cfg.Logger)
+}
+
+func (a *amqpDriver) config(queue amqp.QueueNameGenerator) amqp.Config {
+	return amqp.Config{
 		Connection: amqp.ConnectionConfig{
-			AmqpURI: DefaultConfig.URL,
+			AmqpURI: a.cfg.URL,
 			Reconnect: &amqp.ReconnectConfig{
-				BackoffInitialInterval:     DefaultConfig.BackoffInitialInterval,
-				BackoffRandomizationFactor: DefaultConfig.BackoffRandomizationFactor,
-				BackoffMultiplier:          DefaultConfig.BackoffMultiplier,
-				BackoffMaxInterval:         DefaultConfig.BackoffMaxInterval,
+				BackoffInitialInterval:     a.cfg.BackoffInitialInterval,
+				BackoffRandomizationFactor: a.cfg.BackoffRandomizationFactor,
+				BackoffMultiplier:          a.cfg.BackoffMultiplier,
+				BackoffMaxInterval:         a.cfg.BackoffMaxInterval,
 			},
 		},
 		Exchange: amqp.ExchangeConfig{
@@ -57,10 +61,11 @@ func (a *amqpDriver) CreateSubscriber() (common.Subscriber, error) {
 			Durable: true,
 		},
 		Queue: amqp.QueueConfig{
-			Durable: true,
+			GenerateName: queue,
+			Durable:      true,
 		},
-		Marshaler: DefaultConfig.Marshaler,
-	}, watermill.NewStdLogger(false, false))
+		Marshaler: a.cfg.Marshaler,
+	}
 }
 
 type Config struct {
@@ -70,6 +75,7 @@ type Config struct {
 	BackoffRandomizationFactor float64
 	BackoffMultiplier          float64
 	BackoffMaxInterval         time.Duration
+	Logger                     watermill.LoggerAdapter
 }
 
 var DefaultConfig = Config{
@@ -79,10 +85,33 @@ var DefaultConfig = Config{
 	BackoffRandomizationFactor: 0.15,
 	BackoffMultiplier:          1.5,
 	BackoffMaxInterval:         5 * time.Second,
+	Logger:                     watermill.NewStdLogger(false, false),
 }
 
-var _ driver.Driver = &amqpDriver{}
-
-func init() {
-	driver.SetDriver(&amqpDriver{})
+func mergeConfig(cfg Config) Config {
+	var def = DefaultConfig
+	if cfg.URL == "" {
+		cfg.URL = def.URL
+	}
+	if cfg.Marshaler == nil {
+		cfg.Marshaler = def.Marshaler
+	}
+	if cfg.BackoffInitialInterval == 0 {
+		cfg.BackoffInitialInterval = def.BackoffInitialInterval
+	}
+	if cfg.BackoffRandomizationFactor == 0 {
+		cfg.BackoffRandomizationFactor = def.BackoffRandomizationFactor
+	}
+	if cfg.BackoffMultiplier == 0 {
+		cfg.BackoffMultiplier = def.BackoffMultiplier
+	}
+	if cfg.BackoffMaxInterval == 0 {
+		cfg.BackoffMaxInterval = def.BackoffMaxInterval
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = def.Logger
+	}
+	return cfg
 }
+
+var _ driver.TemporaryDriver = &amqpDriver{}
@@ -12,6 +12,8 @@ var (
 )
 
 // CreatePublisher creates a new Publisher.
+//
+// Deprecated: create a Bus with events.NewBus, it reuses its publisher
 func CreatePublisher() (common.Publisher, error) {
 	if publisher == nil {
 		return nil, errors.New("publisher is not set")
@@ -21,6 +23,8 @@ func CreatePublisher() (common.Publisher, error) {
 }
 
 // CreateSubscriber creates a new Subscriber.
+//
+// Deprecated: create a Bus with events.NewBus, it reuses its subscriber
 func CreateSubscriber() (common.Subscriber, error) {
 	if subscribe == nil {
 		return nil, errors.New("subscriber is not set")
@@ -29,21 +33,62 @@ func CreateSubscriber() (common.Subscriber, error) {
 	return subscribe()
 }
 
+// Deprecated: use events.NewBus
 func SetPublisher(fn func() (common.Publisher, error)) {
 	publisher = fn
 }
 
+// Deprecated: use events.NewBus
 func SetSubscriber(fn func() (common.Subscriber, error)) {
 	subscribe = fn
 }
 
+// SetDriver sets the driver of Global. The drivers no longer call it on import.
+//
+// Deprecated: use events.NewBus
 func SetDriver(d Driver) {
 	SetPublisher(d.CreatePublisher)
 	SetSubscriber(d.CreateSubscriber)
 }
 
+// Driver creates the publishers and subscribers of a broker. The driver is
+// closed with the bus when it implements io.Closer.
 type Driver interface {
 	CreateSubscriber() (common.Subscriber, error)
 	CreatePublisher() (common.Publisher, error)
-	// Close() error
+}
+
+// GroupDriver creates subscribers of a queue group, every group receives all
+// the messages of a topic and the subscribers of a group share them
+type GroupDriver interface {
+	Driver
+	CreateGroupSubscriber(group string) (common.Subscriber, error)
+}
+
+// TemporaryDriver creates subscribers of a group receiving the messages
+// published from now on, the group is removed once its subscribers are closed
+type TemporaryDriver interface {
+	GroupDriver
+	CreateTemporarySubscriber(group string) (common.Subscriber, error)
+}
+
+// ResumeDriver creates temporary subscribers starting at the message id of
+// their topic, a reconnecting listener receives the messages it missed. The
+// subscriber starts at the end of the topic when id isn't found.
+type ResumeDriver interface {
+	TemporaryDriver
+	CreateResumeSubscriber(group, id string) (common.Subscriber, error)
+}
+
+// Global is the driver set by SetDriver
+var Global Driver = global{}
+
+type global struct{}
+
+func (global) CreatePublisher() (common.Publisher, error) {
+	return CreatePublisher()
+}
+
+func (global) CreateSubscriber() (common.Subscriber, error) {
+	return CreateSubscriber()
 }
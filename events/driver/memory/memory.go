@@ -0,0 +1,71 @@
+// Package memory is an in-process events driver on watermill's gochannel,
+// meant for tests and single process deployments.
+package memory
+
+import (
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/hysios/x/events/common"
+	"github.com/hysios/x/events/driver"
+)
+
+type Config struct {
+	// OutputChannelBuffer is the buffer of every subscription channel
+	OutputChannelBuffer int64
+	// Persistent replays the previous messages of a topic to new subscribers
+	Persistent bool
+	// BlockingPublish blocks Publish until the subscribers acked the message,
+	// handy for deterministic tests
+	BlockingPublish bool
+	Logger          watermill.LoggerAdapter
+}
+
+var DefaultConfig = Config{
+	OutputChannelBuffer: 64,
+	Logger:              watermill.NopLogger{},
+}
+
+type memoryDriver struct {
+	pubsub *gochannel.GoChannel
+}
+
+// New creates a driver, the publisher and the subscribers share one gochannel
+func New(cfg Config) driver.Driver {
+	if cfg.Logger == nil {
+		cfg.Logger = DefaultConfig.Logger
+	}
+
+	return &memoryDriver{
+		pubsub: gochannel.NewGoChannel(gochannel.Config{
+			OutputChannelBuffer:            cfg.OutputChannelBuffer,
+			Persistent:                     cfg.Persistent,
+			BlockPublishUntilSubscriberAck: cfg.BlockingPublish,
+		}, cfg.Logger),
+	}
+}
+
+// CreatePublisher implements driver.Driver.
+func (m *memoryDriver) CreatePublisher() (common.Publisher, error) {
+	return shared{m.pubsub}, nil
+}
+
+// CreateSubscriber implements driver.Driver.
+func (m *memoryDriver) CreateSubscriber() (common.Subscriber, error) {
+	return shared{m.pubsub}, nil
+}
+
+// Close closes the gochannel, with the subscriptions
+func (m *memoryDriver) Close() error {
+	return m.pubsub.Close()
+}
+
+// shared leaves the gochannel open on Close, it's closed with the driver
+type shared struct {
+	*gochannel.GoChannel
+}
+
+func (shared) Close() error {
+	return nil
+}
+
+var _ driver.Driver = &memoryDriver{}
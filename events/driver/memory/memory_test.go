@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersistent(t *testing.T) {
+	var (
+		d        = New(Config{Persistent: true})
+		pub, _   = d.CreatePublisher()
+		sub, _   = d.CreateSubscriber()
+		ctx, end = context.WithCancel(context.Background())
+	)
+	defer end()
+
+	assert.NoError(t, pub.Publish("orders", message.NewMessage(watermill.NewUUID(), []byte("1"))))
+
+	msgs, err := sub.Subscribe(ctx, "orders")
+	assert.NoError(t, err)
+
+	select {
+	case msg := <-msgs:
+		assert.Equal(t, "1", string(msg.Payload))
+		msg.Ack()
+	case <-time.After(time.Second):
+		t.Fatal("receive timeout")
+	}
+
+	// closing the publisher keeps the subscriptions
+	assert.NoError(t, pub.Close())
+	assert.NoError(t, pub.Publish("orders", message.NewMessage(watermill.NewUUID(), []byte("2"))))
+
+	select {
+	case msg := <-msgs:
+		assert.Equal(t, "2", string(msg.Payload))
+		msg.Ack()
+	case <-time.After(time.Second):
+		t.Fatal("receive timeout")
+	}
+
+	assert.NoError(t, d.(*memoryDriver).Close())
+	_, ok := <-msgs
+	assert.False(t, ok)
+}
+
+func TestBlockingPublish(t *testing.T) {
+	var (
+		d        = New(Config{BlockingPublish: true})
+		pub, _   = d.CreatePublisher()
+		sub, _   = d.CreateSubscriber()
+		ctx, end = context.WithCancel(context.Background())
+		acked    = make(chan struct{})
+	)
+	defer end()
+
+	msgs, err := sub.Subscribe(ctx, "orders")
+	assert.NoError(t, err)
+
+	go func() {
+		msg := <-msgs
+		time.Sleep(20 * time.Millisecond)
+		close(acked)
+		msg.Ack()
+	}()
+
+	assert.NoError(t, pub.Publish("orders", message.NewMessage(watermill.NewUUID(), []byte("1"))))
+	select {
+	case <-acked:
+	default:
+		t.Fatal("publish returned before the ack")
+	}
+}
@@ -20,6 +20,7 @@ type Config struct {
 	StanOptions      []stan.Option
 	Marshaler        nats.Marshaler
 	Unmarshaler      nats.Unmarshaler
+	Logger           watermill.LoggerAdapter
 }
 
 type PublishConfig struct {
@@ -36,6 +37,7 @@ var (
 		AckWaitTimeout:   time.Second * 30,
 		Marshaler:        nats.JSONMarshaler{},
 		Unmarshaler:      nats.JSONMarshaler{},
+		Logger:           watermill.NewStdLogger(false, false),
 	}
 	DefaultPublicConfig = PublishConfig{
 		URL: DefaultURL,
@@ -43,70 +45,97 @@ var (
 )
 
 type natsDriver struct {
+	cfg Config
+}
+
+// New creates a driver with cfg, the zero fields are taken from DefaultConfig
+func New(cfg Config) driver.Driver {
+	return &natsDriver{cfg: mergeConfig(cfg)}
 }
 
 // CreatePublisher implements driver.Driver.
 func (n *natsDriver) CreatePublisher() (common.Publisher, error) {
-	subscribeOptions := []nc.SubOpt{
-		nc.DeliverAll(),
-		nc.AckExplicit(),
-	}
-
-	jsConfig := nats.JetStreamConfig{
-		Disabled:         false,
-		AutoProvision:    true,
-		ConnectOptions:   nil,
-		SubscribeOptions: subscribeOptions,
-		PublishOptions:   nil,
-		TrackMsgId:       false,
-		AckAsync:         false,
-		DurablePrefix:    "",
-	}
 	return nats.NewPublisher(
 		nats.PublisherConfig{
-			URL:       DefaultURL,
-			JetStream: jsConfig,
-			Marshaler: DefaultConfig.Marshaler,
-			// NatsOptions: DefaultPublicConfig.NatsOptions,
+			URL:       n.cfg.URL,
+			JetStream: jetStream(nc.DeliverAll()),
+			Marshaler: n.cfg.Marshaler,
 		},
-		watermill.NewStdLogger(false, false),
+		n.cfg.Logger,
 	)
 }
 
 // CreateSubscriber implements driver.Driver.
 func (n *natsDriver) CreateSubscriber() (common.Subscriber, error) {
-	subscribeOptions := []nc.SubOpt{
-		nc.DeliverAll(),
-		nc.AckExplicit(),
-	}
+	return n.subscriber(n.cfg.QueueGroupPrefix, nc.DeliverAll())
+}
 
-	jsConfig := nats.JetStreamConfig{
-		Disabled:         false,
-		AutoProvision:    true,
-		ConnectOptions:   nil,
-		SubscribeOptions: subscribeOptions,
-		PublishOptions:   nil,
-		TrackMsgId:       false,
-		AckAsync:         false,
-		DurablePrefix:    "",
-	}
+// CreateGroupSubscriber implements driver.GroupDriver, the group is the queue group prefix
+func (n *natsDriver) CreateGroupSubscriber(group string) (common.Subscriber, error) {
+	return n.subscriber(n.cfg.QueueGroupPrefix+"_"+group, nc.DeliverAll())
+}
 
+// CreateTemporarySubscriber implements driver.TemporaryDriver, the queue groups
+// aren't durable, the group only receives the new messages
+func (n *natsDriver) CreateTemporarySubscriber(group string) (common.Subscriber, error) {
+	return n.subscriber(n.cfg.QueueGroupPrefix+"_"+group, nc.DeliverNew())
+}
+
+func (n *natsDriver) subscriber(queueGroupPrefix string, deliver nc.SubOpt) (common.Subscriber, error) {
 	return nats.NewSubscriber(
 		nats.SubscriberConfig{
-			URL:              DefaultURL,
-			QueueGroupPrefix: DefaultConfig.QueueGroupPrefix,
-			SubscribersCount: DefaultConfig.SubscribersCount,
-			CloseTimeout:     DefaultConfig.CloseTimeout,
-			AckWaitTimeout:   DefaultConfig.AckWaitTimeout,
-			Unmarshaler:      DefaultConfig.Unmarshaler,
-			JetStream:        jsConfig,
+			URL:              n.cfg.URL,
+			QueueGroupPrefix: queueGroupPrefix,
+			SubscribersCount: n.cfg.SubscribersCount,
+			CloseTimeout:     n.cfg.CloseTimeout,
+			AckWaitTimeout:   n.cfg.AckWaitTimeout,
+			Unmarshaler:      n.cfg.Unmarshaler,
+			JetStream:        jetStream(deliver),
 		},
-		watermill.NewStdLogger(false, false),
+		n.cfg.Logger,
 	)
 }
 
-var _ driver.Driver = &natsDriver{}
+func jetStream(deliver nc.SubOpt) nats.JetStreamConfig {
+	return nats.JetStreamConfig{
+		Disabled:      false,
+		AutoProvision: true,
+		SubscribeOptions: []nc.SubOpt{
+			deliver,
+			nc.AckExplicit(),
+		},
+		TrackMsgId: false,
+		AckAsync:   false,
+	}
+}
 
-func init() {
-	driver.SetDriver(&natsDriver{})
+func mergeConfig(cfg Config) Config {
+	var def = DefaultConfig
+	if cfg.URL == "" {
+		cfg.URL = def.URL
+	}
+	if cfg.SubscribersCount == 0 {
+		cfg.SubscribersCount = def.SubscribersCount
+	}
+	if cfg.QueueGroupPrefix == "" {
+		cfg.QueueGroupPrefix = def.QueueGroupPrefix
+	}
+	if cfg.CloseTimeout == 0 {
+		cfg.CloseTimeout = def.CloseTimeout
+	}
+	if cfg.AckWaitTimeout == 0 {
+		cfg.AckWaitTimeout = def.AckWaitTimeout
+	}
+	if cfg.Marshaler == nil {
+		cfg.Marshaler = def.Marshaler
+	}
+	if cfg.Unmarshaler == nil {
+		cfg.Unmarshaler = def.Unmarshaler
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = def.Logger
+	}
+	return cfg
 }
+
+var _ driver.TemporaryDriver = &natsDriver{}
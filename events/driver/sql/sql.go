@@ -0,0 +1,544 @@
+// Package sql is an events driver storing the messages in a database with GORM,
+// for deployments without a broker. Subscribers poll the messages table, the
+// position of every consumer group on a topic is kept in the offsets table.
+//
+// Delivery is at least once. A consumer group reads a topic in order, the
+// next message is delivered once the previous one is acked, a nacked message
+// is delivered again after RetryInterval. A subscription takes a lease on the
+// offset of its group while it delivers a batch, so the subscribers of a group
+// don't process the same messages. The lease is renewed while the subscription
+// runs and taken over by another subscriber once it expires. No transaction is
+// held while a message is handled, the position is committed after every ack.
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/hysios/x/events/common"
+	"github.com/hysios/x/events/driver"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// MessagesTable is the table the messages are stored in
+	MessagesTable = "events_messages"
+	// OffsetsTable is the table the positions of the consumer groups are stored in
+	OffsetsTable = "events_offsets"
+)
+
+// ErrClosed is returned by a closed subscriber
+var ErrClosed = errors.New("sql: subscriber closed")
+
+// Message is a stored message
+type Message struct {
+	ID        uint64 `gorm:"primaryKey;autoIncrement"`
+	Topic     string `gorm:"size:191;index"`
+	UUID      string `gorm:"size:64;index"`
+	Metadata  []byte
+	Payload   []byte
+	CreatedAt time.Time
+}
+
+func (Message) TableName() string {
+	return MessagesTable
+}
+
+// Offset is the id of the last message a consumer group acked on a topic
+type Offset struct {
+	ConsumerGroup string `gorm:"size:191;primaryKey"`
+	Topic         string `gorm:"size:191;primaryKey"`
+	Position      uint64
+	// Owner is the subscription delivering the messages of the group until LeasedUntil
+	Owner       string `gorm:"size:64"`
+	LeasedUntil *time.Time
+	UpdatedAt   time.Time
+}
+
+func (Offset) TableName() string {
+	return OffsetsTable
+}
+
+type Config struct {
+	DB *gorm.DB
+	// ConsumerGroup of the subscribers of CreateSubscriber
+	ConsumerGroup string
+	// PollInterval is the wait between two polls of an idle topic
+	PollInterval time.Duration
+	// BatchSize is the max number of messages loaded per poll
+	BatchSize int
+	// RetryInterval is the wait before a nacked message is delivered again
+	RetryInterval time.Duration
+	// GapTimeout is how long a missing id is waited for, the transaction
+	// publishing it may commit after a message with a higher id
+	GapTimeout time.Duration
+	// Lease is how long the offset of a group is reserved to a subscription
+	// without renewal, the messages of a crashed subscriber wait that long
+	Lease time.Duration
+	// DisableMigrate skips the creation of the tables
+	DisableMigrate bool
+	Logger         watermill.LoggerAdapter
+}
+
+var DefaultConfig = Config{
+	ConsumerGroup: "default",
+	PollInterval:  time.Second,
+	BatchSize:     100,
+	RetryInterval: time.Second,
+	GapTimeout:    5 * time.Second,
+	Lease:         30 * time.Second,
+	Logger:        watermill.NopLogger{},
+}
+
+// Migrate creates the messages and offsets tables
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Message{}, &Offset{})
+}
+
+type sqlDriver struct {
+	cfg Config
+
+	once    sync.Once
+	migrate error
+}
+
+// New creates a driver on cfg.DB, the zero fields are taken from DefaultConfig
+func New(cfg Config) driver.Driver {
+	return &sqlDriver{cfg: mergeConfig(cfg)}
+}
+
+// CreatePublisher implements driver.Driver.
+func (d *sqlDriver) CreatePublisher() (common.Publisher, error) {
+	if err := d.init(); err != nil {
+		return nil, err
+	}
+	return &publisher{db: d.cfg.DB}, nil
+}
+
+// CreateSubscriber implements driver.Driver.
+func (d *sqlDriver) CreateSubscriber() (common.Subscriber, error) {
+	return d.CreateGroupSubscriber(d.cfg.ConsumerGroup)
+}
+
+// CreateGroupSubscriber implements driver.GroupDriver.
+func (d *sqlDriver) CreateGroupSubscriber(group string) (common.Subscriber, error) {
+	if err := d.init(); err != nil {
+		return nil, err
+	}
+	return newSubscriber(d.cfg, group, false), nil
+}
+
+// CreateTemporarySubscriber implements driver.TemporaryDriver, the group starts
+// at the end of the topics and its offsets are deleted on Close
+func (d *sqlDriver) CreateTemporarySubscriber(group string) (common.Subscriber, error) {
+	if err := d.init(); err != nil {
+		return nil, err
+	}
+	return newSubscriber(d.cfg, group, true), nil
+}
+
+// CreateResumeSubscriber implements driver.ResumeDriver, the temporary group
+// starts at the message id of the subscribed topic
+func (d *sqlDriver) CreateResumeSubscriber(group, id string) (common.Subscriber, error) {
+	if err := d.init(); err != nil {
+		return nil, err
+	}
+
+	var sub = newSubscriber(d.cfg, group, true)
+	sub.from = id
+	return sub, nil
+}
+
+func (d *sqlDriver) init() error {
+	if d.cfg.DB == nil {
+		return errors.New("sql: no DB")
+	}
+
+	d.once.Do(func() {
+		if !d.cfg.DisableMigrate {
+			d.migrate = Migrate(d.cfg.DB)
+		}
+	})
+	return d.migrate
+}
+
+func mergeConfig(cfg Config) Config {
+	var def = DefaultConfig
+	if cfg.ConsumerGroup == "" {
+		cfg.ConsumerGroup = def.ConsumerGroup
+	}
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = def.PollInterval
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = def.BatchSize
+	}
+	if cfg.RetryInterval == 0 {
+		cfg.RetryInterval = def.RetryInterval
+	}
+	if cfg.GapTimeout == 0 {
+		cfg.GapTimeout = def.GapTimeout
+	}
+	if cfg.Lease == 0 {
+		cfg.Lease = def.Lease
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = def.Logger
+	}
+	return cfg
+}
+
+type publisher struct {
+	db *gorm.DB
+}
+
+// Publish stores the messages in one transaction
+func (p *publisher) Publish(topic string, messages ...*common.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	var (
+		rows = make([]Message, len(messages))
+		now  = time.Now()
+	)
+	for i, msg := range messages {
+		md, err := json.Marshal(msg.Metadata)
+		if err != nil {
+			return err
+		}
+
+		rows[i] = Message{
+			Topic:     topic,
+			UUID:      msg.UUID,
+			Metadata:  md,
+			Payload:   msg.Payload,
+			CreatedAt: now,
+		}
+	}
+
+	return p.db.Create(&rows).Error
+}
+
+func (p *publisher) Close() error {
+	return nil
+}
+
+type subscriber struct {
+	cfg       Config
+	group     string
+	temporary bool
+	// from is the id of the message a temporary group starts at
+	from string
+
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	closing chan struct{}
+	closed  bool
+}
+
+func newSubscriber(cfg Config, group string, temporary bool) *subscriber {
+	return &subscriber{
+		cfg:       cfg,
+		group:     group,
+		temporary: temporary,
+		closing:   make(chan struct{}),
+	}
+}
+
+// Subscribe polls the messages of topic after the position of the group
+func (s *subscriber) Subscribe(ctx context.Context, topic string) (<-chan *common.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	var offset = Offset{ConsumerGroup: s.group, Topic: topic, UpdatedAt: time.Now()}
+	if s.temporary {
+		position, err := s.start(s.cfg.DB.WithContext(ctx), topic)
+		if err != nil {
+			return nil, err
+		}
+		offset.Position = position
+	}
+
+	if err := s.cfg.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&offset).Error; err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var out = make(chan *common.Message)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		select {
+		case <-s.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(out)
+		defer cancel()
+
+		s.consume(ctx, topic, out)
+	}()
+
+	return out, nil
+}
+
+// start returns the position of a temporary group, before the message from
+// when it's found, else the end of topic
+func (s *subscriber) start(db *gorm.DB, topic string) (uint64, error) {
+	if s.from != "" {
+		var rows []Message
+		if err := db.Select("id").
+			Where("topic = ? AND uuid = ?", topic, s.from).
+			Order("id").
+			Limit(1).
+			Find(&rows).Error; err != nil {
+			return 0, err
+		}
+		if len(rows) > 0 {
+			return rows[0].ID - 1, nil
+		}
+	}
+
+	var position uint64
+	err := db.Model(&Message{}).
+		Where("topic = ?", topic).
+		Select("COALESCE(MAX(id), 0)").
+		Scan(&position).Error
+	return position, err
+}
+
+func (s *subscriber) consume(ctx context.Context, topic string, out chan<- *common.Message) {
+	var owner = watermill.NewShortUUID()
+	for {
+		delivered, err := s.consumeBatch(ctx, topic, owner, out)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.cfg.Logger.Error("sql: consume", err, watermill.LogFields{"topic": topic, "consumer_group": s.group})
+		}
+
+		if delivered == 0 && !s.wait(ctx, s.cfg.PollInterval) {
+			return
+		}
+	}
+}
+
+// consumeBatch leases the offset of the group and delivers the next messages,
+// the position is committed after every ack. Nothing is delivered while
+// another subscriber of the group holds the lease.
+func (s *subscriber) consumeBatch(ctx context.Context, topic, owner string, out chan<- *common.Message) (delivered int, err error) {
+	position, ok, err := s.lease(ctx, topic, owner)
+	if err != nil || !ok {
+		return 0, err
+	}
+
+	// the lease is renewed until the batch ends, the batch stops when it's lost
+	bctx, cancel := context.WithCancel(ctx)
+	var renewed = make(chan struct{})
+	go func() {
+		defer close(renewed)
+		s.renew(bctx, cancel, topic, owner)
+	}()
+	defer func() {
+		cancel()
+		<-renewed
+		s.release(topic, owner)
+	}()
+
+	var rows []Message
+	if err := s.cfg.DB.WithContext(bctx).
+		Where("topic = ? AND id > ?", topic, position).
+		Order("id").
+		Limit(s.cfg.BatchSize).
+		Find(&rows).Error; err != nil {
+		return 0, err
+	}
+
+	for _, row := range rows {
+		settled, err := s.settled(s.cfg.DB.WithContext(bctx), position, row)
+		if err != nil || !settled {
+			return delivered, err
+		}
+
+		if !s.deliver(ctx, bctx, row, out) {
+			return delivered, nil
+		}
+		if err := s.commit(topic, owner, row.ID); err != nil {
+			return delivered, err
+		}
+		position = row.ID
+		delivered++
+	}
+	return delivered, nil
+}
+
+// lease reserves the offset of the group on topic to owner, it reports false
+// while another subscription holds an unexpired lease
+func (s *subscriber) lease(ctx context.Context, topic, owner string) (position uint64, ok bool, err error) {
+	var (
+		now   = time.Now()
+		until = now.Add(s.cfg.Lease)
+		db    = s.cfg.DB.WithContext(ctx)
+	)
+
+	res := db.Model(&Offset{}).
+		Where("consumer_group = ? AND topic = ? AND (owner = ? OR leased_until IS NULL OR leased_until < ?)", s.group, topic, owner, now).
+		Updates(map[string]interface{}{"owner": owner, "leased_until": until})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return 0, false, res.Error
+	}
+
+	var offset Offset
+	if err := db.Where("consumer_group = ? AND topic = ?", s.group, topic).Take(&offset).Error; err != nil {
+		return 0, false, err
+	}
+	return offset.Position, true, nil
+}
+
+// renew extends the lease of owner every third of Lease until ctx is done, it
+// calls lost when the lease was taken over
+func (s *subscriber) renew(ctx context.Context, lost context.CancelFunc, topic, owner string) {
+	var ticker = time.NewTicker(s.cfg.Lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		res := s.cfg.DB.WithContext(ctx).Model(&Offset{}).
+			Where("consumer_group = ? AND topic = ? AND owner = ?", s.group, topic, owner).
+			Update("leased_until", time.Now().Add(s.cfg.Lease))
+		if res.Error == nil && res.RowsAffected == 0 {
+			s.cfg.Logger.Info("sql: lease lost", watermill.LogFields{"topic": topic, "consumer_group": s.group})
+			lost()
+			return
+		}
+	}
+}
+
+// commit moves the position of the group to id unless owner lost the lease
+func (s *subscriber) commit(topic, owner string, id uint64) error {
+	res := s.cfg.DB.Model(&Offset{}).
+		Where("consumer_group = ? AND topic = ? AND owner = ?", s.group, topic, owner).
+		Updates(map[string]interface{}{"position": id, "leased_until": time.Now().Add(s.cfg.Lease)})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("sql: lease of %s on %s lost before committing %d", s.group, topic, id)
+	}
+	return nil
+}
+
+// release ends the lease of owner, another subscriber may take the offset over
+func (s *subscriber) release(topic, owner string) {
+	if err := s.cfg.DB.Model(&Offset{}).
+		Where("consumer_group = ? AND topic = ? AND owner = ?", s.group, topic, owner).
+		Update("leased_until", nil).Error; err != nil {
+		s.cfg.Logger.Error("sql: release lease", err, watermill.LogFields{"topic": topic, "consumer_group": s.group})
+	}
+}
+
+// settled reports whether the ids between position and row are all committed,
+// or missing for longer than GapTimeout. A message committing after one with a
+// higher id of its topic would be skipped otherwise.
+func (s *subscriber) settled(db *gorm.DB, position uint64, row Message) (bool, error) {
+	if row.ID == position+1 || time.Since(row.CreatedAt) > s.cfg.GapTimeout {
+		return true, nil
+	}
+
+	var count int64
+	if err := db.Model(&Message{}).Where("id > ? AND id < ?", position, row.ID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return uint64(count) == row.ID-position-1, nil
+}
+
+// deliver sends row until it's acked, it returns false once batch is done. The
+// message carries ctx, the context of the subscription.
+func (s *subscriber) deliver(ctx, batch context.Context, row Message, out chan<- *common.Message) bool {
+	for {
+		var msg = message.NewMessage(row.UUID, row.Payload)
+		if len(row.Metadata) > 0 {
+			if err := json.Unmarshal(row.Metadata, &msg.Metadata); err != nil {
+				// delivered without metadata rather than blocking the topic
+				s.cfg.Logger.Error("sql: decode metadata", err, watermill.LogFields{"message_uuid": row.UUID, "id": row.ID})
+				msg.Metadata = make(message.Metadata)
+			}
+		}
+		msg.SetContext(ctx)
+
+		select {
+		case out <- msg:
+		case <-batch.Done():
+			return false
+		}
+
+		select {
+		case <-msg.Acked():
+			return true
+		case <-msg.Nacked():
+			if !s.wait(batch, s.cfg.RetryInterval) {
+				return false
+			}
+		case <-batch.Done():
+			return false
+		}
+	}
+}
+
+func (s *subscriber) wait(ctx context.Context, d time.Duration) bool {
+	var t = time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Close stops the subscriptions and waits for them, a temporary group is deleted
+func (s *subscriber) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.closing)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	if s.temporary {
+		return s.cfg.DB.Where("consumer_group = ?", s.group).Delete(&Offset{}).Error
+	}
+	return nil
+}
+
+var _ driver.TemporaryDriver = &sqlDriver{}
@@ -0,0 +1,323 @@
+package sql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/glebarez/sqlite"
+	"github.com/hysios/x/events"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func testDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite memory failed: %v", err)
+	}
+
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	return db
+}
+
+func receive(t *testing.T, msgs <-chan *message.Message) *message.Message {
+	t.Helper()
+
+	select {
+	case msg := <-msgs:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("receive timeout")
+		return nil
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	var (
+		db          = testDB(t)
+		d           = New(Config{DB: db, PollInterval: 5 * time.Millisecond, RetryInterval: time.Millisecond})
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer cancel()
+
+	pub, err := d.CreatePublisher()
+	assert.NoError(t, err)
+	sub, err := d.CreateSubscriber()
+	assert.NoError(t, err)
+	billing, err := d.(*sqlDriver).CreateGroupSubscriber("billing")
+	assert.NoError(t, err)
+
+	var msg = message.NewMessage(watermill.NewUUID(), []byte("1"))
+	msg.Metadata.Set("k", "v")
+	assert.NoError(t, pub.Publish("orders", msg, message.NewMessage(watermill.NewUUID(), []byte("2"))))
+	assert.NoError(t, pub.Publish("users", message.NewMessage(watermill.NewUUID(), []byte("x"))))
+
+	msgs, err := sub.Subscribe(ctx, "orders")
+	assert.NoError(t, err)
+
+	got := receive(t, msgs)
+	assert.Equal(t, msg.UUID, got.UUID)
+	assert.Equal(t, "v", got.Metadata.Get("k"))
+	got.Nack()
+
+	// nacked messages come back before the next ones
+	got = receive(t, msgs)
+	assert.Equal(t, "1", string(got.Payload))
+	got.Ack()
+
+	got = receive(t, msgs)
+	assert.Equal(t, "2", string(got.Payload))
+	got.Ack()
+
+	// every group reads the whole topic
+	other, err := billing.Subscribe(ctx, "orders")
+	assert.NoError(t, err)
+	got = receive(t, other)
+	assert.Equal(t, "1", string(got.Payload))
+	got.Ack()
+	got = receive(t, other)
+	assert.Equal(t, "2", string(got.Payload))
+	got.Ack()
+	// a subscriber waiting for an ack holds the only connection of the test db
+	assert.NoError(t, billing.Close())
+
+	assert.Eventually(t, func() bool {
+		var offset Offset
+		db.Where("consumer_group = ? AND topic = ?", "default", "orders").First(&offset)
+		return offset.Position == 2
+	}, time.Second, 5*time.Millisecond)
+
+	assert.NoError(t, sub.Close())
+	_, ok := <-msgs
+	assert.False(t, ok)
+
+	// a new subscriber resumes after the acked position
+	sub, _ = d.CreateSubscriber()
+	defer sub.Close()
+	assert.NoError(t, pub.Publish("orders", message.NewMessage(watermill.NewUUID(), []byte("3"))))
+	msgs, _ = sub.Subscribe(ctx, "orders")
+	got = receive(t, msgs)
+	assert.Equal(t, "3", string(got.Payload))
+	got.Ack()
+}
+
+func TestCompetingSubscribers(t *testing.T) {
+	var (
+		d           = New(Config{DB: testDB(t), PollInterval: 5 * time.Millisecond})
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer cancel()
+
+	pub, _ := d.CreatePublisher()
+	a, _ := d.CreateSubscriber()
+	b, _ := d.CreateSubscriber()
+	defer a.Close()
+	defer b.Close()
+
+	assert.NoError(t, pub.Publish("orders", message.NewMessage(watermill.NewUUID(), []byte("1"))))
+
+	msgsA, _ := a.Subscribe(ctx, "orders")
+	msgsB, _ := b.Subscribe(ctx, "orders")
+
+	// the group offset is claimed by the first subscriber until the ack
+	var got *message.Message
+	select {
+	case got = <-msgsA:
+	case got = <-msgsB:
+	case <-time.After(time.Second):
+		t.Fatal("receive timeout")
+	}
+	time.Sleep(20 * time.Millisecond)
+	got.Ack()
+
+	select {
+	case dup := <-msgsA:
+		t.Fatalf("delivered twice: %s", dup.Payload)
+	case dup := <-msgsB:
+		t.Fatalf("delivered twice: %s", dup.Payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestGap(t *testing.T) {
+	var (
+		db          = testDB(t)
+		d           = New(Config{DB: db, PollInterval: 5 * time.Millisecond, GapTimeout: 200 * time.Millisecond})
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer cancel()
+
+	sub, _ := d.CreateSubscriber()
+	defer sub.Close()
+	msgs, err := sub.Subscribe(ctx, "orders")
+	assert.NoError(t, err)
+
+	// id 2 is committed before id 1
+	assert.NoError(t, db.Create(&Message{ID: 2, Topic: "orders", Payload: []byte("2"), CreatedAt: time.Now()}).Error)
+	select {
+	case msg := <-msgs:
+		t.Fatalf("delivered over the gap: %s", msg.Payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.NoError(t, db.Create(&Message{ID: 1, Topic: "orders", Payload: []byte("1"), Metadata: []byte("bad"), CreatedAt: time.Now()}).Error)
+	for _, want := range []string{"1", "2"} {
+		got := receive(t, msgs)
+		assert.Equal(t, want, string(got.Payload))
+		got.Ack()
+	}
+
+	// a gap older than GapTimeout is skipped
+	assert.NoError(t, db.Create(&Message{ID: 5, Topic: "orders", Payload: []byte("5"), CreatedAt: time.Now().Add(-time.Second)}).Error)
+	got := receive(t, msgs)
+	assert.Equal(t, "5", string(got.Payload))
+	got.Ack()
+}
+
+func TestRouter(t *testing.T) {
+	var (
+		bus = events.NewBus(New(Config{DB: testDB(t), PollInterval: 5 * time.Millisecond}),
+			events.WithRouterOptions(events.WithSignals(false)))
+		ctx, cancel = context.WithCancel(context.Background())
+		got         = make(chan int, 1)
+		audit       = make(chan int, 1)
+	)
+	defer bus.Close()
+	defer cancel()
+
+	assert.NoError(t, events.On(bus, "numbers", func(ctx context.Context, n int) error {
+		got <- n
+		return nil
+	}))
+	// every handler has its own group, they don't compete
+	assert.NoError(t, events.On(bus, "numbers", func(ctx context.Context, n int) error {
+		audit <- n
+		return nil
+	}, events.WithHandlerName("numbers.audit")))
+
+	_, err := bus.Start(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, bus.Send(ctx, "numbers", 42))
+
+	for _, ch := range []chan int{got, audit} {
+		select {
+		case n := <-ch:
+			assert.Equal(t, 42, n)
+		case <-time.After(time.Second):
+			t.Fatal("handle timeout")
+		}
+	}
+}
+
+func TestListen(t *testing.T) {
+	var (
+		db          = testDB(t)
+		bus         = events.NewBus(New(Config{DB: db, PollInterval: 5 * time.Millisecond}))
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer bus.Close()
+	defer cancel()
+
+	var first = message.NewMessage(watermill.NewUUID(), []byte("1"))
+	assert.NoError(t, bus.Publish("orders", first))
+
+	// the listener starts at the end of the topic
+	lctx, stop := context.WithCancel(ctx)
+	listen, err := bus.Listen(lctx, "orders")
+	assert.NoError(t, err)
+	assert.NoError(t, bus.Publish("orders", message.NewMessage(watermill.NewUUID(), []byte("2"))))
+	got := receive(t, listen)
+	assert.Equal(t, "2", string(got.Payload))
+	got.Ack()
+
+	// and takes nothing from the default group
+	msgs, err := bus.Subscribe(ctx, "orders")
+	assert.NoError(t, err)
+	for _, want := range []string{"1", "2"} {
+		got := receive(t, msgs)
+		assert.Equal(t, want, string(got.Payload))
+		got.Ack()
+	}
+
+	// its group is deleted once ctx is done
+	stop()
+	assert.Eventually(t, func() bool {
+		var count int64
+		db.Model(&Offset{}).Where("consumer_group LIKE ?", "listen_%").Count(&count)
+		return count == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// a resumed listener starts at the message, the end of the topic when it's unknown
+	for from, want := range map[string]string{first.UUID: "1", "gone": "3"} {
+		lctx, stop := context.WithCancel(ctx)
+		listen, err := bus.Listen(lctx, "orders", events.ListenFrom(from))
+		assert.NoError(t, err)
+		assert.NoError(t, bus.Publish("orders", message.NewMessage(watermill.NewUUID(), []byte("3"))))
+		got := receive(t, listen)
+		assert.Equal(t, want, string(got.Payload))
+		got.Ack()
+		stop()
+	}
+}
+
+func TestHandlerPublishes(t *testing.T) {
+	var (
+		bus = events.NewBus(New(Config{DB: testDB(t), PollInterval: 5 * time.Millisecond}),
+			events.WithRouterOptions(events.WithSignals(false)))
+		ctx, cancel = context.WithCancel(context.Background())
+		got         = make(chan int, 1)
+	)
+	defer bus.Close()
+	defer cancel()
+
+	// no transaction is held while handling, the single connection is free
+	assert.NoError(t, events.On(bus, "numbers", func(ctx context.Context, n int) error {
+		return bus.Send(ctx, "doubled", n*2)
+	}))
+	assert.NoError(t, events.On(bus, "doubled", func(ctx context.Context, n int) error {
+		got <- n
+		return nil
+	}))
+
+	_, err := bus.Start(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, bus.Send(ctx, "numbers", 21))
+
+	select {
+	case n := <-got:
+		assert.Equal(t, 42, n)
+	case <-time.After(time.Second):
+		t.Fatal("handle timeout")
+	}
+}
+
+func TestLeaseExpired(t *testing.T) {
+	var (
+		db          = testDB(t)
+		d           = New(Config{DB: db, PollInterval: 5 * time.Millisecond})
+		ctx, cancel = context.WithCancel(context.Background())
+		past        = time.Now().Add(-time.Second)
+	)
+	defer cancel()
+
+	pub, _ := d.CreatePublisher()
+	sub, _ := d.CreateSubscriber()
+	defer sub.Close()
+	assert.NoError(t, pub.Publish("orders", message.NewMessage(watermill.NewUUID(), []byte("1"))))
+
+	// the lease of a crashed subscriber is taken over once expired
+	assert.NoError(t, db.Create(&Offset{ConsumerGroup: DefaultConfig.ConsumerGroup, Topic: "orders", Owner: "crashed", LeasedUntil: &past}).Error)
+	msgs, err := sub.Subscribe(ctx, "orders")
+	assert.NoError(t, err)
+	receive(t, msgs).Ack()
+
+	assert.Eventually(t, func() bool {
+		var offset Offset
+		db.Where("topic = ?", "orders").Take(&offset)
+		return offset.Position == 1 && offset.Owner != "crashed"
+	}, time.Second, 5*time.Millisecond)
+}
@@ -2,13 +2,18 @@ package events
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/hysios/x/events/common"
 )
 
-func NewMessage(payload interface{}) *common.Message {
-	b, _ := json.Marshal(payload)
-	return message.NewMessage(watermill.NewUUID(), b)
+// NewMessage encodes payload as JSON into a new message
+func NewMessage(payload interface{}) (*common.Message, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("events: encode payload: %w", err)
+	}
+	return message.NewMessage(watermill.NewUUID(), b), nil
 }
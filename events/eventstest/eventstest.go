@@ -0,0 +1,177 @@
+// Package eventstest helps testing code that publishes events.
+//
+//	bus := eventstest.NewBus(t)
+//	e := eventstest.Expect(t, "user.create")
+//	repo.Create(user)
+//	e.Within(time.Second).Payload(user)
+package eventstest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hysios/x/events"
+	"github.com/hysios/x/events/common"
+	"github.com/hysios/x/events/driver/memory"
+)
+
+// DefaultWithin is how long an expectation waits by default
+var DefaultWithin = time.Second
+
+// NewBus returns a bus on a persistent memory driver, it is the events.DefaultBus
+// until the end of the test
+func NewBus(t testing.TB) *events.Bus {
+	var cfg = memory.DefaultConfig
+	cfg.Persistent = true
+
+	var (
+		bus  = events.NewBus(memory.New(cfg))
+		prev = events.DefaultBus()
+	)
+	events.SetDefaultBus(bus)
+	t.Cleanup(func() {
+		events.SetDefaultBus(prev)
+		bus.Close()
+	})
+	return bus
+}
+
+// Expectation waits for the messages of a topic
+type Expectation struct {
+	t      testing.TB
+	topic  string
+	within time.Duration
+	msgs   <-chan *common.Message
+}
+
+// Expect subscribes to topic on the events.DefaultBus
+func Expect(t testing.TB, topic string) *Expectation {
+	t.Helper()
+	return ExpectOn(t, events.DefaultBus(), topic)
+}
+
+// ExpectOn subscribes to topic on bus, the subscription ends with the test
+func ExpectOn(t testing.TB, bus *events.Bus, topic string) *Expectation {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	msgs, err := bus.Subscribe(ctx, topic)
+	if err != nil {
+		t.Fatalf("eventstest: subscribe %s: %v", topic, err)
+	}
+
+	return &Expectation{t: t, topic: topic, within: DefaultWithin, msgs: msgs}
+}
+
+// Within sets how long to wait for the messages
+func (e *Expectation) Within(d time.Duration) *Expectation {
+	e.within = d
+	return e
+}
+
+// Message waits for the next message
+func (e *Expectation) Message() *common.Message {
+	e.t.Helper()
+
+	msg, ok := e.next(time.After(e.within))
+	if !ok {
+		e.t.Fatalf("eventstest: no message on %s within %s", e.topic, e.within)
+	}
+	return msg
+}
+
+// Count waits for n messages
+func (e *Expectation) Count(n int) []*common.Message {
+	e.t.Helper()
+
+	var (
+		timeout = time.After(e.within)
+		msgs    = make([]*common.Message, 0, n)
+	)
+	for len(msgs) < n {
+		msg, ok := e.next(timeout)
+		if !ok {
+			e.t.Fatalf("eventstest: %d of %d messages on %s within %s", len(msgs), n, e.topic, e.within)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// None checks no message arrives for the within duration
+func (e *Expectation) None() {
+	e.t.Helper()
+
+	if msg, ok := e.next(time.After(e.within)); ok {
+		e.t.Fatalf("eventstest: unexpected message on %s: %s", e.topic, msg.Payload)
+	}
+}
+
+// Payload waits for a message whose JSON payload equals v. v is compared as is
+// when it's []byte or json.RawMessage, otherwise it's encoded to JSON first.
+func (e *Expectation) Payload(v interface{}) *common.Message {
+	e.t.Helper()
+
+	want, err := normalize(v)
+	if err != nil {
+		e.t.Fatalf("eventstest: encode expected payload: %v", err)
+	}
+
+	var (
+		timeout = time.After(e.within)
+		seen    [][]byte
+	)
+	for {
+		msg, ok := e.next(timeout)
+		if !ok {
+			e.t.Fatalf("eventstest: no payload %s on %s within %s, got %s", want, e.topic, e.within, bytes.Join(seen, []byte(", ")))
+			return nil
+		}
+
+		if got, err := normalize(json.RawMessage(msg.Payload)); err == nil && bytes.Equal(got, want) {
+			return msg
+		}
+		seen = append(seen, msg.Payload)
+	}
+}
+
+func (e *Expectation) next(timeout <-chan time.Time) (*common.Message, bool) {
+	select {
+	case msg, ok := <-e.msgs:
+		if !ok {
+			return nil, false
+		}
+		msg.Ack()
+		return msg, true
+	case <-timeout:
+		return nil, false
+	}
+}
+
+// normalize returns the canonical JSON of v, objects keys are sorted
+func normalize(v interface{}) ([]byte, error) {
+	var data []byte
+	switch x := v.(type) {
+	case json.RawMessage:
+		data = x
+	case []byte:
+		data = x
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		data = b
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return json.Marshal(decoded)
+}
@@ -0,0 +1,75 @@
+package eventstest
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/hysios/x/events"
+	"github.com/stretchr/testify/assert"
+)
+
+type user struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestExpect(t *testing.T) {
+	var bus = NewBus(t)
+	assert.Same(t, bus, events.DefaultBus())
+
+	var e = Expect(t, "user.create")
+	publish(t, "user.create", `{"name":"bob","age":3}`)
+	publish(t, "user.create", `{"age":4,"name":"alice"}`)
+
+	msg := e.Within(time.Second).Payload(user{Name: "alice", Age: 4})
+	assert.NotNil(t, msg)
+
+	// persistent bus, later expectations see the previous messages
+	msgs := Expect(t, "user.create").Count(2)
+	assert.Len(t, msgs, 2)
+
+	Expect(t, "user.delete").Within(20 * time.Millisecond).None()
+	Expect(t, "user.create").Payload([]byte(`{"name": "bob", "age": 3}`))
+}
+
+func TestExpectFails(t *testing.T) {
+	NewBus(t)
+	publish(t, "user.create", `{"name":"bob"}`)
+
+	var ft = &fakeT{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Expect(ft, "user.create").Within(20 * time.Millisecond).Payload(user{Name: "alice"})
+	}()
+	<-done
+
+	assert.True(t, ft.failed)
+	assert.Contains(t, ft.msg, `got {"name":"bob"}`)
+}
+
+func publish(t *testing.T, topic, payload string) {
+	var msg = message.NewMessage(watermill.NewUUID(), []byte(payload))
+	msg.SetContext(context.Background())
+	assert.NoError(t, events.DefaultBus().Publish(topic, msg))
+}
+
+// fakeT records the fatal message and stops the goroutine like testing.T does
+type fakeT struct {
+	testing.TB
+	failed bool
+	msg    string
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Fatalf(format string, args ...interface{}) {
+	f.failed = true
+	f.msg = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
@@ -0,0 +1,241 @@
+// Package httpbridge streams the messages of events topics to browsers, as
+// Server-Sent Events or WebSocket frames.
+//
+//	bridge := httpbridge.New(router)
+//	http.Handle("/events/orders", bridge.SSE("orders"))
+//	http.Handle("/ws/orders", bridge.WebSocket("orders"))
+//
+// Every connection listens with a temporary group of its own (see
+// events.Bus.Listen), it receives the messages without taking them from the
+// handlers of the service. A reconnecting client resumes after its last event
+// id on the drivers able to (see driver.ResumeDriver) and on the drivers
+// replaying the topics, the others only stream the new messages. The query
+// parameters meta.<key>=<value> only stream the messages with that metadata.
+package httpbridge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hysios/x/events"
+	"github.com/hysios/x/events/common"
+)
+
+// LastEventIDHeader is sent by the browsers reconnecting to an SSE stream
+const LastEventIDHeader = "Last-Event-ID"
+
+// MetaPrefix is the prefix of the query parameters filtering on metadata
+const MetaPrefix = "meta."
+
+type Option struct {
+	// Heartbeat is the interval of the keep alive frames
+	Heartbeat time.Duration
+	// Buffer is the number of messages waiting for a client, a client falling behind is dropped
+	Buffer int
+	// Filter decides which messages are streamed to the client of r, on top of the metadata filters
+	Filter func(r *http.Request, msg *common.Message) bool
+	// ResumeTimeout is how long the last event id of a reconnecting client is
+	// looked for, the messages held meanwhile are sent when it isn't found
+	ResumeTimeout time.Duration
+}
+
+type Opt func(*Option)
+
+var DefaultOption = Option{
+	Heartbeat:     15 * time.Second,
+	Buffer:        64,
+	ResumeTimeout: time.Second,
+}
+
+// WithHeartbeat
+func WithHeartbeat(d time.Duration) Opt {
+	return func(o *Option) {
+		o.Heartbeat = d
+	}
+}
+
+// WithBuffer
+func WithBuffer(n int) Opt {
+	return func(o *Option) {
+		o.Buffer = n
+	}
+}
+
+// WithFilter
+func WithFilter(filter func(r *http.Request, msg *common.Message) bool) Opt {
+	return func(o *Option) {
+		o.Filter = filter
+	}
+}
+
+// WithResumeTimeout
+func WithResumeTimeout(d time.Duration) Opt {
+	return func(o *Option) {
+		o.ResumeTimeout = d
+	}
+}
+
+// Bridge serves the topics of a router over HTTP
+type Bridge struct {
+	router *events.Router
+	opt    Option
+}
+
+// New
+func New(router *events.Router, opts ...Opt) *Bridge {
+	var opt = DefaultOption
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return &Bridge{router: router, opt: opt}
+}
+
+// Frame is a message as sent to the WebSocket clients
+type Frame struct {
+	// Type is message or heartbeat
+	Type     string            `json:"type"`
+	ID       string            `json:"id,omitempty"`
+	Topic    string            `json:"topic,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Payload is embedded as is when it's JSON, otherwise as a string
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+func newFrame(topic string, msg *common.Message) Frame {
+	var payload = json.RawMessage(msg.Payload)
+	if !json.Valid(msg.Payload) {
+		payload, _ = json.Marshal(string(msg.Payload))
+	}
+
+	return Frame{
+		Type:     "message",
+		ID:       msg.UUID,
+		Topic:    topic,
+		Metadata: msg.Metadata,
+		Payload:  payload,
+	}
+}
+
+// stream listens to topic for r, the messages are acked as received and
+// buffered for the client. The channel is closed once ctx is done, the
+// subscription ends or the buffer overflows.
+func (b *Bridge) stream(ctx context.Context, r *http.Request, topic, lastID string) (<-chan *common.Message, error) {
+	var opts []events.ListenOpt
+	if lastID != "" {
+		opts = append(opts, events.ListenFrom(lastID))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	msgs, err := b.router.Listen(ctx, topic, opts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	var (
+		meta = metaFilter(r)
+		out  = make(chan *common.Message, b.opt.Buffer)
+	)
+	var send = func(msg *common.Message) bool {
+		if !matches(msg, meta) || (b.opt.Filter != nil && !b.opt.Filter(r, msg)) {
+			return true
+		}
+
+		select {
+		case out <- msg:
+			return true
+		default:
+			// the client can't keep up
+			return false
+		}
+	}
+
+	go func() {
+		// a dropped client must not hold the subscription
+		defer cancel()
+		defer close(out)
+
+		// a resumed listener starts at lastID, a driver without groups replays
+		// the topic. The messages up to lastID are held and dropped once it's
+		// found, they are sent when it isn't found within ResumeTimeout or the
+		// buffer, they may be new to the client.
+		var (
+			resume  = lastID != ""
+			held    []*common.Message
+			timeout <-chan time.Time
+		)
+		if resume {
+			var t = time.NewTimer(b.opt.ResumeTimeout)
+			defer t.Stop()
+			timeout = t.C
+		}
+
+		for {
+			select {
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				msg.Ack()
+
+				switch {
+				case !resume:
+					if !send(msg) {
+						return
+					}
+					continue
+				case msg.UUID == lastID:
+					resume, held, timeout = false, nil, nil
+					continue
+				}
+
+				held = append(held, msg)
+				if len(held) < b.opt.Buffer {
+					continue
+				}
+			case <-timeout:
+			}
+
+			resume, timeout = false, nil
+			for _, msg := range held {
+				if !send(msg) {
+					return
+				}
+			}
+			held = nil
+		}
+	}()
+	return out, nil
+}
+
+// metaFilter returns the meta.<key> query parameters of r
+func metaFilter(r *http.Request) map[string]string {
+	var filter = make(map[string]string)
+	for k, v := range r.URL.Query() {
+		if key, ok := strings.CutPrefix(k, MetaPrefix); ok && len(v) > 0 {
+			filter[key] = v[0]
+		}
+	}
+	return filter
+}
+
+func matches(msg *common.Message, filter map[string]string) bool {
+	for k, v := range filter {
+		if msg.Metadata.Get(k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// topicOf returns topic, the topic query parameter when it's empty
+func topicOf(r *http.Request, topic string) string {
+	if topic != "" {
+		return topic
+	}
+	return r.URL.Query().Get("topic")
+}
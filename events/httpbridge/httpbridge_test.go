@@ -0,0 +1,274 @@
+package httpbridge
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/glebarez/sqlite"
+	"github.com/hysios/x/events"
+	"github.com/hysios/x/events/common"
+	"github.com/hysios/x/events/driver/memory"
+	"github.com/hysios/x/events/driver/sql"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+	"gorm.io/gorm"
+)
+
+func testRouter(t *testing.T) *events.Router {
+	// a blocking publish waits for the ack, the clients receive the messages in order
+	var cfg = memory.DefaultConfig
+	cfg.BlockingPublish = true
+
+	var bus = events.NewBus(memory.New(cfg))
+	t.Cleanup(func() { bus.Close() })
+	return &events.Router{Bus: bus}
+}
+
+func newMessage(payload string, meta ...string) *message.Message {
+	var msg = message.NewMessage(watermill.NewUUID(), []byte(payload))
+	for i := 0; i+1 < len(meta); i += 2 {
+		msg.Metadata.Set(meta[i], meta[i+1])
+	}
+	return msg
+}
+
+func publish(t *testing.T, r *events.Router, topic, payload string, meta ...string) *message.Message {
+	var msg = newMessage(payload, meta...)
+	assert.NoError(t, r.Publish(topic, msg))
+	return msg
+}
+
+// readEvent reads the next SSE event, skipping the comments
+func readEvent(t *testing.T, rd *bufio.Reader) map[string]string {
+	t.Helper()
+
+	var event = make(map[string]string)
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && len(event) > 0:
+			return event
+		case line == "", strings.HasPrefix(line, ":"):
+			if strings.HasPrefix(line, ":") {
+				event["comment"] = line
+				return event
+			}
+		default:
+			k, v, _ := strings.Cut(line, ": ")
+			if event[k] != "" {
+				v = event[k] + "\n" + v
+			}
+			event[k] = v
+		}
+	}
+}
+
+func TestSSE(t *testing.T) {
+	var (
+		router = testRouter(t)
+		bridge = New(router)
+		srv    = httptest.NewServer(bridge.SSE(""))
+	)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?topic=orders&meta.tenant=a")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	resp2, err := http.Get(srv.URL + "?topic=orders&meta.tenant=b")
+	assert.NoError(t, err)
+	defer resp2.Body.Close()
+
+	first := publish(t, router, "orders", `{"id":1}`, "tenant", "a")
+	publish(t, router, "orders", "line1\nline2", "tenant", "b")
+	publish(t, router, "orders", `{"id":3}`, "tenant", "a")
+
+	var rd = bufio.NewReader(resp.Body)
+	event := readEvent(t, rd)
+	assert.Equal(t, first.UUID, event["id"])
+	assert.Equal(t, "orders", event["event"])
+	assert.Equal(t, `{"id":1}`, event["data"])
+	assert.Equal(t, `{"id":3}`, readEvent(t, rd)["data"])
+
+	// multi-line payloads are sent as several data lines
+	assert.Equal(t, "line1\nline2", readEvent(t, bufio.NewReader(resp2.Body))["data"])
+
+	resp3, err := http.Get(srv.URL)
+	assert.NoError(t, err)
+	resp3.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp3.StatusCode)
+}
+
+func TestSSEResume(t *testing.T) {
+	var (
+		router = testRouter(t)
+		srv    = httptest.NewServer(New(router, WithHeartbeat(10*time.Millisecond)).SSE("orders"))
+	)
+	defer srv.Close()
+
+	var first = newMessage("1")
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set(LastEventIDHeader, first.UUID)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.NoError(t, router.Publish("orders", newMessage("0"), first))
+	publish(t, router, "orders", "2")
+
+	var (
+		rd   = bufio.NewReader(resp.Body)
+		data []string
+		beat bool
+	)
+	for len(data) == 0 || !beat {
+		event := readEvent(t, rd)
+		if event["comment"] != "" {
+			beat = true
+		} else {
+			data = append(data, event["data"])
+		}
+	}
+	assert.Equal(t, []string{"2"}, data)
+}
+
+func TestResumeSQL(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+
+	var (
+		bus         = events.NewBus(sql.New(sql.Config{DB: db, PollInterval: 5 * time.Millisecond}))
+		router      = &events.Router{Bus: bus}
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer bus.Close()
+	defer cancel()
+
+	var first = publish(t, router, "orders", "1")
+	publish(t, router, "orders", "2")
+
+	// the listener of a persistent driver starts at the last event id
+	msgs, err := New(router, WithResumeTimeout(time.Hour)).stream(ctx, httptest.NewRequest("GET", "/", nil), "orders", first.UUID)
+	assert.NoError(t, err)
+	select {
+	case msg := <-msgs:
+		assert.Equal(t, "2", string(msg.Payload))
+	case <-time.After(time.Second):
+		t.Fatal("resume timeout")
+	}
+}
+
+func TestResumeMissingID(t *testing.T) {
+	var (
+		router      = testRouter(t)
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer cancel()
+
+	var next = func(msgs <-chan *common.Message) string {
+		t.Helper()
+		select {
+		case msg := <-msgs:
+			return string(msg.Payload)
+		case <-time.After(time.Second):
+			t.Fatal("receive timeout")
+			return ""
+		}
+	}
+
+	// the held messages are sent once the id isn't found in time
+	msgs, err := New(router, WithResumeTimeout(20*time.Millisecond)).stream(ctx, httptest.NewRequest("GET", "/", nil), "orders", "gone")
+	assert.NoError(t, err)
+
+	publish(t, router, "orders", "1")
+	assert.Equal(t, "1", next(msgs))
+	publish(t, router, "orders", "2")
+	assert.Equal(t, "2", next(msgs))
+
+	// or once the buffer is full
+	msgs, err = New(router, WithBuffer(2), WithResumeTimeout(time.Hour)).stream(ctx, httptest.NewRequest("GET", "/", nil), "users", "gone")
+	assert.NoError(t, err)
+
+	publish(t, router, "users", "1")
+	publish(t, router, "users", "2")
+	assert.Equal(t, "1", next(msgs))
+	assert.Equal(t, "2", next(msgs))
+	publish(t, router, "users", "3")
+	assert.Equal(t, "3", next(msgs))
+}
+
+func TestDropSlowClient(t *testing.T) {
+	var (
+		router      = testRouter(t)
+		bridge      = New(router, WithBuffer(1))
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer cancel()
+
+	msgs, err := bridge.stream(ctx, httptest.NewRequest("GET", "/", nil), "orders", "")
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		publish(t, router, "orders", "x")
+	}
+
+	// nothing was read, the second message overflowed the buffer
+	var n int
+	for range msgs {
+		n++
+	}
+	assert.Equal(t, 1, n)
+}
+
+func TestWebSocket(t *testing.T) {
+	var (
+		router = testRouter(t)
+		srv    = httptest.NewServer(New(router, WithHeartbeat(10*time.Millisecond)).WebSocket("orders"))
+	)
+	defer srv.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	assert.NoError(t, err)
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(time.Second))
+
+	// the heartbeats start once the topic is subscribed
+	var frame Frame
+	assert.NoError(t, websocket.JSON.Receive(ws, &frame))
+	assert.Equal(t, "heartbeat", frame.Type)
+
+	msg := publish(t, router, "orders", "plain", "k", "v")
+	publish(t, router, "orders", `{"id":2}`)
+
+	receive := func() {
+		for frame.Type = ""; frame.Type != "message"; {
+			assert.NoError(t, websocket.JSON.Receive(ws, &frame))
+		}
+	}
+
+	receive()
+	assert.Equal(t, "message", frame.Type)
+	assert.Equal(t, msg.UUID, frame.ID)
+	assert.Equal(t, "orders", frame.Topic)
+	assert.Equal(t, "v", frame.Metadata["k"])
+	assert.JSONEq(t, `"plain"`, string(frame.Payload))
+
+	receive()
+	assert.JSONEq(t, `{"id":2}`, string(frame.Payload))
+}
@@ -0,0 +1,67 @@
+package httpbridge
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SSE streams topic as Server-Sent Events, the event id is the message UUID
+// and the event name the topic. An empty topic is read from the topic query
+// parameter. Last-Event-ID resumes after that message with a persistent driver.
+func (b *Bridge) SSE(topic string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var topic = topicOf(r, topic)
+		if topic == "" {
+			http.Error(w, "topic is required", http.StatusBadRequest)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		var lastID = r.Header.Get(LastEventIDHeader)
+		if lastID == "" {
+			lastID = r.URL.Query().Get("last_event_id")
+		}
+
+		msgs, err := b.stream(r.Context(), r, topic, lastID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		var heartbeat = time.NewTicker(b.opt.Heartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+
+				fmt.Fprintf(w, "id: %s\nevent: %s\n", msg.UUID, topic)
+				for _, line := range bytes.Split(msg.Payload, []byte("\n")) {
+					fmt.Fprintf(w, "data: %s\n", line)
+				}
+				fmt.Fprint(w, "\n")
+			}
+			flusher.Flush()
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package httpbridge
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// WebSocket streams topic as JSON Frames, an empty topic is read from the
+// topic query parameter. The connection is closed when the client falls behind.
+func (b *Bridge) WebSocket(topic string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var topic = topicOf(r, topic)
+		if topic == "" {
+			http.Error(w, "topic is required", http.StatusBadRequest)
+			return
+		}
+
+		websocket.Handler(func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+
+			// the reads only detect the client going away
+			go func() {
+				defer cancel()
+				var discard []byte
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+			}()
+
+			msgs, err := b.stream(ctx, r, topic, r.URL.Query().Get("last_event_id"))
+			if err != nil {
+				return
+			}
+
+			var heartbeat = time.NewTicker(b.opt.Heartbeat)
+			defer heartbeat.Stop()
+
+			for {
+				var frame Frame
+				select {
+				case <-ctx.Done():
+					return
+				case <-heartbeat.C:
+					frame = Frame{Type: "heartbeat"}
+				case msg, ok := <-msgs:
+					if !ok {
+						return
+					}
+					frame = newFrame(topic, msg)
+				}
+
+				if err := websocket.JSON.Send(ws, frame); err != nil {
+					return
+				}
+			}
+		}).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,43 @@
+package events
+
+import (
+	"github.com/ThreeDotsLabs/watermill"
+	"go.uber.org/zap"
+)
+
+// ZapLogger adapts log to the watermill logger, trace logs are written at the debug level
+func ZapLogger(log *zap.Logger) watermill.LoggerAdapter {
+	return &zapLogger{log: log}
+}
+
+type zapLogger struct {
+	log *zap.Logger
+}
+
+func (z *zapLogger) Error(msg string, err error, fields watermill.LogFields) {
+	z.log.Error(msg, append(zapFields(fields), zap.Error(err))...)
+}
+
+func (z *zapLogger) Info(msg string, fields watermill.LogFields) {
+	z.log.Info(msg, zapFields(fields)...)
+}
+
+func (z *zapLogger) Debug(msg string, fields watermill.LogFields) {
+	z.log.Debug(msg, zapFields(fields)...)
+}
+
+func (z *zapLogger) Trace(msg string, fields watermill.LogFields) {
+	z.log.Debug(msg, zapFields(fields)...)
+}
+
+func (z *zapLogger) With(fields watermill.LogFields) watermill.LoggerAdapter {
+	return &zapLogger{log: z.log.With(zapFields(fields)...)}
+}
+
+func zapFields(fields watermill.LogFields) []zap.Field {
+	var zf = make([]zap.Field, 0, len(fields)+1)
+	for k, v := range fields {
+		zf = append(zf, zap.Any(k, v))
+	}
+	return zf
+}
@@ -0,0 +1,105 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	"github.com/hysios/x/events/common"
+)
+
+// PoisonSuffix is appended to a topic to name its poison queue
+const PoisonSuffix = ".poison"
+
+// The metadata of the poisoned messages, the keys of the watermill PoisonQueue
+const (
+	ReasonForPoisonedKey  = middleware.ReasonForPoisonedKey
+	PoisonedTopicKey      = middleware.PoisonedTopicKey
+	PoisonedHandlerKey    = middleware.PoisonedHandlerKey
+	PoisonedSubscriberKey = middleware.PoisonedSubscriberKey
+	// PoisonedAtKey is the RFC 3339 time the message was poisoned
+	PoisonedAtKey = "poisoned_at"
+)
+
+// PoisonTopic returns the poison queue of topic
+func PoisonTopic(topic string) string {
+	return topic + PoisonSuffix
+}
+
+// publishFunc publishes messages, like common.Publisher.Publish
+type publishFunc func(topic string, messages ...*common.Message) error
+
+// PoisonQueue is a router middleware moving the messages the handler failed on
+// to the poison queue of their topic, with the error in the metadata. Put it
+// before Retry so only the messages failing every retry are moved.
+func PoisonQueue(pub common.Publisher) message.HandlerMiddleware {
+	return poisonQueue(pub.Publish)
+}
+
+func poisonQueue(publish publishFunc) message.HandlerMiddleware {
+	return func(h message.HandlerFunc) message.HandlerFunc {
+		return func(msg *message.Message) (msgs []*message.Message, err error) {
+			msgs, err = h(msg)
+			if err == nil {
+				return msgs, nil
+			}
+
+			var (
+				ctx   = msg.Context()
+				topic = message.SubscribeTopicFromCtx(ctx)
+			)
+			msg.Metadata.Set(ReasonForPoisonedKey, err.Error())
+			msg.Metadata.Set(PoisonedTopicKey, topic)
+			msg.Metadata.Set(PoisonedHandlerKey, message.HandlerNameFromCtx(ctx))
+			msg.Metadata.Set(PoisonedSubscriberKey, message.SubscriberNameFromCtx(ctx))
+			msg.Metadata.Set(PoisonedAtKey, time.Now().UTC().Format(time.RFC3339))
+
+			if perr := publish(PoisonTopic(topic), msg); perr != nil {
+				return nil, errors.Join(err, fmt.Errorf("events: publish to poison queue: %w", perr))
+			}
+			return nil, nil
+		}
+	}
+}
+
+// ReplayPoison republishes the poison queue of topic onto topic without the
+// poison metadata. It stops once no message arrived for wait, and returns the
+// number of replayed messages.
+func (b *Bus) ReplayPoison(ctx context.Context, topic string, wait time.Duration) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	msgs, err := b.Subscribe(ctx, PoisonTopic(topic))
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	for {
+		select {
+		case <-ctx.Done():
+			return count, nil
+		case <-time.After(wait):
+			return count, nil
+		case msg, ok := <-msgs:
+			if !ok {
+				return count, nil
+			}
+
+			var replay = msg.Copy()
+			for _, key := range []string{ReasonForPoisonedKey, PoisonedTopicKey, PoisonedHandlerKey, PoisonedSubscriberKey, PoisonedAtKey} {
+				delete(replay.Metadata, key)
+			}
+
+			if err := b.Publish(topic, replay); err != nil {
+				msg.Nack()
+				return count, err
+			}
+			msg.Ack()
+			count++
+		}
+	}
+}
@@ -2,6 +2,8 @@ package events
 
 import (
 	"context"
+	"errors"
+	"net/http"
 	"sync"
 	"time"
 
@@ -10,83 +12,298 @@ import (
 	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
 	"github.com/ThreeDotsLabs/watermill/message/router/plugin"
 	"github.com/hysios/x/events/common"
-	"github.com/hysios/x/events/driver"
 )
 
 type Router struct {
 	*message.Router
+
+	// Bus publishes and subscribes for the router, DefaultBus when nil
+	Bus *Bus
+}
+
+// DefaultRoute is the router started by Start, nil until then
+var DefaultRoute *Router
+
+type RouterOption struct {
+	Logger watermill.LoggerAdapter
+	// Retry retries the failed handlers, nil disables the retries
+	Retry *RetryPolicy
+	// Throttle limits the handled messages to ThrottleCount per Throttle, zero disables it
+	Throttle      time.Duration
+	ThrottleCount int64
+	// Timeout cancels the context of the handled messages after Timeout, zero disables it
+	Timeout time.Duration
+	// Signals closes the router on SIGINT and SIGTERM
+	Signals bool
+	// PoisonQueue moves the messages failing every retry to the poison queue of their topic
+	PoisonQueue bool
+	// CloseTimeout is how long Close waits for the running handlers
+	CloseTimeout time.Duration
+	// Middlewares run around the handlers, inside the built-in ones
+	Middlewares []message.HandlerMiddleware
+}
+
+// RetryPolicy of the failed handlers, the interval grows by Multiplier up to MaxInterval
+type RetryPolicy struct {
+	MaxRetries      int
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+}
+
+type RouterOpt func(*RouterOption)
+
+var DefaultRouterOption = RouterOption{
+	Logger: watermill.NewStdLogger(false, false),
+	Retry: &RetryPolicy{
+		MaxRetries:      3,
+		InitialInterval: time.Millisecond * 100,
+	},
+	Signals:      true,
+	CloseTimeout: 30 * time.Second,
+}
+
+// WithLogger
+func WithLogger(logger watermill.LoggerAdapter) RouterOpt {
+	return func(o *RouterOption) {
+		o.Logger = logger
+	}
+}
+
+// WithRetry sets the retry policy, nil disables the retries
+func WithRetry(policy *RetryPolicy) RouterOpt {
+	return func(o *RouterOption) {
+		o.Retry = policy
+	}
+}
+
+// WithThrottle limits the handled messages to count per duration
+func WithThrottle(count int64, duration time.Duration) RouterOpt {
+	return func(o *RouterOption) {
+		o.ThrottleCount = count
+		o.Throttle = duration
+	}
+}
+
+// WithTimeout
+func WithTimeout(timeout time.Duration) RouterOpt {
+	return func(o *RouterOption) {
+		o.Timeout = timeout
+	}
+}
+
+// WithSignals
+func WithSignals(enable bool) RouterOpt {
+	return func(o *RouterOption) {
+		o.Signals = enable
+	}
+}
+
+// WithPoisonQueue moves the messages failing every retry to PoisonTopic(topic), with the bus of the router
+func WithPoisonQueue() RouterOpt {
+	return func(o *RouterOption) {
+		o.PoisonQueue = true
+	}
+}
+
+// WithCloseTimeout
+func WithCloseTimeout(timeout time.Duration) RouterOpt {
+	return func(o *RouterOption) {
+		o.CloseTimeout = timeout
+	}
+}
+
+// WithMiddlewares
+func WithMiddlewares(middlewares ...message.HandlerMiddleware) RouterOpt {
+	return func(o *RouterOption) {
+		o.Middlewares = append(o.Middlewares, middlewares...)
+	}
 }
 
-var DefaultRoute = &Router{}
-var once sync.Once
+func NewRouter(opts ...RouterOpt) *Router {
+	var opt = DefaultRouterOption
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	var logger = opt.Logger
+	if logger == nil {
+		logger = watermill.NopLogger{}
+	}
 
-func NewRouter() *Router {
-	var logger = watermill.NewStdLogger(true, true)
+	router, _ := message.NewRouter(message.RouterConfig{CloseTimeout: opt.CloseTimeout}, logger)
+	if opt.Signals {
+		router.AddPlugin(plugin.SignalsHandler)
+	}
 
-	router, _ := message.NewRouter(message.RouterConfig{}, logger)
-	router.AddPlugin(plugin.SignalsHandler)
+	var r = &Router{
+		Router: router,
+	}
 
 	// Router level middleware are executed for every message sent to the router
 	router.AddMiddleware(
+		// Tracing continues the trace of the publisher, the span covers the retries
+		Tracing,
+
 		// CorrelationID will copy the correlation id from the incoming message's metadata to the produced messages
 		middleware.CorrelationID,
+	)
 
-		// The handler function is retried if it returns an error.
-		// After MaxRetries, the message is Nacked and it's up to the PubSub to resend it.
-		middleware.Retry{
-			MaxRetries:      3,
-			InitialInterval: time.Millisecond * 100,
-			Logger:          logger,
-		}.Middleware,
+	if opt.Throttle > 0 {
+		router.AddMiddleware(middleware.NewThrottle(opt.ThrottleCount, opt.Throttle).Middleware)
+	}
 
-		// Recoverer handles panics from handlers.
-		// In this case, it passes them as errors to the Retry middleware.
-		middleware.Recoverer,
-	)
+	// PoisonQueue salvages the messages the retries didn't help, they are acked then
+	if opt.PoisonQueue {
+		router.AddMiddleware(poisonQueue(func(topic string, messages ...*common.Message) error {
+			return r.Publish(topic, messages...)
+		}))
+	}
 
-	return &Router{
-		Router: router,
+	// The handler function is retried if it returns an error.
+	// After MaxRetries, the message is Nacked and it's up to the PubSub to resend it.
+	if opt.Retry != nil {
+		router.AddMiddleware(middleware.Retry{
+			MaxRetries:      opt.Retry.MaxRetries,
+			InitialInterval: opt.Retry.InitialInterval,
+			MaxInterval:     opt.Retry.MaxInterval,
+			Multiplier:      opt.Retry.Multiplier,
+			Logger:          logger,
+		}.Middleware)
 	}
+
+	if opt.Timeout > 0 {
+		router.AddMiddleware(middleware.Timeout(opt.Timeout))
+	}
+
+	router.AddMiddleware(opt.Middlewares...)
+
+	// Recoverer handles panics from handlers.
+	// In this case, it passes them as errors to the Retry middleware.
+	router.AddMiddleware(middleware.Recoverer)
+
+	return r
 }
 
 var l sync.Mutex
 
-func Start(ctx context.Context, ready func(router *Router)) *Router {
+// Start builds the DefaultRoute on the DefaultBus with opts and runs it until
+// ctx is done. It returns once the router is running, the next calls return
+// the running router.
+func Start(ctx context.Context, opts ...RouterOpt) (*Router, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if DefaultRoute != nil {
+		return DefaultRoute, nil
+	}
+
+	r, err := DefaultBus().start(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	DefaultRoute = r
+	return r, nil
+}
+
+// Stop stops the DefaultRoute within timeout, Start builds a new router afterwards
+func Stop(timeout time.Duration) error {
 	l.Lock()
 	defer l.Unlock()
 
 	if DefaultRoute == nil {
-		DefaultRoute = NewRouter()
-		go func() {
-			if ready != nil {
-				ready(DefaultRoute)
-			}
-			DefaultRoute.Run(ctx)
-		}()
+		return nil
 	}
 
-	return DefaultRoute
+	var r = DefaultRoute
+	DefaultRoute = nil
+	return r.Stop(timeout)
 }
 
+var (
+	// ErrNotRunning is returned by the probes of a router that isn't running
+	ErrNotRunning = errors.New("events: router is not running")
+	// ErrClosed is returned by the probes of a closed router
+	ErrClosed = errors.New("events: router closed")
+	// ErrStopTimeout is returned when the handlers didn't finish within the stop timeout
+	ErrStopTimeout = errors.New("events: stop timeout")
+)
+
 func (r *Router) Close() error {
 	return r.Router.Close()
 }
 
-func (r *Router) Publish(topic string, messages ...*common.Message) error {
-	publish, err := driver.CreatePublisher()
-	if err != nil {
+// Stop closes the router, the in-flight handlers have until timeout to finish
+func (r *Router) Stop(timeout time.Duration) error {
+	if r.Bus != nil {
+		r.Bus.forget(r)
+	}
+
+	var done = make(chan error, 1)
+	go func() { done <- r.Router.Close() }()
+
+	select {
+	case err := <-done:
 		return err
+	case <-time.After(timeout):
+		return ErrStopTimeout
+	}
+}
+
+// Ready reports whether the router is running, for readiness probes
+func (r *Router) Ready() error {
+	if r.IsClosed() {
+		return ErrClosed
+	}
+
+	select {
+	case <-r.Router.Running():
+		return nil
+	default:
+		return ErrNotRunning
+	}
+}
+
+// Live reports whether the router isn't closed, a router still starting is alive
+func (r *Router) Live() error {
+	if r.IsClosed() {
+		return ErrClosed
 	}
-	return publish.Publish(topic, messages...)
+	return nil
+}
+
+// HealthHandler serves 200 when check returns nil and 503 with the error otherwise,
+// e.g. HealthHandler(router.Ready) for the readiness probe
+func HealthHandler(check func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+}
+
+// Publish publishes messages to topic with the bus of the router
+func (r *Router) Publish(topic string, messages ...*common.Message) error {
+	return r.bus().Publish(topic, messages...)
 }
 
 // Subscribe subscribes to the given topic.
 func (r *Router) Subscribe(ctx context.Context, topic string) (<-chan *common.Message, error) {
-	subscribe, err := driver.CreateSubscriber()
-	if err != nil {
-		return nil, err
+	return r.bus().Subscribe(ctx, topic)
+}
+
+// Listen subscribes to topic with a temporary group, see Bus.Listen
+func (r *Router) Listen(ctx context.Context, topic string, opts ...ListenOpt) (<-chan *common.Message, error) {
+	return r.bus().Listen(ctx, topic, opts...)
+}
+
+func (r *Router) bus() *Bus {
+	if r.Bus != nil {
+		return r.Bus
 	}
-	return subscribe.Subscribe(ctx, topic)
+	return DefaultBus()
 }
 
 // Run
@@ -94,10 +311,12 @@ func (r *Router) Run(ctx context.Context) error {
 	return r.Router.Run(ctx)
 }
 
+// Publish publishes messages with the DefaultBus
 func Publish(topic string, messages ...*common.Message) error {
-	return DefaultRoute.Publish(topic, messages...)
+	return DefaultBus().Publish(topic, messages...)
 }
 
+// Subscribe subscribes with the DefaultBus
 func Subscribe(ctx context.Context, topic string) (<-chan *common.Message, error) {
-	return DefaultRoute.Subscribe(ctx, topic)
+	return DefaultBus().Subscribe(ctx, topic)
 }
@@ -0,0 +1,161 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/hysios/x/events/driver/memory"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestPoisonQueue(t *testing.T) {
+	var (
+		cfg = memory.DefaultConfig
+		bus *Bus
+	)
+	cfg.Persistent = true
+	bus = NewBus(memory.New(cfg), WithRouterOptions(
+		WithSignals(false),
+		WithRetry(&RetryPolicy{MaxRetries: 2, InitialInterval: time.Millisecond}),
+		WithPoisonQueue(),
+	))
+	defer bus.Close()
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		done        = make(chan error)
+		attempts    = make(chan string, 10)
+		fail        = true
+	)
+
+	assert.NoError(t, bus.AddHandler("orders", "orders", "", func(msg *message.Message) error {
+		attempts <- string(msg.Payload)
+		if fail {
+			return errors.New("boom")
+		}
+		return nil
+	}))
+
+	go func() { done <- bus.Run(ctx) }()
+	<-bus.Router().Running()
+
+	poisoned, err := bus.Subscribe(ctx, PoisonTopic("orders"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, bus.Publish("orders", message.NewMessage(watermill.NewUUID(), []byte("1"))))
+
+	select {
+	case msg := <-poisoned:
+		assert.Equal(t, "boom", msg.Metadata.Get(ReasonForPoisonedKey))
+		assert.Equal(t, "orders", msg.Metadata.Get(PoisonedTopicKey))
+		assert.Equal(t, "orders", msg.Metadata.Get(PoisonedHandlerKey))
+		assert.NotEmpty(t, msg.Metadata.Get(PoisonedAtKey))
+		msg.Ack()
+	case <-time.After(time.Second):
+		t.Fatal("poison timeout")
+	}
+	// the first try and 2 retries
+	assert.Len(t, attempts, 3)
+	for len(attempts) > 0 {
+		<-attempts
+	}
+
+	fail = false
+	n, err := bus.ReplayPoison(ctx, "orders", 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	select {
+	case payload := <-attempts:
+		assert.Equal(t, "1", payload)
+	case <-time.After(time.Second):
+		t.Fatal("replay timeout")
+	}
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestZapLogger(t *testing.T) {
+	var (
+		core, logs = observer.New(zap.DebugLevel)
+		logger     = ZapLogger(zap.New(core)).With(watermill.LogFields{"router": "a"})
+	)
+
+	logger.Info("started", watermill.LogFields{"handlers": 2})
+	logger.Trace("received", nil)
+	logger.Error("failed", errors.New("boom"), nil)
+
+	var entries = logs.All()
+	assert.Len(t, entries, 3)
+	assert.Equal(t, map[string]interface{}{"router": "a", "handlers": int64(2)}, entries[0].ContextMap())
+	assert.Equal(t, zap.DebugLevel, entries[1].Level)
+	assert.Equal(t, "boom", entries[2].ContextMap()["error"])
+}
+
+func TestStartStop(t *testing.T) {
+	var bus = NewBus(memory.New(memory.DefaultConfig), WithRouterOptions(WithSignals(false)))
+	defer bus.Close()
+
+	SetDefaultBus(bus)
+	defer SetDefaultBus(nil)
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		started     = make(chan struct{})
+		release     = make(chan struct{})
+	)
+	defer cancel()
+
+	assert.NoError(t, On(bus, "slow", func(ctx context.Context, v int) error {
+		close(started)
+		<-release
+		return nil
+	}))
+
+	r, err := Start(ctx, WithCloseTimeout(time.Second))
+	assert.NoError(t, err)
+	assert.NoError(t, r.Ready())
+	assert.NoError(t, r.Live())
+
+	again, err := Start(ctx)
+	assert.NoError(t, err)
+	assert.Same(t, r, again)
+
+	var rec = httptest.NewRecorder()
+	HealthHandler(r.Ready).ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	assert.NoError(t, Send(ctx, "slow", 1))
+	<-started
+
+	// the in-flight handler outlives the stop timeout
+	assert.ErrorIs(t, Stop(10*time.Millisecond), ErrStopTimeout)
+	close(release)
+	assert.Eventually(t, func() bool { return r.Live() != nil }, time.Second, time.Millisecond)
+	assert.ErrorIs(t, r.Ready(), ErrClosed)
+
+	rec = httptest.NewRecorder()
+	HealthHandler(r.Live).ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	// a new router is built after Stop
+	next, err := Start(ctx)
+	assert.NoError(t, err)
+	assert.NotSame(t, r, next)
+	assert.NoError(t, Stop(time.Second))
+}
+
+func TestReadyBeforeRun(t *testing.T) {
+	var r = NewRouter(WithSignals(false))
+	assert.ErrorIs(t, r.Ready(), ErrNotRunning)
+	assert.NoError(t, r.Live())
+}
@@ -0,0 +1,394 @@
+// Package store is an append-only event store on GORM. Every aggregate has a
+// stream of versioned events, appends check the expected version so concurrent
+// writers can't interleave. The events are written to the outbox in the
+// transaction appending them, an outbox.Relay publishes them on the events bus.
+//
+//	var order = &Order{ID: "o-1"}
+//	version, err := s.Load(ctx, order)
+//	events, err := s.Save(ctx, order, version, OrderPaid{Amount: 10})
+//
+//	go outbox.NewRelay(db, outbox.Events(bus)).Run(ctx)
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/hysios/x/outbox"
+	"gorm.io/gorm"
+)
+
+var (
+	// EventsTable is the table the event streams are stored in
+	EventsTable = "event_streams"
+	// SnapshotsTable is the table the snapshots are stored in
+	SnapshotsTable = "event_snapshots"
+)
+
+// ErrConcurrency is returned when the stream isn't at the expected version
+var ErrConcurrency = errors.New("store: concurrent modification")
+
+// Metadata keys of the published messages
+const (
+	AggregateIDKey = "aggregate_id"
+	VersionKey     = "version"
+	TypeKey        = "event_type"
+)
+
+// Record is a stored event
+type Record struct {
+	ID          uint64 `gorm:"primaryKey;autoIncrement"`
+	AggregateID string `gorm:"size:191;uniqueIndex:idx_event_stream_version"`
+	Version     int    `gorm:"uniqueIndex:idx_event_stream_version"`
+	Type        string `gorm:"size:191"`
+	Payload     []byte
+	CreatedAt   time.Time
+}
+
+func (Record) TableName() string {
+	return EventsTable
+}
+
+// SnapshotRecord is the latest snapshot of an aggregate
+type SnapshotRecord struct {
+	AggregateID string `gorm:"size:191;primaryKey"`
+	Version     int
+	Payload     []byte
+	CreatedAt   time.Time
+}
+
+func (SnapshotRecord) TableName() string {
+	return SnapshotsTable
+}
+
+// Event is an event of an aggregate stream
+type Event struct {
+	AggregateID string
+	Version     int
+	// Type is the name of the event, see Name
+	Type string
+	// Data is the event, a json.RawMessage when its type isn't registered
+	Data      interface{}
+	CreatedAt time.Time
+}
+
+// Aggregate is rebuilt by applying its events in order
+type Aggregate interface {
+	AggregateID() string
+	Apply(evt Event) error
+}
+
+// Snapshotter is an aggregate whose state can be saved, Load restores the
+// latest snapshot before applying the newer events
+type Snapshotter interface {
+	Aggregate
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+// Name returns the name of the type of v, like orders.OrderPaid
+func Name(v interface{}) string {
+	var t = reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.String()
+}
+
+type Option struct {
+	// Topic is the topic of a committed event, its Type by default
+	Topic func(evt Event) string
+	// SnapshotEvery saves a snapshot of the Snapshotter aggregates every n versions, zero disables it
+	SnapshotEvery int
+}
+
+type Opt func(*Option)
+
+var DefaultOption = Option{
+	Topic: func(evt Event) string { return evt.Type },
+}
+
+// WithTopic
+func WithTopic(topic func(evt Event) string) Opt {
+	return func(o *Option) {
+		o.Topic = topic
+	}
+}
+
+// SnapshotEvery
+func SnapshotEvery(n int) Opt {
+	return func(o *Option) {
+		o.SnapshotEvery = n
+	}
+}
+
+// Store keeps the event streams in db
+type Store struct {
+	db  *gorm.DB
+	opt Option
+
+	mu    sync.RWMutex
+	types map[string]reflect.Type
+}
+
+// Migrate creates the event, snapshot and outbox tables
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&Record{}, &SnapshotRecord{}); err != nil {
+		return err
+	}
+	return outbox.Migrate(db)
+}
+
+// NewStore
+func NewStore(db *gorm.DB, opts ...Opt) *Store {
+	var opt = DefaultOption
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return &Store{
+		db:    db,
+		opt:   opt,
+		types: make(map[string]reflect.Type),
+	}
+}
+
+// Register registers the types of the events, their Data is decoded into a value of that type
+func (s *Store) Register(evts ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, evt := range evts {
+		var t = reflect.TypeOf(evt)
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		s.types[t.String()] = t
+	}
+}
+
+// Append appends evts to the stream of id, the stream has to be at version expected,
+// zero for a new stream. The events are written to the outbox in the same
+// transaction, they are published by the relay once committed.
+func (s *Store) Append(ctx context.Context, id string, expected int, evts ...interface{}) ([]Event, error) {
+	if len(evts) == 0 {
+		return nil, nil
+	}
+
+	var (
+		records   = make([]Record, len(evts))
+		committed = make([]Event, len(evts))
+		now       = time.Now()
+	)
+	for i, evt := range evts {
+		payload, err := json.Marshal(evt)
+		if err != nil {
+			return nil, fmt.Errorf("store: encode %s: %w", Name(evt), err)
+		}
+
+		records[i] = Record{
+			AggregateID: id,
+			Version:     expected + i + 1,
+			Type:        Name(evt),
+			Payload:     payload,
+			CreatedAt:   now,
+		}
+		committed[i] = Event{
+			AggregateID: id,
+			Version:     expected + i + 1,
+			Type:        records[i].Type,
+			Data:        evt,
+			CreatedAt:   now,
+		}
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		version, err := currentVersion(tx, id)
+		if err != nil {
+			return err
+		}
+		if version != expected {
+			return fmt.Errorf("%w: %s is at version %d, expected %d", ErrConcurrency, id, version, expected)
+		}
+
+		if err := tx.Create(&records).Error; err != nil {
+			// a concurrent append took the versions first
+			if version, verr := currentVersion(s.db.WithContext(ctx), id); verr == nil && version != expected {
+				return fmt.Errorf("%w: %s is at version %d, expected %d", ErrConcurrency, id, version, expected)
+			}
+			return err
+		}
+		return s.enqueue(tx, committed)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return committed, nil
+}
+
+// Save appends evts to the stream of agg and applies them to agg, a snapshot is
+// saved when the stream crosses a multiple of SnapshotEvery
+func (s *Store) Save(ctx context.Context, agg Aggregate, expected int, evts ...interface{}) ([]Event, error) {
+	committed, err := s.Append(ctx, agg.AggregateID(), expected, evts...)
+	if len(committed) == 0 {
+		return committed, err
+	}
+
+	for _, evt := range committed {
+		if aerr := agg.Apply(evt); aerr != nil {
+			return committed, errors.Join(err, aerr)
+		}
+	}
+
+	var version = committed[len(committed)-1].Version
+	if snap, ok := agg.(Snapshotter); ok && s.opt.SnapshotEvery > 0 && version/s.opt.SnapshotEvery > expected/s.opt.SnapshotEvery {
+		if serr := s.SaveSnapshot(ctx, snap, version); serr != nil {
+			return committed, errors.Join(err, serr)
+		}
+	}
+	return committed, err
+}
+
+// Load rebuilds agg from its latest snapshot and the newer events, it returns the version of agg
+func (s *Store) Load(ctx context.Context, agg Aggregate) (int, error) {
+	var version int
+
+	if snap, ok := agg.(Snapshotter); ok {
+		var rec SnapshotRecord
+		err := s.db.WithContext(ctx).Where("aggregate_id = ?", agg.AggregateID()).Limit(1).Find(&rec).Error
+		if err != nil {
+			return 0, err
+		}
+
+		if rec.AggregateID != "" {
+			if err := snap.Restore(rec.Payload); err != nil {
+				return 0, fmt.Errorf("store: restore %s: %w", rec.AggregateID, err)
+			}
+			version = rec.Version
+		}
+	}
+
+	evts, err := s.Events(ctx, agg.AggregateID(), version)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, evt := range evts {
+		if err := agg.Apply(evt); err != nil {
+			return 0, err
+		}
+		version = evt.Version
+	}
+	return version, nil
+}
+
+// Events returns the events of the stream of id after version from
+func (s *Store) Events(ctx context.Context, id string, from int) ([]Event, error) {
+	var records []Record
+	err := s.db.WithContext(ctx).
+		Where("aggregate_id = ? AND version > ?", id, from).
+		Order("version").
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var evts = make([]Event, len(records))
+	for i, rec := range records {
+		data, err := s.decode(rec)
+		if err != nil {
+			return nil, err
+		}
+
+		evts[i] = Event{
+			AggregateID: rec.AggregateID,
+			Version:     rec.Version,
+			Type:        rec.Type,
+			Data:        data,
+			CreatedAt:   rec.CreatedAt,
+		}
+	}
+	return evts, nil
+}
+
+// Republish writes again the stored events of the stream of id from version on
+// to the outbox, the consumers receive those that were already published twice
+func (s *Store) Republish(ctx context.Context, id string, version int) error {
+	evts, err := s.Events(ctx, id, version-1)
+	if err != nil {
+		return err
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return s.enqueue(tx, evts)
+	})
+}
+
+// SaveSnapshot saves the state of agg at version, it replaces the previous snapshot
+func (s *Store) SaveSnapshot(ctx context.Context, agg Snapshotter, version int) error {
+	data, err := agg.Snapshot()
+	if err != nil {
+		return fmt.Errorf("store: snapshot %s: %w", agg.AggregateID(), err)
+	}
+
+	return s.db.WithContext(ctx).Save(&SnapshotRecord{
+		AggregateID: agg.AggregateID(),
+		Version:     version,
+		Payload:     data,
+		CreatedAt:   time.Now(),
+	}).Error
+}
+
+func (s *Store) decode(rec Record) (interface{}, error) {
+	s.mu.RLock()
+	t, ok := s.types[rec.Type]
+	s.mu.RUnlock()
+
+	if !ok {
+		return json.RawMessage(rec.Payload), nil
+	}
+
+	var v = reflect.New(t)
+	if err := json.Unmarshal(rec.Payload, v.Interface()); err != nil {
+		return nil, fmt.Errorf("store: decode %s v%d: %w", rec.AggregateID, rec.Version, err)
+	}
+	return v.Elem().Interface(), nil
+}
+
+// enqueue writes evts to the outbox with tx, the stream metadata is set on the
+// messages, the messages of an aggregate are relayed in order
+func (s *Store) enqueue(tx *gorm.DB, evts []Event) error {
+	for _, evt := range evts {
+		payload, err := json.Marshal(evt.Data)
+		if err != nil {
+			return fmt.Errorf("store: encode %s: %w", evt.Type, err)
+		}
+
+		if err := outbox.Publish(tx, s.opt.Topic(evt), payload,
+			outbox.Aggregate(evt.AggregateID),
+			outbox.Header(AggregateIDKey, evt.AggregateID),
+			outbox.Header(VersionKey, fmt.Sprint(evt.Version)),
+			outbox.Header(TypeKey, evt.Type),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func currentVersion(db *gorm.DB, id string) (int, error) {
+	var version *int
+	err := db.Model(&Record{}).
+		Where("aggregate_id = ?", id).
+		Select("MAX(version)").
+		Scan(&version).Error
+	if err != nil || version == nil {
+		return 0, err
+	}
+	return *version, nil
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/hysios/x/events"
+	"github.com/hysios/x/events/eventstest"
+	"github.com/hysios/x/outbox"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type OrderPlaced struct {
+	Amount int `json:"amount"`
+}
+
+type OrderPaid struct {
+	Amount int `json:"amount"`
+}
+
+type Order struct {
+	ID      string `json:"id"`
+	Amount  int    `json:"amount"`
+	Paid    int    `json:"paid"`
+	applied int
+}
+
+func (o *Order) AggregateID() string {
+	return o.ID
+}
+
+func (o *Order) Apply(evt Event) error {
+	o.applied++
+	switch e := evt.Data.(type) {
+	case OrderPlaced:
+		o.Amount = e.Amount
+	case OrderPaid:
+		o.Paid += e.Amount
+	}
+	return nil
+}
+
+func (o *Order) Snapshot() ([]byte, error) {
+	return json.Marshal(o)
+}
+
+func (o *Order) Restore(data []byte) error {
+	return json.Unmarshal(data, o)
+}
+
+func testDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite memory failed: %v", err)
+	}
+
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+
+	assert.NoError(t, Migrate(db))
+	return db
+}
+
+// relay publishes the outbox of db on bus
+func relay(t *testing.T, db *gorm.DB, bus *events.Bus) int {
+	t.Helper()
+
+	sent, err := outbox.NewRelay(db, outbox.Events(bus)).Flush(context.Background())
+	assert.NoError(t, err)
+	return sent
+}
+
+func TestAppendLoad(t *testing.T) {
+	var (
+		bus = eventstest.NewBus(t)
+		db  = testDB(t)
+		s   = NewStore(db, WithTopic(func(evt Event) string { return "orders" }))
+		ctx = context.Background()
+		e   = eventstest.Expect(t, "orders")
+	)
+	s.Register(OrderPlaced{}, &OrderPaid{})
+
+	committed, err := s.Append(ctx, "o-1", 0, OrderPlaced{Amount: 10}, OrderPaid{Amount: 4})
+	assert.NoError(t, err)
+	assert.Len(t, committed, 2)
+	assert.Equal(t, 2, committed[1].Version)
+	assert.Equal(t, "store.OrderPaid", committed[1].Type)
+	assert.Equal(t, 2, relay(t, db, bus))
+
+	for _, msg := range e.Count(2) {
+		assert.Equal(t, "o-1", msg.Metadata.Get(AggregateIDKey))
+		if msg.Metadata.Get(VersionKey) == "2" {
+			assert.Equal(t, "store.OrderPaid", msg.Metadata.Get(TypeKey))
+			assert.JSONEq(t, `{"amount":4}`, string(msg.Payload))
+		}
+	}
+
+	// a writer that loaded version 1 is rejected, nothing is published
+	_, err = s.Append(ctx, "o-1", 1, OrderPaid{Amount: 1})
+	assert.ErrorIs(t, err, ErrConcurrency)
+	assert.Zero(t, relay(t, db, bus))
+
+	var order = &Order{ID: "o-1"}
+	version, err := s.Load(ctx, order)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, version)
+	assert.Equal(t, 10, order.Amount)
+	assert.Equal(t, 4, order.Paid)
+
+	// other streams are independent
+	_, err = s.Append(ctx, "o-2", 0, OrderPlaced{Amount: 3})
+	assert.NoError(t, err)
+
+	evts, err := s.Events(ctx, "o-1", 1)
+	assert.NoError(t, err)
+	assert.Len(t, evts, 1)
+	assert.Equal(t, OrderPaid{Amount: 4}, evts[0].Data)
+}
+
+func TestUnregisteredEvent(t *testing.T) {
+	var (
+		s   = NewStore(testDB(t))
+		ctx = context.Background()
+	)
+
+	_, err := s.Append(ctx, "o-1", 0, OrderPlaced{Amount: 10})
+	assert.NoError(t, err)
+
+	evts, err := s.Events(ctx, "o-1", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, json.RawMessage(`{"amount":10}`), evts[0].Data)
+}
+
+func TestSnapshot(t *testing.T) {
+	var (
+		db    = testDB(t)
+		s     = NewStore(db, SnapshotEvery(3))
+		ctx   = context.Background()
+		order = &Order{ID: "o-1"}
+	)
+	s.Register(OrderPlaced{}, OrderPaid{})
+
+	_, err := s.Save(ctx, order, 0, OrderPlaced{Amount: 10})
+	assert.NoError(t, err)
+
+	var count int64
+	db.Model(&SnapshotRecord{}).Count(&count)
+	assert.Zero(t, count)
+
+	_, err = s.Save(ctx, order, 1, OrderPaid{Amount: 2}, OrderPaid{Amount: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, order.Paid)
+
+	var snap SnapshotRecord
+	assert.NoError(t, db.First(&snap).Error)
+	assert.Equal(t, 3, snap.Version)
+
+	_, err = s.Save(ctx, order, 3, OrderPaid{Amount: 1})
+	assert.NoError(t, err)
+
+	// the snapshot covers 3 versions, only the 4th event is applied
+	var loaded = &Order{ID: "o-1"}
+	version, err := s.Load(ctx, loaded)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, version)
+	assert.Equal(t, 6, loaded.Paid)
+	assert.Equal(t, 1, loaded.applied)
+}
+
+func TestRepublish(t *testing.T) {
+	var (
+		bus = eventstest.NewBus(t)
+		db  = testDB(t)
+		s   = NewStore(db, WithTopic(func(evt Event) string { return "orders" }))
+		ctx = context.Background()
+		e   = eventstest.Expect(t, "orders")
+	)
+
+	// the events wait in the outbox until they are relayed
+	_, err := s.Append(ctx, "o-1", 0, OrderPlaced{Amount: 10}, OrderPaid{Amount: 4})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Republish(ctx, "o-1", 2))
+	assert.Equal(t, 3, relay(t, db, bus))
+
+	var versions []string
+	for _, msg := range e.Count(3) {
+		versions = append(versions, msg.Metadata.Get(VersionKey))
+	}
+	assert.ElementsMatch(t, []string{"1", "2", "2"}, versions)
+}
@@ -0,0 +1,45 @@
+package events
+
+import (
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/hysios/x/events/common"
+	"github.com/hysios/x/otel"
+)
+
+// System is the messaging system of the spans
+const System = "watermill"
+
+// Tracing is a router middleware that continues the trace found in the message
+// metadata, the messages produced by the handler carry the handler span
+func Tracing(h message.HandlerFunc) message.HandlerFunc {
+	return func(msg *message.Message) (msgs []*message.Message, err error) {
+		var topic = message.SubscribeTopicFromCtx(msg.Context())
+
+		ctx, span := otel.StartConsume(otel.Extract(msg.Context(), msg.Metadata), System, topic, otel.MessageID(msg.UUID))
+		defer func() { otel.End(span, err) }()
+
+		msg.SetContext(ctx)
+		msgs, err = h(msg)
+		for _, m := range msgs {
+			otel.Inject(ctx, m.Metadata)
+		}
+		return msgs, err
+	}
+}
+
+// inject starts a producer span for every message and writes it into the metadata
+func inject(topic string, messages []*common.Message) func(err error) {
+	var ends = make([]func(error), 0, len(messages))
+	for _, msg := range messages {
+		ctx, span := otel.StartPublish(msg.Context(), System, topic, otel.MessageID(msg.UUID))
+		otel.Inject(ctx, msg.Metadata)
+
+		ends = append(ends, func(err error) { otel.End(span, err) })
+	}
+
+	return func(err error) {
+		for _, end := range ends {
+			end(err)
+		}
+	}
+}
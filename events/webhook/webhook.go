@@ -0,0 +1,534 @@
+// Package webhook posts the messages of events topics to the HTTP endpoints
+// registered on them. Payloads are signed with the endpoint secret, failed
+// deliveries are retried with an exponential backoff and an endpoint failing
+// repeatedly is skipped for a while by its circuit breaker. A message whose
+// retries are exhausted or skipped is nacked, its redelivery only posts to the
+// endpoints that didn't receive it yet. A message skipped by an open circuit
+// is nacked once the cooldown is over.
+//
+//	hooks := webhook.New(bus)
+//	hooks.Register(webhook.Endpoint{ID: "partner", Topic: "order.paid", URL: url, Secret: secret})
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/hysios/x/events"
+	"github.com/hysios/x/events/common"
+	"github.com/hysios/x/store"
+)
+
+// Headers of the delivered requests
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	IDHeader        = "X-Webhook-Id"
+	TopicHeader     = "X-Webhook-Topic"
+)
+
+var (
+	// ErrCircuitOpen is recorded for the deliveries skipped by an open circuit breaker
+	ErrCircuitOpen = errors.New("webhook: circuit open")
+	// ErrInvalidEndpoint is returned by Register for an endpoint without ID, Topic or URL
+	ErrInvalidEndpoint = errors.New("webhook: invalid endpoint")
+	// ErrUndelivered is returned by Deliver when an endpoint may receive the message on a redelivery
+	ErrUndelivered = errors.New("webhook: undelivered")
+)
+
+// Tolerance is the max age of a request accepted by Verify, zero disables the check
+var Tolerance = 5 * time.Minute
+
+// Endpoint receives the messages of Topic
+type Endpoint struct {
+	ID    string
+	Topic string
+	URL   string
+	// Secret signs the payloads, see Sign
+	Secret  string
+	Headers map[string]string
+}
+
+// Attempt is a delivery attempt of a message to an endpoint
+type Attempt struct {
+	Endpoint   string
+	Topic      string
+	MessageID  string
+	Attempt    int
+	StatusCode int
+	Error      string
+	Duration   time.Duration
+	At         time.Time
+}
+
+type Option struct {
+	Client *http.Client
+	// Timeout of a request
+	Timeout time.Duration
+	// MaxAttempts of a delivery
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled on every attempt up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// BreakerThreshold is the number of consecutive failures opening the circuit of an endpoint
+	BreakerThreshold int
+	// BreakerCooldown is how long the circuit stays open before a trial delivery
+	BreakerCooldown time.Duration
+	// Attempts records the attempts by message id, the default store keeps the
+	// attempts of the last MaxRecords messages
+	Attempts   store.Store[string, []Attempt]
+	MaxRecords int
+	// MaxRecordAttempts is the number of attempts kept of a message, the
+	// oldest failures are dropped first
+	MaxRecordAttempts int
+	// Group is the queue group the topics are consumed with
+	Group string
+}
+
+type Opt func(*Option)
+
+var DefaultOption = Option{
+	Timeout:           10 * time.Second,
+	MaxAttempts:       5,
+	Backoff:           500 * time.Millisecond,
+	MaxBackoff:        30 * time.Second,
+	BreakerThreshold:  5,
+	BreakerCooldown:   30 * time.Second,
+	MaxRecords:        10000,
+	MaxRecordAttempts: 100,
+	Group:             "webhook",
+}
+
+// WithClient
+func WithClient(client *http.Client) Opt {
+	return func(o *Option) {
+		o.Client = client
+	}
+}
+
+// WithRetry sets the max attempts and the backoff of the deliveries
+func WithRetry(maxAttempts int, backoff, maxBackoff time.Duration) Opt {
+	return func(o *Option) {
+		o.MaxAttempts = maxAttempts
+		o.Backoff = backoff
+		o.MaxBackoff = maxBackoff
+	}
+}
+
+// WithBreaker sets the circuit breaker of the endpoints
+func WithBreaker(threshold int, cooldown time.Duration) Opt {
+	return func(o *Option) {
+		o.BreakerThreshold = threshold
+		o.BreakerCooldown = cooldown
+	}
+}
+
+// WithAttempts sets the store of the delivery attempts
+func WithAttempts(s store.Store[string, []Attempt]) Opt {
+	return func(o *Option) {
+		o.Attempts = s
+	}
+}
+
+// WithGroup
+func WithGroup(group string) Opt {
+	return func(o *Option) {
+		o.Group = group
+	}
+}
+
+// Webhook delivers the messages of the topics with endpoints
+type Webhook struct {
+	bus *events.Bus
+	opt Option
+	// name prefixes the handlers, the webhooks of a bus don't collide
+	name string
+
+	mu        sync.RWMutex
+	endpoints map[string]map[string]*endpoint
+	topics    map[string]bool
+
+	attemptsMu sync.Mutex
+}
+
+// New
+func New(bus *events.Bus, opts ...Opt) *Webhook {
+	var opt = DefaultOption
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	if opt.Client == nil {
+		opt.Client = &http.Client{Timeout: opt.Timeout}
+	}
+	if opt.Attempts == nil {
+		opt.Attempts = newRecords(opt.MaxRecords)
+	}
+
+	return &Webhook{
+		bus:       bus,
+		opt:       opt,
+		name:      "webhook." + watermill.NewShortUUID(),
+		endpoints: make(map[string]map[string]*endpoint),
+		topics:    make(map[string]bool),
+	}
+}
+
+// Register adds or replaces the endpoint ep.ID of ep.Topic, the topic is
+// subscribed on its first endpoint
+func (w *Webhook) Register(ep Endpoint) error {
+	if ep.ID == "" || ep.Topic == "" || ep.URL == "" {
+		return fmt.Errorf("%w: %+v", ErrInvalidEndpoint, ep)
+	}
+
+	w.mu.Lock()
+	if w.endpoints[ep.Topic] == nil {
+		w.endpoints[ep.Topic] = make(map[string]*endpoint)
+	}
+	w.endpoints[ep.Topic][ep.ID] = &endpoint{Endpoint: ep}
+
+	var subscribed = w.topics[ep.Topic]
+	w.topics[ep.Topic] = true
+	w.mu.Unlock()
+
+	if subscribed {
+		return nil
+	}
+
+	err := w.bus.AddHandler(w.name+"."+ep.Topic, ep.Topic, w.opt.Group, func(msg *common.Message) error {
+		return w.Deliver(msg.Context(), ep.Topic, msg)
+	})
+	if err != nil {
+		w.mu.Lock()
+		delete(w.topics, ep.Topic)
+		w.mu.Unlock()
+	}
+	return err
+}
+
+// Unregister removes the endpoint id of topic, the topic stays subscribed
+func (w *Webhook) Unregister(topic, id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.endpoints[topic], id)
+}
+
+// Endpoints returns the endpoints of topic
+func (w *Webhook) Endpoints(topic string) []Endpoint {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	var eps = make([]Endpoint, 0, len(w.endpoints[topic]))
+	for _, ep := range w.endpoints[topic] {
+		eps = append(eps, ep.Endpoint)
+	}
+	return eps
+}
+
+// Attempts returns the delivery attempts of the message id
+func (w *Webhook) Attempts(id string) []Attempt {
+	attempts, _ := w.opt.Attempts.Load(id)
+	return attempts
+}
+
+// Deliver posts msg to the endpoints of topic that didn't receive it yet, every
+// endpoint is tried until success, a non retryable response or MaxAttempts. It
+// returns ErrUndelivered when an endpoint gave up on a retryable failure or an
+// open circuit, a redelivery of msg may succeed. An open circuit is waited for
+// until its cooldown ends or ctx is done.
+func (w *Webhook) Deliver(ctx context.Context, topic string, msg *common.Message) error {
+	w.mu.RLock()
+	var eps = make([]*endpoint, 0, len(w.endpoints[topic]))
+	for _, ep := range w.endpoints[topic] {
+		eps = append(eps, ep)
+	}
+	w.mu.RUnlock()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(eps))
+	)
+	for i, ep := range eps {
+		if w.delivered(msg.UUID, ep.ID) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, ep *endpoint) {
+			defer wg.Done()
+			errs[i] = w.deliver(ctx, ep, msg)
+		}(i, ep)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// delivered reports whether the endpoint id already received the message
+func (w *Webhook) delivered(msgID, id string) bool {
+	for _, a := range w.Attempts(msgID) {
+		if a.Endpoint == id && a.Error == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Webhook) deliver(ctx context.Context, ep *endpoint, msg *common.Message) error {
+	var backoff = w.opt.Backoff
+
+	for n := 1; n <= w.opt.MaxAttempts; n++ {
+		var attempt = Attempt{
+			Endpoint:  ep.ID,
+			Topic:     ep.Topic,
+			MessageID: msg.UUID,
+			Attempt:   n,
+			At:        time.Now(),
+		}
+
+		var retry bool
+		if !ep.allow(time.Now(), w.opt.BreakerCooldown) {
+			attempt.Error = ErrCircuitOpen.Error()
+		} else {
+			var (
+				start = time.Now()
+				err   error
+			)
+			attempt.StatusCode, retry, err = w.post(ctx, ep, msg)
+			attempt.Duration = time.Since(start)
+			if err != nil {
+				attempt.Error = err.Error()
+			}
+			ep.record(err == nil, time.Now(), w.opt.BreakerThreshold)
+		}
+		w.record(attempt)
+
+		switch {
+		case attempt.Error == ErrCircuitOpen.Error():
+			// a redelivery before the trial would find the circuit open again
+			select {
+			case <-ctx.Done():
+			case <-time.After(max(ep.cooldown(time.Now(), w.opt.BreakerCooldown), w.opt.Backoff)):
+			}
+			return fmt.Errorf("%w: %s to %s: %w", ErrUndelivered, msg.UUID, ep.ID, ErrCircuitOpen)
+		case attempt.Error == "" || !retry:
+			// a redelivery won't fix a non retryable failure
+			return nil
+		case n == w.opt.MaxAttempts:
+			return fmt.Errorf("%w: %s to %s after %d attempts", ErrUndelivered, msg.UUID, ep.ID, n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s to %s: %w", ErrUndelivered, msg.UUID, ep.ID, ctx.Err())
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > w.opt.MaxBackoff {
+			backoff = w.opt.MaxBackoff
+		}
+	}
+	return nil
+}
+
+// post sends msg to ep, it returns the status code and whether a failure may be retried
+func (w *Webhook) post(ctx context.Context, ep *endpoint, msg *common.Message) (int, bool, error) {
+	var timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(msg.Payload))
+	if err != nil {
+		return 0, false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range ep.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set(IDHeader, msg.UUID)
+	req.Header.Set(TopicHeader, ep.Topic)
+	req.Header.Set(TimestampHeader, timestamp)
+	if ep.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(ep.Secret, timestamp, msg.Payload))
+	}
+
+	resp, err := w.opt.Client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	var code = resp.StatusCode
+	if code >= 200 && code < 300 {
+		return code, false, nil
+	}
+
+	var retry = code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+	return code, retry, errors.New(resp.Status)
+}
+
+func (w *Webhook) record(a Attempt) {
+	w.attemptsMu.Lock()
+	defer w.attemptsMu.Unlock()
+
+	attempts, _ := w.opt.Attempts.Load(a.MessageID)
+	attempts = append(attempts, a)
+	if n := w.opt.MaxRecordAttempts; n > 0 && len(attempts) > n {
+		attempts = trim(attempts, n)
+	}
+	w.opt.Attempts.Store(a.MessageID, attempts)
+}
+
+// trim drops the oldest failed attempts down to n, the successful ones tell
+// the endpoints that received the message
+func trim(attempts []Attempt, n int) []Attempt {
+	var (
+		drop = len(attempts) - n
+		kept = make([]Attempt, 0, n)
+	)
+	for _, a := range attempts {
+		if drop > 0 && a.Error != "" {
+			drop--
+			continue
+		}
+		kept = append(kept, a)
+	}
+	return kept
+}
+
+// Sign returns the signature of body sent at timestamp, the hex HMAC-SHA256 of
+// "timestamp.body" prefixed with sha256=
+func Sign(secret, timestamp string, body []byte) string {
+	var mac = hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a delivered request body, for the receivers.
+// A request older or newer than Tolerance is rejected, it may be replayed.
+func Verify(secret string, header http.Header, body []byte) bool {
+	var timestamp = header.Get(TimestampHeader)
+	if Tolerance > 0 {
+		sec, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return false
+		}
+
+		if age := time.Since(time.Unix(sec, 0)); age > Tolerance || age < -Tolerance {
+			return false
+		}
+	}
+
+	var want = Sign(secret, timestamp, body)
+	return hmac.Equal([]byte(want), []byte(header.Get(SignatureHeader)))
+}
+
+// endpoint is an Endpoint with its circuit breaker
+type endpoint struct {
+	Endpoint
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// allow reports whether a delivery may be tried, an open circuit lets one trial through after cooldown
+func (e *endpoint) allow(now time.Time, cooldown time.Duration) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.openedAt.IsZero() {
+		return true
+	}
+	if e.trial || now.Sub(e.openedAt) < cooldown {
+		return false
+	}
+	e.trial = true
+	return true
+}
+
+// cooldown returns how long the circuit stays open, zero once a trial may go through
+func (e *endpoint) cooldown(now time.Time, cooldown time.Duration) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.openedAt.IsZero() {
+		return 0
+	}
+	return max(e.openedAt.Add(cooldown).Sub(now), 0)
+}
+
+func (e *endpoint) record(ok bool, now time.Time, threshold int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.trial = false
+	if ok {
+		e.failures = 0
+		e.openedAt = time.Time{}
+		return
+	}
+
+	if e.failures++; threshold > 0 && e.failures >= threshold {
+		e.openedAt = now
+	}
+}
+
+// records is the default attempts store, it keeps the most recent messages
+type records struct {
+	cache *lru.Cache[string, []Attempt]
+}
+
+func newRecords(size int) *records {
+	c, _ := lru.New[string, []Attempt](max(size, 1))
+	return &records{cache: c}
+}
+
+func (r *records) Load(key string) ([]Attempt, bool) {
+	return r.cache.Get(key)
+}
+
+func (r *records) Store(key string, val []Attempt) {
+	r.cache.Add(key, val)
+}
+
+func (r *records) LoadOrStore(key string, val []Attempt) ([]Attempt, bool) {
+	if actual, ok := r.cache.Get(key); ok {
+		return actual, true
+	}
+	r.cache.Add(key, val)
+	return val, false
+}
+
+func (r *records) Delete(key string) {
+	r.cache.Remove(key)
+}
+
+func (r *records) LoadAndDelete(key string) ([]Attempt, bool) {
+	val, ok := r.cache.Peek(key)
+	r.cache.Remove(key)
+	return val, ok
+}
+
+func (r *records) Range(f func(key string, val []Attempt) bool) {
+	for _, key := range r.cache.Keys() {
+		if val, ok := r.cache.Peek(key); ok && !f(key, val) {
+			return
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/hysios/x/events"
+	"github.com/hysios/x/events/driver/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliver(t *testing.T) {
+	var (
+		received = make(chan http.Header, 1)
+		srv      = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			if !Verify("secret", r.Header, body) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			assert.JSONEq(t, `{"id":1}`, string(body))
+			received <- r.Header
+		}))
+		bus         = events.NewBus(memory.New(memory.DefaultConfig), events.WithRouterOptions(events.WithSignals(false)))
+		hooks       = New(bus)
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer srv.Close()
+	defer bus.Close()
+	defer cancel()
+
+	assert.ErrorIs(t, hooks.Register(Endpoint{ID: "partner"}), ErrInvalidEndpoint)
+	assert.NoError(t, hooks.Register(Endpoint{
+		ID:      "partner",
+		Topic:   "order.paid",
+		URL:     srv.URL,
+		Secret:  "secret",
+		Headers: map[string]string{"X-Partner": "acme"},
+	}))
+	assert.Len(t, hooks.Endpoints("order.paid"), 1)
+
+	_, err := bus.Start(ctx)
+	assert.NoError(t, err)
+
+	var msg = message.NewMessage(watermill.NewUUID(), []byte(`{"id":1}`))
+	assert.NoError(t, bus.Publish("order.paid", msg))
+
+	select {
+	case header := <-received:
+		assert.Equal(t, msg.UUID, header.Get(IDHeader))
+		assert.Equal(t, "order.paid", header.Get(TopicHeader))
+		assert.Equal(t, "acme", header.Get("X-Partner"))
+	case <-time.After(time.Second):
+		t.Fatal("delivery timeout")
+	}
+
+	assert.Eventually(t, func() bool { return len(hooks.Attempts(msg.UUID)) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, http.StatusOK, hooks.Attempts(msg.UUID)[0].StatusCode)
+}
+
+func TestRetryAndBreaker(t *testing.T) {
+	var (
+		calls int32
+		srv   = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		hooks = New(events.NewBus(memory.New(memory.DefaultConfig)),
+			WithRetry(3, time.Millisecond, 2*time.Millisecond),
+			WithBreaker(4, time.Hour),
+		)
+		ctx = context.Background()
+	)
+	defer srv.Close()
+
+	hooks.mu.Lock()
+	hooks.endpoints["order.paid"] = map[string]*endpoint{"partner": {Endpoint: Endpoint{ID: "partner", Topic: "order.paid", URL: srv.URL}}}
+	hooks.mu.Unlock()
+
+	var first = message.NewMessage(watermill.NewUUID(), []byte(`{}`))
+	assert.ErrorIs(t, hooks.Deliver(ctx, "order.paid", first), ErrUndelivered)
+
+	var attempts = hooks.Attempts(first.UUID)
+	assert.Len(t, attempts, 3)
+	assert.Equal(t, 3, attempts[2].Attempt)
+	assert.Equal(t, http.StatusBadGateway, attempts[2].StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// the 4th failure opens the circuit, the delivery is given up once ctx is done
+	var (
+		second     = message.NewMessage(watermill.NewUUID(), []byte(`{}`))
+		wctx, stop = context.WithTimeout(ctx, 20*time.Millisecond)
+	)
+	defer stop()
+	assert.ErrorIs(t, hooks.Deliver(wctx, "order.paid", second), ErrUndelivered)
+	assert.ErrorIs(t, wctx.Err(), context.DeadlineExceeded)
+
+	attempts = hooks.Attempts(second.UUID)
+	assert.Len(t, attempts, 2)
+	assert.Equal(t, ErrCircuitOpen.Error(), attempts[1].Error)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+func TestNotRetryable(t *testing.T) {
+	var (
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		hooks = New(events.NewBus(memory.New(memory.DefaultConfig)), WithRetry(3, time.Millisecond, time.Millisecond))
+		msg   = message.NewMessage(watermill.NewUUID(), []byte(`{}`))
+	)
+	defer srv.Close()
+
+	hooks.endpoints["t"] = map[string]*endpoint{"a": {Endpoint: Endpoint{ID: "a", Topic: "t", URL: srv.URL}}}
+	// a redelivery won't fix a 4xx
+	assert.NoError(t, hooks.Deliver(context.Background(), "t", msg))
+	assert.Len(t, hooks.Attempts(msg.UUID), 1)
+
+	hooks.Unregister("t", "a")
+	assert.Empty(t, hooks.Endpoints("t"))
+}
+
+func TestBreakerTrial(t *testing.T) {
+	var (
+		ep  = &endpoint{}
+		now = time.Now()
+	)
+
+	ep.record(false, now, 1)
+	assert.False(t, ep.allow(now, time.Minute))
+
+	// one trial after the cooldown
+	assert.True(t, ep.allow(now.Add(time.Minute), time.Minute))
+	assert.False(t, ep.allow(now.Add(time.Minute), time.Minute))
+
+	ep.record(true, now, 1)
+	assert.True(t, ep.allow(now, time.Minute))
+}
+
+func TestRedeliver(t *testing.T) {
+	var (
+		fail  atomic.Bool
+		calls = make(map[string]int)
+		mu    sync.Mutex
+		srv   = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			calls[r.URL.Path]++
+			mu.Unlock()
+
+			if r.URL.Path == "/b" && fail.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		hooks = New(events.NewBus(memory.New(memory.DefaultConfig)), WithRetry(2, time.Millisecond, time.Millisecond))
+		msg   = message.NewMessage(watermill.NewUUID(), []byte(`{}`))
+	)
+	defer srv.Close()
+
+	hooks.endpoints["t"] = map[string]*endpoint{
+		"a": {Endpoint: Endpoint{ID: "a", Topic: "t", URL: srv.URL + "/a"}},
+		"b": {Endpoint: Endpoint{ID: "b", Topic: "t", URL: srv.URL + "/b"}},
+	}
+
+	fail.Store(true)
+	assert.ErrorIs(t, hooks.Deliver(context.Background(), "t", msg), ErrUndelivered)
+
+	// the redelivery only posts to the endpoint that failed
+	fail.Store(false)
+	assert.NoError(t, hooks.Deliver(context.Background(), "t", msg))
+	assert.Equal(t, map[string]int{"/a": 1, "/b": 3}, calls)
+}
+
+func TestVerifyTolerance(t *testing.T) {
+	var (
+		body   = []byte(`{}`)
+		header = make(http.Header)
+		sign   = func(at time.Time) {
+			var timestamp = strconv.FormatInt(at.Unix(), 10)
+			header.Set(TimestampHeader, timestamp)
+			header.Set(SignatureHeader, Sign("secret", timestamp, body))
+		}
+	)
+
+	sign(time.Now())
+	assert.True(t, Verify("secret", header, body))
+	assert.False(t, Verify("other", header, body))
+
+	// a captured request can't be replayed later
+	sign(time.Now().Add(-time.Hour))
+	assert.False(t, Verify("secret", header, body))
+}
+
+func TestRecordsBounded(t *testing.T) {
+	var r = newRecords(2)
+	for _, id := range []string{"m1", "m2", "m3"} {
+		r.Store(id, []Attempt{{MessageID: id}})
+	}
+
+	_, ok := r.Load("m1")
+	assert.False(t, ok)
+	_, ok = r.Load("m3")
+	assert.True(t, ok)
+}
+
+func TestBreakerCooldown(t *testing.T) {
+	var (
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		hooks = New(events.NewBus(memory.New(memory.DefaultConfig)),
+			WithRetry(1, time.Millisecond, time.Millisecond),
+			WithBreaker(1, 50*time.Millisecond),
+		)
+		msg = message.NewMessage(watermill.NewUUID(), []byte(`{}`))
+	)
+	defer srv.Close()
+
+	hooks.endpoints["t"] = map[string]*endpoint{"a": {Endpoint: Endpoint{ID: "a", Topic: "t", URL: srv.URL}}}
+	assert.ErrorIs(t, hooks.Deliver(context.Background(), "t", msg), ErrUndelivered)
+
+	// the nack of an open circuit waits for the trial instead of looping
+	var start = time.Now()
+	assert.ErrorIs(t, hooks.Deliver(context.Background(), "t", msg), ErrCircuitOpen)
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+	assert.Len(t, hooks.Attempts(msg.UUID), 2)
+}
+
+func TestRecordAttemptsBounded(t *testing.T) {
+	var hooks = New(events.NewBus(memory.New(memory.DefaultConfig)), func(o *Option) { o.MaxRecordAttempts = 3 })
+
+	hooks.record(Attempt{MessageID: "m", Endpoint: "a"})
+	for i := 1; i <= 5; i++ {
+		hooks.record(Attempt{MessageID: "m", Endpoint: "b", Attempt: i, Error: "502 Bad Gateway"})
+	}
+
+	// the success is kept with the latest failures
+	var attempts = hooks.Attempts("m")
+	assert.Len(t, attempts, 3)
+	assert.Equal(t, "a", attempts[0].Endpoint)
+	assert.Equal(t, 4, attempts[1].Attempt)
+	assert.True(t, hooks.delivered("m", "a"))
+}
+
+func TestWebhooksShareBus(t *testing.T) {
+	var bus = events.NewBus(memory.New(memory.DefaultConfig), events.WithRouterOptions(events.WithSignals(false)))
+	defer bus.Close()
+
+	// every webhook registers its own handlers
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, New(bus).Register(Endpoint{ID: "partner", Topic: "order.paid", URL: "http://localhost"}))
+	}
+}
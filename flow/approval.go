@@ -0,0 +1,404 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/hysios/x/events"
+	"github.com/hysios/x/events/common"
+	"go.uber.org/zap"
+)
+
+// Rule 审批规则
+type Rule int
+
+const (
+	// AnyOf 或签, the approval of one assignee completes the step
+	AnyOf Rule = iota
+	// AllOf 会签, every assignee approves the step
+	AllOf
+	// Sequential 依次审批, the assignees approve the step one after the other in order
+	Sequential
+)
+
+func (r Rule) String() string {
+	switch r {
+	case AnyOf:
+		return "any_of"
+	case AllOf:
+		return "all_of"
+	case Sequential:
+		return "sequential"
+	default:
+		return fmt.Sprintf("Rule(%d)", int(r))
+	}
+}
+
+// 审批状态, the Status of an Approval
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+)
+
+// 审批事件, the Event names of the notices and of the history
+const (
+	EventSubmitted = "submitted"
+	EventApproved  = "approved"
+	EventRejected  = "rejected"
+	// EventCompleted is noticed when the last step is approved
+	EventCompleted = "completed"
+)
+
+// ApprovalFlow 审批流程, the steps are approved in order
+type ApprovalFlow struct {
+	Name  string
+	Steps []ApprovalStep
+}
+
+// ApprovalStep 审批步骤
+type ApprovalStep struct {
+	Name string
+	// Assignees 审批人, the participants of the approval when empty
+	Assignees []string
+	// Rule 审批规则, how the assignees approve the step
+	Rule Rule
+}
+
+// Record 审批记录
+type Record struct {
+	Step    string
+	User    string
+	Event   string
+	Comment string
+	At      time.Time
+}
+
+// Approval 审批实例, the embedded Event holds its id, status, owner and watchers
+type Approval struct {
+	Event
+	// Step is the index of the current step
+	Step int
+	// Approved are the users who approved the current step
+	Approved []string
+	// Waiting are the users who have to approve the current step
+	Waiting []string
+	History []Record
+}
+
+// Task 待办
+type Task struct {
+	FlowId    string
+	Flow      string
+	Step      string
+	Owner     string
+	CreatedAt time.Time
+}
+
+// Notice is published to the owner and the watchers on every change of an approval
+type Notice struct {
+	Event
+	Step    string
+	User    string
+	Comment string
+	Waiting []string
+}
+
+type WorkflowOption struct {
+	// Publisher publishes the notices, the events.DefaultBus when nil
+	Publisher common.Publisher
+	// Topic of the notices
+	Topic string
+	Now   func() time.Time
+	// Logger logs the notices failing after the approval is saved
+	Logger *zap.Logger
+}
+
+type WorkflowOpt func(*WorkflowOption)
+
+var DefaultWorkflowOption = WorkflowOption{
+	Topic:  "flow.approval",
+	Now:    time.Now,
+	Logger: zap.NewNop(),
+}
+
+// WithNoticePublisher
+func WithNoticePublisher(pub common.Publisher) WorkflowOpt {
+	return func(o *WorkflowOption) {
+		o.Publisher = pub
+	}
+}
+
+// WithNoticeTopic
+func WithNoticeTopic(topic string) WorkflowOpt {
+	return func(o *WorkflowOption) {
+		o.Topic = topic
+	}
+}
+
+// WithWorkflowClock
+func WithWorkflowClock(now func() time.Time) WorkflowOpt {
+	return func(o *WorkflowOption) {
+		o.Now = now
+	}
+}
+
+// WithWorkflowLogger
+func WithWorkflowLogger(log *zap.Logger) WorkflowOpt {
+	return func(o *WorkflowOption) {
+		o.Logger = log
+	}
+}
+
+// Workflow 审批流程引擎. The steps of a flow are approved in order by their
+// assignees following the Rule of the step, one rejection rejects the approval.
+type Workflow struct {
+	store ApprovalStore
+	opt   WorkflowOption
+
+	mu    sync.RWMutex
+	flows map[string]*ApprovalFlow
+	locks sync.Map
+}
+
+// NewWorkflow
+func NewWorkflow(store ApprovalStore, opts ...WorkflowOpt) *Workflow {
+	var opt = DefaultWorkflowOption
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return &Workflow{store: store, opt: opt, flows: make(map[string]*ApprovalFlow)}
+}
+
+// Register 注册流程
+func (w *Workflow) Register(flows ...*ApprovalFlow) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, f := range flows {
+		if _, ok := w.flows[f.Name]; ok {
+			return fmt.Errorf("%w: %s", ErrDuplicateFlow, f.Name)
+		}
+		w.flows[f.Name] = f
+	}
+	return nil
+}
+
+func (w *Workflow) flow(name string) (*ApprovalFlow, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	f, ok := w.flows[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrFlowNotFound, name)
+	}
+	return f, nil
+}
+
+func (w *Workflow) lock(id string) func() {
+	v, _ := w.locks.LoadOrStore(id, &sync.Mutex{})
+	var mu = v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// Load 查询审批
+func (w *Workflow) Load(ctx context.Context, id string) (*Approval, error) {
+	return w.store.Load(ctx, id)
+}
+
+// History 审批历史
+func (w *Workflow) History(ctx context.Context, id string) ([]Record, error) {
+	a, err := w.store.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return a.History, nil
+}
+
+// Pending 待办, the tasks waiting for the approval of user
+func (w *Workflow) Pending(ctx context.Context, user string) ([]Task, error) {
+	approvals, err := w.store.Pending(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks = make([]Task, 0, len(approvals))
+	for _, a := range approvals {
+		var task = Task{FlowId: a.FlowId, Flow: a.Flow, Owner: a.Owner, CreatedAt: a.UpdatedAt}
+		if f, err := w.flow(a.Flow); err == nil && a.Step < len(f.Steps) {
+			task.Step = f.Steps[a.Step].Name
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// Submit 发起审批 of evt.Flow by evt.Owner, the id is evt.FlowId or a new one.
+// The steps without assignees are approved by evt.Participants.
+func (w *Workflow) Submit(ctx context.Context, evt Event) (*Approval, error) {
+	f, err := w.flow(evt.Flow)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Steps) == 0 {
+		return nil, fmt.Errorf("flow: %s has no steps", f.Name)
+	}
+	for _, step := range f.Steps {
+		if len(step.Assignees) == 0 && len(evt.Participants) == 0 {
+			return nil, fmt.Errorf("flow: step %s has no assignees", step.Name)
+		}
+	}
+
+	if evt.FlowId == "" {
+		evt.FlowId = watermill.NewUUID()
+	}
+
+	defer w.lock(evt.FlowId)()
+
+	if _, err := w.store.Load(ctx, evt.FlowId); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrDuplicateFlow, evt.FlowId)
+	} else if !errors.Is(err, ErrInstanceNotFound) {
+		return nil, err
+	}
+
+	var now = w.opt.Now()
+	var a = &Approval{Event: evt}
+	a.Status = ApprovalPending
+	a.StartAt = now
+	a.EndAt = time.Time{}
+	a.Waiting = w.waiting(f, a)
+
+	return a, w.commit(ctx, f, a, Record{Step: f.Steps[0].Name, User: evt.Owner, Event: EventSubmitted, At: now})
+}
+
+// Approve 同意, the approval advances to the next step once the rule of the step is met
+func (w *Workflow) Approve(ctx context.Context, id, user, comment string) (*Approval, error) {
+	return w.decide(ctx, id, user, comment, EventApproved)
+}
+
+// Reject 驳回, the approval is rejected
+func (w *Workflow) Reject(ctx context.Context, id, user, comment string) (*Approval, error) {
+	return w.decide(ctx, id, user, comment, EventRejected)
+}
+
+func (w *Workflow) decide(ctx context.Context, id, user, comment, event string) (*Approval, error) {
+	defer w.lock(id)()
+
+	a, err := w.store.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if a.Status != ApprovalPending {
+		return nil, fmt.Errorf("%w: %s is %s", ErrFinished, id, a.Status)
+	}
+
+	f, err := w.flow(a.Flow)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(a.Waiting, user) {
+		return nil, fmt.Errorf("%w: %s", ErrNotAssignee, user)
+	}
+
+	var (
+		now  = w.opt.Now()
+		step = f.Steps[a.Step]
+		rec  = Record{Step: step.Name, User: user, Event: event, Comment: comment, At: now}
+	)
+
+	if event == EventRejected {
+		a.Status = ApprovalRejected
+		a.EndAt = now
+		a.Waiting = nil
+		return a, w.commit(ctx, f, a, rec)
+	}
+
+	a.Approved = append(a.Approved, user)
+	if step.Rule == AnyOf || len(w.waiting(f, a)) == 0 {
+		a.Step++
+		a.Approved = nil
+	}
+
+	if a.Step == len(f.Steps) {
+		a.Status = ApprovalApproved
+		a.EndAt = now
+		a.Waiting = nil
+		if err := w.commit(ctx, f, a, rec); err != nil {
+			return a, err
+		}
+		w.notify(ctx, a, Record{Step: step.Name, User: user, Event: EventCompleted, At: now})
+		return a, nil
+	}
+
+	a.Waiting = w.waiting(f, a)
+	return a, w.commit(ctx, f, a, rec)
+}
+
+// waiting returns the users who have to approve the current step of a
+func (w *Workflow) waiting(f *ApprovalFlow, a *Approval) []string {
+	var step = f.Steps[a.Step]
+
+	var assignees = step.Assignees
+	if len(assignees) == 0 {
+		assignees = a.Participants
+	}
+
+	var waiting []string
+	for _, user := range assignees {
+		if slices.Contains(a.Approved, user) {
+			continue
+		}
+		waiting = append(waiting, user)
+		if step.Rule == Sequential {
+			break
+		}
+	}
+	return waiting
+}
+
+// commit records rec in the history of a, saves a and notices the change
+func (w *Workflow) commit(ctx context.Context, f *ApprovalFlow, a *Approval, rec Record) error {
+	a.EventId = watermill.NewUUID()
+	a.Event.Event = rec.Event
+	a.UpdatedAt = rec.At
+	a.History = append(a.History, rec)
+
+	if err := w.store.Save(ctx, a); err != nil {
+		return err
+	}
+	w.notify(ctx, a, rec)
+	return nil
+}
+
+// notify publishes the notice of rec, a failure is logged: the change is
+// saved already and deciding again would fail
+func (w *Workflow) notify(ctx context.Context, a *Approval, rec Record) {
+	if err := w.publish(ctx, a, rec); err != nil {
+		w.opt.Logger.Error("flow: notify", zap.String("flow_id", a.FlowId), zap.String("event", rec.Event), zap.Error(err))
+	}
+}
+
+func (w *Workflow) publish(ctx context.Context, a *Approval, rec Record) error {
+	var pub = w.opt.Publisher
+	if pub == nil {
+		pub = events.DefaultBus()
+	}
+
+	var notice = Notice{Event: a.Event, Step: rec.Step, User: rec.User, Comment: rec.Comment, Waiting: a.Waiting}
+	notice.Event.Event = rec.Event
+
+	msg, err := events.NewMessage(notice)
+	if err != nil {
+		return err
+	}
+	msg.SetContext(ctx)
+
+	return pub.Publish(w.opt.Topic, msg)
+}
@@ -0,0 +1,173 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/hysios/x/events/eventstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func leaveFlow() *ApprovalFlow {
+	return &ApprovalFlow{
+		Name: "leave",
+		Steps: []ApprovalStep{
+			{Name: "manager", Assignees: []string{"m1", "m2"}, Rule: AnyOf},
+			{Name: "hr", Assignees: []string{"h1", "h2"}, Rule: AllOf},
+			{Name: "board", Rule: Sequential},
+		},
+	}
+}
+
+func testWorkflow(t *testing.T) *Workflow {
+	eventstest.NewBus(t)
+
+	var w = NewWorkflow(NewMemoryApprovalStore())
+	assert.NoError(t, w.Register(leaveFlow()))
+	return w
+}
+
+func TestWorkflowApprove(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		w       = testWorkflow(t)
+		notices = eventstest.Expect(t, DefaultWorkflowOption.Topic)
+	)
+
+	_, err := w.Submit(ctx, Event{Flow: "leave", Owner: "alice"})
+	assert.ErrorContains(t, err, "step board has no assignees")
+
+	a, err := w.Submit(ctx, Event{Flow: "leave", FlowId: "l1", Owner: "alice", Watchs: []string{"bob"}, Participants: []string{"c1", "c2"}})
+	assert.NoError(t, err)
+	assert.Equal(t, ApprovalPending, a.Status)
+	assert.Equal(t, []string{"m1", "m2"}, a.Waiting)
+
+	_, err = w.Submit(ctx, Event{Flow: "leave", FlowId: "l1", Participants: []string{"c1"}})
+	assert.ErrorIs(t, err, ErrDuplicateFlow)
+
+	tasks, err := w.Pending(ctx, "m1")
+	assert.NoError(t, err)
+	assert.Equal(t, []Task{{FlowId: "l1", Flow: "leave", Step: "manager", Owner: "alice", CreatedAt: a.UpdatedAt}}, tasks)
+
+	_, err = w.Approve(ctx, "l1", "h1", "")
+	assert.ErrorIs(t, err, ErrNotAssignee)
+
+	// any of the managers
+	a, err = w.Approve(ctx, "l1", "m2", "ok")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, a.Step)
+	assert.Equal(t, []string{"h1", "h2"}, a.Waiting)
+
+	tasks, _ = w.Pending(ctx, "m1")
+	assert.Empty(t, tasks)
+
+	// all of hr
+	a, err = w.Approve(ctx, "l1", "h1", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, a.Step)
+	assert.Equal(t, []string{"h2"}, a.Waiting)
+
+	_, err = w.Approve(ctx, "l1", "h1", "")
+	assert.ErrorIs(t, err, ErrNotAssignee)
+
+	a, err = w.Approve(ctx, "l1", "h2", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, a.Step)
+
+	// the participants one after the other
+	assert.Equal(t, []string{"c1"}, a.Waiting)
+	tasks, _ = w.Pending(ctx, "c2")
+	assert.Empty(t, tasks)
+
+	_, err = w.Approve(ctx, "l1", "c1", "")
+	assert.NoError(t, err)
+	tasks, _ = w.Pending(ctx, "c2")
+	assert.Len(t, tasks, 1)
+
+	a, err = w.Approve(ctx, "l1", "c2", "done")
+	assert.NoError(t, err)
+	assert.Equal(t, ApprovalApproved, a.Status)
+	assert.False(t, a.EndAt.IsZero())
+	assert.Empty(t, a.Waiting)
+
+	_, err = w.Approve(ctx, "l1", "c2", "")
+	assert.ErrorIs(t, err, ErrFinished)
+
+	history, err := w.History(ctx, "l1")
+	assert.NoError(t, err)
+
+	var users []string
+	for _, rec := range history {
+		users = append(users, rec.Event+":"+rec.User)
+	}
+	assert.Equal(t, []string{"submitted:alice", "approved:m2", "approved:h1", "approved:h2", "approved:c1", "approved:c2"}, users)
+	assert.Equal(t, "ok", history[1].Comment)
+
+	var events []string
+	for _, msg := range notices.Count(7) {
+		var n Notice
+		assert.NoError(t, json.Unmarshal(msg.Payload, &n))
+		assert.Equal(t, "alice", n.Owner)
+		assert.Equal(t, []string{"bob"}, n.Watchs)
+		events = append(events, n.Event.Event)
+	}
+	assert.ElementsMatch(t, []string{"submitted", "approved", "approved", "approved", "approved", "approved", "completed"}, events)
+}
+
+func TestWorkflowReject(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		w       = testWorkflow(t)
+		notices = eventstest.Expect(t, DefaultWorkflowOption.Topic)
+	)
+
+	_, err := w.Submit(ctx, Event{Flow: "leave", FlowId: "l1", Owner: "alice", Participants: []string{"c1"}})
+	assert.NoError(t, err)
+
+	a, err := w.Reject(ctx, "l1", "m1", "too long")
+	assert.NoError(t, err)
+	assert.Equal(t, ApprovalRejected, a.Status)
+	assert.Equal(t, EventRejected, a.Event.Event)
+
+	tasks, _ := w.Pending(ctx, "m2")
+	assert.Empty(t, tasks)
+
+	_, err = w.Approve(ctx, "l1", "m2", "")
+	assert.ErrorIs(t, err, ErrFinished)
+	_, err = w.Approve(ctx, "l2", "m2", "")
+	assert.ErrorIs(t, err, ErrInstanceNotFound)
+
+	notices.Payload(Notice{Event: a.Event, Step: "manager", User: "m1", Comment: "too long"})
+}
+
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(topic string, messages ...*message.Message) error {
+	return errors.New("broker down")
+}
+
+func (failingPublisher) Close() error {
+	return nil
+}
+
+func TestWorkflowNotifyFailure(t *testing.T) {
+	var (
+		ctx = context.Background()
+		w   = NewWorkflow(NewMemoryApprovalStore(), WithNoticePublisher(failingPublisher{}))
+	)
+	assert.NoError(t, w.Register(&ApprovalFlow{Name: "expense", Steps: []ApprovalStep{{Name: "manager", Assignees: []string{"m1"}}}}))
+
+	// the notices are lost, the decisions are saved
+	_, err := w.Submit(ctx, Event{Flow: "expense", FlowId: "e1", Owner: "alice"})
+	assert.NoError(t, err)
+	a, err := w.Approve(ctx, "e1", "m1", "")
+	assert.NoError(t, err)
+	assert.Equal(t, ApprovalApproved, a.Status)
+
+	a, err = w.Load(ctx, "e1")
+	assert.NoError(t, err)
+	assert.Equal(t, ApprovalApproved, a.Status)
+}
@@ -0,0 +1,13 @@
+package flow
+
+import "errors"
+
+var (
+	ErrFlowNotFound     = errors.New("flow: flow not found")
+	ErrInstanceNotFound = errors.New("flow: instance not found")
+	ErrDuplicateFlow    = errors.New("flow: duplicate flow")
+	ErrTimeout          = errors.New("flow: step timeout")
+	ErrStepFailed       = errors.New("flow: step failed")
+	ErrFinished         = errors.New("flow: instance finished")
+	ErrNotAssignee      = errors.New("flow: not an assignee of the step")
+)
@@ -0,0 +1,58 @@
+package flow
+
+import (
+	"fmt"
+	"strings"
+)
+
+// label is the event of t with its guard
+func (t *Transition) label() string {
+	if t.Guard == "" {
+		return t.Event
+	}
+	return fmt.Sprintf("%s [%s]", t.Event, t.Guard)
+}
+
+// DOT exports the machine as a Graphviz digraph
+func (m *Machine) DOT() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "digraph %q {\n", m.Name)
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\t\"\" [shape=point];\n")
+	for _, s := range m.States {
+		var shape = "circle"
+		if s.Final {
+			shape = "doublecircle"
+		}
+		fmt.Fprintf(&b, "\t%q [shape=%s];\n", s.Name, shape)
+	}
+
+	fmt.Fprintf(&b, "\t\"\" -> %q;\n", m.Initial)
+	for _, t := range m.Transitions {
+		for _, from := range t.From {
+			fmt.Fprintf(&b, "\t%q -> %q [label=%q];\n", from, t.To, t.label())
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid exports the machine as a Mermaid state diagram
+func (m *Machine) Mermaid() string {
+	var b strings.Builder
+
+	b.WriteString("stateDiagram-v2\n")
+	fmt.Fprintf(&b, "    [*] --> %s\n", m.Initial)
+	for _, t := range m.Transitions {
+		for _, from := range t.From {
+			fmt.Fprintf(&b, "    %s --> %s: %s\n", from, t.To, t.label())
+		}
+	}
+	for _, s := range m.States {
+		if s.Final {
+			fmt.Fprintf(&b, "    %s --> [*]\n", s.Name)
+		}
+	}
+	return b.String()
+}
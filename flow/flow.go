@@ -1,6 +1,9 @@
 package flow
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type Event struct {
 	FlowId  string // flow id 流程 id
@@ -22,12 +25,40 @@ type Event struct {
 	UpdatedAt time.Time
 }
 
+// Flow 流程, 由有序的步骤组成
 type Flow struct {
 	Name string
+	// Start is the event name starting an instance of the flow 启动流程的事件
+	Start string
+	Steps []Step
+	// Machine 状态机, the flow defined as states and transitions
+	Machine *Machine
 }
 
+// Action runs on the instance of a flow, the changes of inst.Data are saved
+type Action func(ctx context.Context, inst *Instance) error
+
+// Step 步骤
 type Step struct {
 	Name string
+	// Action 执行动作
+	Action Action
+	// Compensate 补偿动作, undoes Action when a later step fails
+	Compensate Action
+	// Await is the event name completing the step, the step is completed by its Action otherwise
+	Await string
+	// Fail is the event name failing the step
+	Fail string
+	// Timeout fails the step when it isn't completed in time
+	Timeout time.Duration
 }
 
-// RegisterEvent 注册事件
+// Step returns the step name of f
+func (f *Flow) Step(name string) (*Step, bool) {
+	for i := range f.Steps {
+		if f.Steps[i].Name == name {
+			return &f.Steps[i], true
+		}
+	}
+	return nil, false
+}
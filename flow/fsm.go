@@ -0,0 +1,400 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrIllegalTransition no transition of the current state is triggered by the event
+	ErrIllegalTransition = errors.New("flow: illegal transition")
+	// ErrGuardRejected every guard of the matching transitions rejected the event
+	ErrGuardRejected = errors.New("flow: guard rejected")
+	// ErrUnknownState the current state isn't a state of the machine
+	ErrUnknownState = errors.New("flow: unknown state")
+	// ErrHookFailed an entry or exit hook failed, the transition is aborted
+	ErrHookFailed = errors.New("flow: hook failed")
+	ErrNoMachine  = errors.New("flow: flow has no state machine")
+)
+
+// TransitionError is returned when event can't move an Event out of State
+type TransitionError struct {
+	Machine string
+	State   string
+	Event   string
+	// Err is ErrIllegalTransition, ErrGuardRejected, ErrUnknownState or ErrHookFailed
+	Err error
+	// Cause is the error of the guard or of the hook
+	Cause error
+}
+
+func (e *TransitionError) Error() string {
+	var msg = fmt.Sprintf("%s: %s on %s in state %s", e.Err, e.Machine, e.Event, e.State)
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+func (e *TransitionError) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Err, e.Cause}
+}
+
+// GuardFunc allows a transition when it returns nil
+type GuardFunc func(ctx context.Context, evt *Event) error
+
+// HookFunc runs when a state is entered or left, an error aborts the transition
+type HookFunc func(ctx context.Context, evt *Event) error
+
+// State 状态
+type State struct {
+	Name string `yaml:"name"`
+	// Final states end the flow, EndAt is set when one is entered
+	Final bool `yaml:"final,omitempty"`
+	// Entry and Exit are the names of the hooks
+	Entry []string `yaml:"entry,omitempty"`
+	Exit  []string `yaml:"exit,omitempty"`
+}
+
+// Transition 状态迁移 triggered by the event name
+type Transition struct {
+	Event string `yaml:"event"`
+	From  names  `yaml:"from"`
+	To    string `yaml:"to"`
+	// Guard is the name of the guard
+	Guard string `yaml:"guard,omitempty"`
+}
+
+// names is a name or a list of names in YAML
+type names []string
+
+func (n *names) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*n = names{value.Value}
+		return nil
+	}
+
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*n = list
+	return nil
+}
+
+// Machine 状态机. The current state of an Event is its Status, an empty
+// Status is the Initial state.
+//
+//	m := flow.NewMachine("order", "created").
+//		State("paid", flow.OnEntry("notify", notify)).
+//		State("shipped", flow.Final()).
+//		Transition("pay", "created", "paid", flow.When("has_balance", hasBalance)).
+//		Transition("ship", "paid", "shipped")
+type Machine struct {
+	Name        string        `yaml:"name"`
+	Initial     string        `yaml:"initial"`
+	States      []*State      `yaml:"states"`
+	Transitions []*Transition `yaml:"transitions"`
+
+	guards map[string]GuardFunc
+	hooks  map[string]HookFunc
+	now    func() time.Time
+}
+
+type MachineOpt func(*Machine)
+
+// WithGuard registers the guard name referenced by the transitions
+func WithGuard(name string, fn GuardFunc) MachineOpt {
+	return func(m *Machine) {
+		m.guards[name] = fn
+	}
+}
+
+// WithHook registers the hook name referenced by the states
+func WithHook(name string, fn HookFunc) MachineOpt {
+	return func(m *Machine) {
+		m.hooks[name] = fn
+	}
+}
+
+// WithMachineClock
+func WithMachineClock(now func() time.Time) MachineOpt {
+	return func(m *Machine) {
+		m.now = now
+	}
+}
+
+func (m *Machine) init(opts []MachineOpt) {
+	m.guards = make(map[string]GuardFunc)
+	m.hooks = make(map[string]HookFunc)
+	m.now = time.Now
+	for _, o := range opts {
+		o(m)
+	}
+}
+
+// NewMachine returns a machine with the initial state
+func NewMachine(name, initial string, opts ...MachineOpt) *Machine {
+	var m = &Machine{Name: name, Initial: initial}
+	m.init(opts)
+	return m.State(initial)
+}
+
+// LoadMachine decodes a machine from YAML, the guards and the hooks it
+// references are registered with opts.
+//
+//	name: order
+//	initial: created
+//	states:
+//	  - name: created
+//	  - name: paid
+//	    entry: [notify]
+//	  - name: shipped
+//	    final: true
+//	transitions:
+//	  - event: pay
+//	    from: created
+//	    to: paid
+//	    guard: has_balance
+func LoadMachine(data []byte, opts ...MachineOpt) (*Machine, error) {
+	var m = &Machine{}
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("flow: decode machine: %w", err)
+	}
+	m.init(opts)
+
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+type StateOpt func(m *Machine, s *State)
+
+// Final marks the state as final
+func Final() StateOpt {
+	return func(m *Machine, s *State) {
+		s.Final = true
+	}
+}
+
+// OnEntry adds the hook name run when the state is entered
+func OnEntry(name string, fn HookFunc) StateOpt {
+	return func(m *Machine, s *State) {
+		m.hooks[name] = fn
+		s.Entry = append(s.Entry, name)
+	}
+}
+
+// OnExit adds the hook name run when the state is left
+func OnExit(name string, fn HookFunc) StateOpt {
+	return func(m *Machine, s *State) {
+		m.hooks[name] = fn
+		s.Exit = append(s.Exit, name)
+	}
+}
+
+// State adds the state name, or changes it when it exists
+func (m *Machine) State(name string, opts ...StateOpt) *Machine {
+	s, ok := m.state(name)
+	if !ok {
+		s = &State{Name: name}
+		m.States = append(m.States, s)
+	}
+
+	for _, o := range opts {
+		o(m, s)
+	}
+	return m
+}
+
+type TransitionOpt func(m *Machine, t *Transition)
+
+// When guards the transition with the guard name
+func When(name string, fn GuardFunc) TransitionOpt {
+	return func(m *Machine, t *Transition) {
+		m.guards[name] = fn
+		t.Guard = name
+	}
+}
+
+// Transition adds the transition from the state from to the state to triggered
+// by event, the states are added when they aren't declared yet
+func (m *Machine) Transition(event, from, to string, opts ...TransitionOpt) *Machine {
+	m.State(from).State(to)
+
+	var t = &Transition{Event: event, From: names{from}, To: to}
+	for _, o := range opts {
+		o(m, t)
+	}
+
+	m.Transitions = append(m.Transitions, t)
+	return m
+}
+
+func (m *Machine) state(name string) (*State, bool) {
+	for _, s := range m.States {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Validate checks the states, the guards and the hooks referenced by the machine exist
+func (m *Machine) Validate() error {
+	var errs []error
+	if _, ok := m.state(m.Initial); !ok {
+		errs = append(errs, fmt.Errorf("initial state %q is not declared", m.Initial))
+	}
+
+	for _, s := range m.States {
+		for _, hook := range slices.Concat(s.Entry, s.Exit) {
+			if _, ok := m.hooks[hook]; !ok {
+				errs = append(errs, fmt.Errorf("state %s: unknown hook %q", s.Name, hook))
+			}
+		}
+	}
+
+	for _, t := range m.Transitions {
+		for _, name := range append(slices.Clone(t.From), t.To) {
+			if _, ok := m.state(name); !ok {
+				errs = append(errs, fmt.Errorf("transition %s: unknown state %q", t.Event, name))
+			}
+		}
+		if _, ok := m.guards[t.Guard]; t.Guard != "" && !ok {
+			errs = append(errs, fmt.Errorf("transition %s: unknown guard %q", t.Event, t.Guard))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("flow: invalid machine %s: %w", m.Name, errors.Join(errs...))
+	}
+	return nil
+}
+
+// current returns the state of evt, the initial state when its Status is empty
+func (m *Machine) current(evt *Event) string {
+	if evt.Status == "" {
+		return m.Initial
+	}
+	return evt.Status
+}
+
+// Events lists the events the state of evt has transitions for
+func (m *Machine) Events(evt *Event) []string {
+	var (
+		state  = m.current(evt)
+		events []string
+	)
+	for _, t := range m.Transitions {
+		if slices.Contains(t.From, state) && !slices.Contains(events, t.Event) {
+			events = append(events, t.Event)
+		}
+	}
+	return events
+}
+
+// Can reports whether event would move evt to another state
+func (m *Machine) Can(ctx context.Context, evt *Event, event string) bool {
+	_, err := m.transition(ctx, evt, event)
+	return err == nil
+}
+
+// transition returns the first transition of the state of evt triggered by event whose guard allows it
+func (m *Machine) transition(ctx context.Context, evt *Event, event string) (*Transition, error) {
+	var state = m.current(evt)
+	var terr = &TransitionError{Machine: m.Name, State: state, Event: event, Err: ErrIllegalTransition}
+
+	if _, ok := m.state(state); !ok {
+		terr.Err = ErrUnknownState
+		return nil, terr
+	}
+
+	for _, t := range m.Transitions {
+		if t.Event != event || !slices.Contains(t.From, state) {
+			continue
+		}
+		// a machine built by hand may skip Validate
+		if _, ok := m.state(t.To); !ok {
+			terr.Err = ErrUnknownState
+			terr.Cause = fmt.Errorf("transition to %q", t.To)
+			continue
+		}
+
+		if guard, ok := m.guards[t.Guard]; ok {
+			if err := guard(ctx, evt); err != nil {
+				terr.Err = ErrGuardRejected
+				terr.Cause = fmt.Errorf("%s: %w", t.Guard, err)
+				continue
+			}
+		}
+		return t, nil
+	}
+	return nil, terr
+}
+
+// Fire moves evt to the next state with event: the exit hooks of the current
+// state run, then the entry hooks of the next one. evt.Event is set to event
+// and UpdatedAt to the time of the transition, EndAt when the state is final.
+// evt is unchanged when a hook fails.
+func (m *Machine) Fire(ctx context.Context, evt *Event, event string) error {
+	t, err := m.transition(ctx, evt, event)
+	if err != nil {
+		return err
+	}
+
+	var (
+		next     = *evt
+		from, _  = m.state(m.current(evt))
+		to, _    = m.state(t.To)
+		now      = m.now()
+		hookFail = func(name string, err error) error {
+			return &TransitionError{Machine: m.Name, State: from.Name, Event: event, Err: ErrHookFailed, Cause: fmt.Errorf("%s: %w", name, err)}
+		}
+	)
+
+	// a new Event is in the initial state
+	next.Status = from.Name
+	if next.StartAt.IsZero() {
+		next.StartAt = now
+	}
+
+	for _, name := range from.Exit {
+		if err := m.hooks[name](ctx, &next); err != nil {
+			return hookFail(name, err)
+		}
+	}
+
+	next.Status = to.Name
+	next.Event = event
+	next.UpdatedAt = now
+	if to.Final {
+		next.EndAt = now
+	}
+
+	for _, name := range to.Entry {
+		if err := m.hooks[name](ctx, &next); err != nil {
+			return hookFail(name, err)
+		}
+	}
+
+	*evt = next
+	return nil
+}
+
+// Fire fires event on evt with the machine of f
+func (f *Flow) Fire(ctx context.Context, evt *Event, event string) error {
+	if f.Machine == nil {
+		return fmt.Errorf("%w: %s", ErrNoMachine, f.Name)
+	}
+	return f.Machine.Fire(ctx, evt, event)
+}
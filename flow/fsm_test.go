@@ -0,0 +1,211 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errNoBalance = errors.New("no balance")
+
+func orderMachine(calls *[]string, now time.Time) *Machine {
+	var hook = func(name string) HookFunc {
+		return func(ctx context.Context, evt *Event) error {
+			*calls = append(*calls, name+":"+evt.Status)
+			return nil
+		}
+	}
+
+	return NewMachine("order", "created", WithMachineClock(func() time.Time { return now })).
+		State("created", OnExit("leave_created", hook("leave_created"))).
+		State("paid", OnEntry("notify", hook("notify"))).
+		State("shipped", Final()).
+		State("cancelled", Final()).
+		Transition("pay", "created", "paid", When("has_balance", func(ctx context.Context, evt *Event) error {
+			if evt.Owner == "poor" {
+				return errNoBalance
+			}
+			return nil
+		})).
+		Transition("ship", "paid", "shipped").
+		Transition("cancel", "created", "cancelled").
+		Transition("cancel", "paid", "cancelled")
+}
+
+func TestMachineFire(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		now   = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		calls []string
+		m     = orderMachine(&calls, now)
+		evt   = &Event{Flow: "order", FlowId: "o1", Owner: "alice"}
+	)
+	assert.NoError(t, m.Validate())
+
+	assert.Equal(t, []string{"pay", "cancel"}, m.Events(evt))
+	assert.True(t, m.Can(ctx, evt, "pay"))
+	assert.False(t, m.Can(ctx, evt, "ship"))
+
+	assert.NoError(t, m.Fire(ctx, evt, "pay"))
+	assert.Equal(t, "paid", evt.Status)
+	assert.Equal(t, "pay", evt.Event)
+	assert.Equal(t, now, evt.StartAt)
+	assert.Equal(t, now, evt.UpdatedAt)
+	assert.True(t, evt.EndAt.IsZero())
+	assert.Equal(t, []string{"leave_created:created", "notify:paid"}, calls)
+
+	var terr *TransitionError
+	err := m.Fire(ctx, evt, "pay")
+	assert.ErrorIs(t, err, ErrIllegalTransition)
+	assert.ErrorAs(t, err, &terr)
+	assert.Equal(t, "paid", terr.State)
+	assert.Equal(t, "pay", terr.Event)
+	assert.Equal(t, "flow: illegal transition: order on pay in state paid", err.Error())
+
+	assert.NoError(t, m.Fire(ctx, evt, "ship"))
+	assert.Equal(t, "shipped", evt.Status)
+	assert.Equal(t, now, evt.EndAt)
+
+	assert.ErrorIs(t, m.Fire(ctx, &Event{Status: "lost"}, "ship"), ErrUnknownState)
+}
+
+func TestMachineGuard(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		calls []string
+		m     = orderMachine(&calls, time.Now())
+		evt   = &Event{Owner: "poor"}
+	)
+
+	err := m.Fire(ctx, evt, "pay")
+	assert.ErrorIs(t, err, ErrGuardRejected)
+	assert.ErrorIs(t, err, errNoBalance)
+	assert.Equal(t, "flow: guard rejected: order on pay in state created: has_balance: no balance", err.Error())
+	assert.Empty(t, evt.Status)
+	assert.Empty(t, calls)
+
+	assert.NoError(t, m.Fire(ctx, evt, "cancel"))
+	assert.Equal(t, "cancelled", evt.Status)
+}
+
+func TestMachineHookFailed(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		boom = errors.New("boom")
+		m    = NewMachine("door", "closed").
+			State("open", OnEntry("alarm", func(ctx context.Context, evt *Event) error { return boom })).
+			Transition("open", "closed", "open")
+		evt = &Event{Status: "closed"}
+	)
+
+	err := m.Fire(ctx, evt, "open")
+	assert.ErrorIs(t, err, ErrHookFailed)
+	assert.ErrorIs(t, err, boom)
+	assert.Equal(t, &Event{Status: "closed"}, evt)
+}
+
+func TestMachineBuilder(t *testing.T) {
+	var (
+		ctx = context.Background()
+		// the states of the transitions are declared on the way
+		m = NewMachine("door", "closed").
+			Transition("open", "closed", "opened").
+			Transition("lock", "closed", "locked").
+			State("locked", Final())
+		evt = &Event{}
+	)
+	assert.NoError(t, m.Validate())
+	assert.NoError(t, m.Fire(ctx, evt, "lock"))
+	assert.Equal(t, "locked", evt.Status)
+	assert.False(t, evt.EndAt.IsZero())
+
+	// a transition to an undeclared state fails instead of panicking
+	var raw = &Machine{Name: "door", Initial: "closed", States: []*State{{Name: "closed"}},
+		Transitions: []*Transition{{Event: "open", From: names{"closed"}, To: "opened"}}}
+	assert.ErrorIs(t, raw.Fire(ctx, &Event{}, "open"), ErrUnknownState)
+}
+
+const orderYAML = `
+name: order
+initial: created
+states:
+  - name: created
+  - name: paid
+    entry: [notify]
+  - name: shipped
+    final: true
+transitions:
+  - event: pay
+    from: created
+    to: paid
+    guard: has_balance
+  - event: ship
+    from: [paid]
+    to: shipped
+`
+
+func TestLoadMachine(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		notified bool
+	)
+
+	_, err := LoadMachine([]byte(orderYAML))
+	assert.ErrorContains(t, err, `unknown hook "notify"`)
+	assert.ErrorContains(t, err, `unknown guard "has_balance"`)
+
+	m, err := LoadMachine([]byte(orderYAML),
+		WithGuard("has_balance", func(ctx context.Context, evt *Event) error { return nil }),
+		WithHook("notify", func(ctx context.Context, evt *Event) error {
+			notified = true
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	var f = &Flow{Name: "order", Machine: m}
+	var evt = &Event{}
+	assert.NoError(t, f.Fire(ctx, evt, "pay"))
+	assert.NoError(t, f.Fire(ctx, evt, "ship"))
+	assert.Equal(t, "shipped", evt.Status)
+	assert.True(t, notified)
+
+	assert.ErrorIs(t, (&Flow{Name: "none"}).Fire(ctx, evt, "pay"), ErrNoMachine)
+
+	_, err = LoadMachine([]byte("name: x\ninitial: a\ntransitions:\n  - event: go\n    from: a\n    to: b\n"))
+	assert.ErrorContains(t, err, `initial state "a" is not declared`)
+	assert.ErrorContains(t, err, `unknown state "b"`)
+}
+
+func TestMachineExport(t *testing.T) {
+	var calls []string
+	var m = orderMachine(&calls, time.Now())
+
+	assert.Equal(t, `digraph "order" {
+	rankdir=LR;
+	"" [shape=point];
+	"created" [shape=circle];
+	"paid" [shape=circle];
+	"shipped" [shape=doublecircle];
+	"cancelled" [shape=doublecircle];
+	"" -> "created";
+	"created" -> "paid" [label="pay [has_balance]"];
+	"paid" -> "shipped" [label="ship"];
+	"created" -> "cancelled" [label="cancel"];
+	"paid" -> "cancelled" [label="cancel"];
+}
+`, m.DOT())
+
+	assert.Equal(t, `stateDiagram-v2
+    [*] --> created
+    created --> paid: pay [has_balance]
+    paid --> shipped: ship
+    created --> cancelled: cancel
+    paid --> cancelled: cancel
+    shipped --> [*]
+    cancelled --> [*]
+`, m.Mermaid())
+}
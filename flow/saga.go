@@ -0,0 +1,361 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/hysios/x/events"
+	"go.uber.org/zap"
+)
+
+// 实例状态
+const (
+	StatusRunning      = "running"
+	StatusCompleted    = "completed"
+	StatusCompensating = "compensating"
+	StatusCompensated  = "compensated"
+	StatusFailed       = "failed"
+)
+
+// Instance 流程实例, the embedded Event holds its id, status and times
+type Instance struct {
+	Event
+	// Step is the index of the current step
+	Step int
+	// Done is the number of steps whose action succeeded, they are compensated on failure
+	Done int
+	// Deadline of the current step, zero without timeout
+	Deadline time.Time
+	// Error is why the instance was compensated
+	Error string
+	Data  map[string]interface{}
+}
+
+// Finished reports whether the instance is completed, compensated or failed
+func (inst *Instance) Finished() bool {
+	switch inst.Status {
+	case StatusCompleted, StatusCompensated, StatusFailed:
+		return true
+	default:
+		return false
+	}
+}
+
+type EngineOption struct {
+	// Now is the clock of the deadlines
+	Now    func() time.Time
+	Logger *zap.Logger
+}
+
+type EngineOpt func(*EngineOption)
+
+var DefaultEngineOption = EngineOption{
+	Now:    time.Now,
+	Logger: zap.NewNop(),
+}
+
+// WithClock
+func WithClock(now func() time.Time) EngineOpt {
+	return func(o *EngineOption) {
+		o.Now = now
+	}
+}
+
+// WithLogger
+func WithLogger(log *zap.Logger) EngineOpt {
+	return func(o *EngineOption) {
+		o.Logger = log
+	}
+}
+
+// Engine runs the instances of the registered flows as sagas: the steps run
+// in order, and when one fails the completed steps are compensated in reverse
+// order. The instances are driven by events and saved in a Store.
+type Engine struct {
+	store Store
+	opt   EngineOption
+
+	mu    sync.RWMutex
+	flows map[string]*Flow
+	locks sync.Map
+}
+
+// NewEngine
+func NewEngine(store Store, opts ...EngineOpt) *Engine {
+	var opt = DefaultEngineOption
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return &Engine{store: store, opt: opt, flows: make(map[string]*Flow)}
+}
+
+// Register 注册流程
+func (e *Engine) Register(flows ...*Flow) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, f := range flows {
+		if _, ok := e.flows[f.Name]; ok {
+			return fmt.Errorf("%w: %s", ErrDuplicateFlow, f.Name)
+		}
+		e.flows[f.Name] = f
+	}
+	return nil
+}
+
+func (e *Engine) flow(name string) (*Flow, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	f, ok := e.flows[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrFlowNotFound, name)
+	}
+	return f, nil
+}
+
+// lock serializes the changes of the instance id
+func (e *Engine) lock(id string) func() {
+	v, _ := e.locks.LoadOrStore(id, &sync.Mutex{})
+	var mu = v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// Load 查询实例
+func (e *Engine) Load(ctx context.Context, id string) (*Instance, error) {
+	return e.store.Load(ctx, id)
+}
+
+// Start starts an instance of evt.Flow, its id is evt.FlowId or a new one.
+// Starting an existing instance returns it as is.
+func (e *Engine) Start(ctx context.Context, evt Event) (*Instance, error) {
+	f, err := e.flow(evt.Flow)
+	if err != nil {
+		return nil, err
+	}
+
+	if evt.FlowId == "" {
+		evt.FlowId = watermill.NewUUID()
+	}
+
+	defer e.lock(evt.FlowId)()
+
+	if inst, err := e.store.Load(ctx, evt.FlowId); err == nil {
+		return inst, nil
+	} else if !errors.Is(err, ErrInstanceNotFound) {
+		return nil, err
+	}
+
+	var now = e.opt.Now()
+	var inst = &Instance{Event: evt, Data: make(map[string]interface{})}
+	inst.Status = StatusRunning
+	if inst.StartAt.IsZero() {
+		inst.StartAt = now
+	}
+	inst.EndAt = time.Time{}
+	inst.UpdatedAt = now
+
+	e.advance(ctx, f, inst)
+	return inst, e.store.Save(ctx, inst)
+}
+
+// Handle drives the instance evt.FlowId with evt: the Start event of the flow
+// starts it, the Await event of the current step completes the step and the
+// Fail event compensates the instance. Other events are ignored.
+func (e *Engine) Handle(ctx context.Context, evt Event) error {
+	f, err := e.flow(evt.Flow)
+	if err != nil {
+		return err
+	}
+
+	if evt.Event == f.Start && f.Start != "" {
+		_, err := e.Start(ctx, evt)
+		return err
+	}
+
+	if evt.FlowId == "" {
+		return ErrInstanceNotFound
+	}
+
+	defer e.lock(evt.FlowId)()
+
+	inst, err := e.store.Load(ctx, evt.FlowId)
+	if err != nil {
+		return err
+	}
+	if inst.Finished() || inst.Step >= len(f.Steps) {
+		return nil
+	}
+
+	var step = f.Steps[inst.Step]
+	switch {
+	case step.Await != "" && evt.Event == step.Await && inst.Done > inst.Step:
+		inst.Step++
+		inst.Deadline = time.Time{}
+		e.record(inst, evt)
+		e.advance(ctx, f, inst)
+	case step.Fail != "" && evt.Event == step.Fail:
+		e.record(inst, evt)
+		e.compensate(ctx, f, inst, fmt.Errorf("%w: %s", ErrStepFailed, step.Name))
+	default:
+		return nil
+	}
+
+	return e.store.Save(ctx, inst)
+}
+
+// record keeps evt as the last event of inst
+func (e *Engine) record(inst *Instance, evt Event) {
+	inst.EventId = evt.EventId
+	inst.Event.Event = evt.Event
+	inst.UpdatedAt = e.opt.Now()
+}
+
+// advance runs the steps of inst until one awaits an event, fails or the flow is completed
+func (e *Engine) advance(ctx context.Context, f *Flow, inst *Instance) {
+	for inst.Step < len(f.Steps) {
+		var step = f.Steps[inst.Step]
+
+		if inst.Done <= inst.Step {
+			if err := e.run(ctx, step, inst); err != nil {
+				e.compensate(ctx, f, inst, fmt.Errorf("%s: %w", step.Name, err))
+				return
+			}
+			inst.Done = inst.Step + 1
+			inst.UpdatedAt = e.opt.Now()
+		}
+
+		if step.Await != "" {
+			if step.Timeout > 0 {
+				inst.Deadline = e.opt.Now().Add(step.Timeout)
+			}
+			return
+		}
+		inst.Step++
+	}
+
+	var now = e.opt.Now()
+	inst.Status = StatusCompleted
+	inst.EndAt = now
+	inst.UpdatedAt = now
+}
+
+// run runs the action of step, the timeout of a step without Await bounds the action
+func (e *Engine) run(ctx context.Context, step Step, inst *Instance) error {
+	if step.Action == nil {
+		return nil
+	}
+
+	if step.Timeout > 0 && step.Await == "" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, step.Timeout)
+		defer cancel()
+	}
+	return step.Action(ctx, inst)
+}
+
+// compensate undoes the completed steps of inst in reverse order
+func (e *Engine) compensate(ctx context.Context, f *Flow, inst *Instance, cause error) {
+	inst.Status = StatusCompensating
+	inst.Error = cause.Error()
+	inst.Deadline = time.Time{}
+
+	var errs []error
+	for i := inst.Done - 1; i >= 0; i-- {
+		var step = f.Steps[i]
+		if step.Compensate == nil {
+			continue
+		}
+		if err := step.Compensate(ctx, inst); err != nil {
+			errs = append(errs, fmt.Errorf("compensate %s: %w", step.Name, err))
+		}
+	}
+
+	var now = e.opt.Now()
+	inst.Status = StatusCompensated
+	if len(errs) > 0 {
+		inst.Status = StatusFailed
+		inst.Error = errors.Join(append([]error{cause}, errs...)...).Error()
+	}
+	inst.EndAt = now
+	inst.UpdatedAt = now
+}
+
+// Tick compensates the running instances whose step is past its deadline
+func (e *Engine) Tick(ctx context.Context) error {
+	running, err := e.store.Running(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, inst := range running {
+		if inst.Deadline.IsZero() || inst.Deadline.After(e.opt.Now()) {
+			continue
+		}
+		errs = append(errs, e.expire(ctx, inst.FlowId))
+	}
+	return errors.Join(errs...)
+}
+
+func (e *Engine) expire(ctx context.Context, id string) error {
+	defer e.lock(id)()
+
+	// the instance may have moved on since it was listed
+	inst, err := e.store.Load(ctx, id)
+	if err != nil {
+		return err
+	}
+	if inst.Finished() || inst.Deadline.IsZero() || inst.Deadline.After(e.opt.Now()) {
+		return nil
+	}
+
+	f, err := e.flow(inst.Flow)
+	if err != nil {
+		return err
+	}
+
+	var name string
+	if inst.Step < len(f.Steps) {
+		name = f.Steps[inst.Step].Name
+	}
+	e.compensate(ctx, f, inst, fmt.Errorf("%w: %s", ErrTimeout, name))
+	return e.store.Save(ctx, inst)
+}
+
+// Run checks the deadlines every interval until ctx is done
+func (e *Engine) Run(ctx context.Context, interval time.Duration) error {
+	var ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := e.Tick(ctx); err != nil {
+				e.opt.Logger.Error("flow: tick", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Bind handles the events published to topic on bus, the events of unknown
+// flows and instances are logged and dropped. The engines bound to the same
+// topic of a bus are named with events.WithHandlerName.
+func (e *Engine) Bind(bus *events.Bus, topic string, opts ...events.OnOpt) error {
+	return events.On(bus, topic, func(ctx context.Context, evt Event) error {
+		err := e.Handle(ctx, evt)
+		if errors.Is(err, ErrFlowNotFound) || errors.Is(err, ErrInstanceNotFound) {
+			e.opt.Logger.Warn("flow: drop event", zap.String("flow", evt.Flow), zap.String("flow_id", evt.FlowId), zap.String("event", evt.Event), zap.Error(err))
+			return nil
+		}
+		return err
+	}, opts...)
+}
@@ -0,0 +1,211 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hysios/x/events"
+	"github.com/hysios/x/events/driver/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recorder) action(name string, err error) Action {
+	return func(ctx context.Context, inst *Instance) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.calls = append(r.calls, name)
+		inst.Data[name] = true
+		return err
+	}
+}
+
+func (r *recorder) Calls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.calls...)
+}
+
+func orderFlow(r *recorder, shipErr error) *Flow {
+	return &Flow{
+		Name:  "order",
+		Start: "order.created",
+		Steps: []Step{
+			{Name: "reserve", Action: r.action("reserve", nil), Compensate: r.action("release", nil)},
+			{Name: "pay", Action: r.action("charge", nil), Compensate: r.action("refund", nil), Await: "order.paid", Fail: "order.declined", Timeout: time.Minute},
+			{Name: "ship", Action: r.action("ship", shipErr)},
+		},
+	}
+}
+
+func testEngine(t *testing.T, f *Flow, opts ...EngineOpt) *Engine {
+	var e = NewEngine(NewMemoryStore(), opts...)
+	assert.NoError(t, e.Register(f))
+	return e
+}
+
+func TestSaga(t *testing.T) {
+	var (
+		ctx = context.Background()
+		r   = &recorder{}
+		e   = testEngine(t, orderFlow(r, nil))
+	)
+
+	assert.ErrorIs(t, e.Register(orderFlow(r, nil)), ErrDuplicateFlow)
+
+	assert.NoError(t, e.Handle(ctx, Event{Flow: "order", FlowId: "o1", Event: "order.created", Owner: "alice"}))
+
+	inst, err := e.Load(ctx, "o1")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusRunning, inst.Status)
+	assert.Equal(t, 1, inst.Step)
+	assert.Equal(t, "alice", inst.Owner)
+	assert.False(t, inst.Deadline.IsZero())
+	assert.Equal(t, []string{"reserve", "charge"}, r.Calls())
+
+	// the start event is redelivered, the events of other steps are ignored
+	assert.NoError(t, e.Handle(ctx, Event{Flow: "order", FlowId: "o1", Event: "order.created"}))
+	assert.NoError(t, e.Handle(ctx, Event{Flow: "order", FlowId: "o1", Event: "order.shipped"}))
+	assert.Equal(t, []string{"reserve", "charge"}, r.Calls())
+
+	assert.NoError(t, e.Handle(ctx, Event{Flow: "order", FlowId: "o1", EventId: "e2", Event: "order.paid"}))
+
+	inst, err = e.Load(ctx, "o1")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCompleted, inst.Status)
+	assert.Equal(t, "order.paid", inst.Event.Event)
+	assert.Equal(t, "e2", inst.EventId)
+	assert.False(t, inst.EndAt.IsZero())
+	assert.Equal(t, true, inst.Data["ship"])
+	assert.Equal(t, []string{"reserve", "charge", "ship"}, r.Calls())
+
+	assert.ErrorIs(t, e.Handle(ctx, Event{Flow: "refund", Event: "x"}), ErrFlowNotFound)
+	assert.ErrorIs(t, e.Handle(ctx, Event{Flow: "order", FlowId: "o2", Event: "order.paid"}), ErrInstanceNotFound)
+}
+
+func TestSagaCompensate(t *testing.T) {
+	var (
+		ctx = context.Background()
+		r   = &recorder{}
+		e   = testEngine(t, orderFlow(r, errors.New("no stock")))
+	)
+
+	assert.NoError(t, e.Handle(ctx, Event{Flow: "order", FlowId: "o1", Event: "order.created"}))
+	assert.NoError(t, e.Handle(ctx, Event{Flow: "order", FlowId: "o1", Event: "order.paid"}))
+
+	inst, err := e.Load(ctx, "o1")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCompensated, inst.Status)
+	assert.Equal(t, "ship: no stock", inst.Error)
+	assert.Equal(t, []string{"reserve", "charge", "ship", "refund", "release"}, r.Calls())
+
+	// a finished instance ignores the events
+	assert.NoError(t, e.Handle(ctx, Event{Flow: "order", FlowId: "o1", Event: "order.declined"}))
+	assert.Len(t, r.Calls(), 5)
+}
+
+func TestSagaFailEvent(t *testing.T) {
+	var (
+		ctx = context.Background()
+		r   = &recorder{}
+		e   = testEngine(t, orderFlow(r, nil))
+	)
+
+	inst, err := e.Start(ctx, Event{Flow: "order"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, inst.FlowId)
+
+	assert.NoError(t, e.Handle(ctx, Event{Flow: "order", FlowId: inst.FlowId, Event: "order.declined"}))
+
+	inst, err = e.Load(ctx, inst.FlowId)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCompensated, inst.Status)
+	assert.Equal(t, "flow: step failed: pay", inst.Error)
+	assert.Equal(t, []string{"reserve", "charge", "refund", "release"}, r.Calls())
+}
+
+func TestSagaTimeout(t *testing.T) {
+	var (
+		ctx = context.Background()
+		r   = &recorder{}
+		now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		e   = testEngine(t, orderFlow(r, nil), WithClock(func() time.Time { return now }))
+	)
+
+	assert.NoError(t, e.Handle(ctx, Event{Flow: "order", FlowId: "o1", Event: "order.created"}))
+
+	assert.NoError(t, e.Tick(ctx))
+	inst, _ := e.Load(ctx, "o1")
+	assert.Equal(t, StatusRunning, inst.Status)
+
+	now = now.Add(2 * time.Minute)
+	assert.NoError(t, e.Tick(ctx))
+
+	inst, _ = e.Load(ctx, "o1")
+	assert.Equal(t, StatusCompensated, inst.Status)
+	assert.Equal(t, "flow: step timeout: pay", inst.Error)
+	assert.Equal(t, now, inst.EndAt)
+	assert.Equal(t, []string{"reserve", "charge", "refund", "release"}, r.Calls())
+}
+
+func TestSagaActionTimeout(t *testing.T) {
+	var (
+		ctx = context.Background()
+		r   = &recorder{}
+		f   = &Flow{
+			Name: "slow",
+			Steps: []Step{
+				{Name: "first", Action: r.action("first", nil), Compensate: r.action("undo", errors.New("gone"))},
+				{Name: "wait", Timeout: 10 * time.Millisecond, Action: func(ctx context.Context, inst *Instance) error {
+					<-ctx.Done()
+					return ctx.Err()
+				}},
+			},
+		}
+		e = testEngine(t, f)
+	)
+
+	inst, err := e.Start(ctx, Event{Flow: "slow"})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusFailed, inst.Status)
+	assert.Contains(t, inst.Error, context.DeadlineExceeded.Error())
+	assert.Contains(t, inst.Error, "compensate first: gone")
+	assert.Equal(t, []string{"first", "undo"}, r.Calls())
+}
+
+func TestSagaBind(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		bus         = events.NewBus(memory.New(memory.DefaultConfig))
+		r           = &recorder{}
+		e           = testEngine(t, orderFlow(r, nil))
+	)
+	defer cancel()
+	defer bus.Close()
+
+	assert.NoError(t, e.Bind(bus, "orders"))
+	_, err := bus.Start(ctx)
+	assert.NoError(t, err)
+
+	assert.NoError(t, bus.Send(ctx, "orders", Event{Flow: "order", FlowId: "o1", Event: "order.created"}))
+	assert.Eventually(t, func() bool {
+		inst, err := e.Load(ctx, "o1")
+		return err == nil && inst.Step == 1
+	}, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, bus.Send(ctx, "orders", Event{Flow: "unknown"}))
+	assert.NoError(t, bus.Send(ctx, "orders", Event{Flow: "order", FlowId: "o1", Event: "order.paid"}))
+	assert.Eventually(t, func() bool {
+		inst, err := e.Load(ctx, "o1")
+		return err == nil && inst.Status == StatusCompleted
+	}, time.Second, 10*time.Millisecond)
+}
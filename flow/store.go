@@ -0,0 +1,149 @@
+package flow
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+
+	xmaps "github.com/hysios/x/maps"
+	"github.com/hysios/x/store"
+)
+
+// Store persists the instances of the flows
+type Store interface {
+	// Load returns the instance id, ErrInstanceNotFound when there is none
+	Load(ctx context.Context, id string) (*Instance, error)
+	Save(ctx context.Context, inst *Instance) error
+	// Running lists the instances which aren't finished
+	Running(ctx context.Context) ([]*Instance, error)
+}
+
+type memoryStore struct {
+	mu        sync.Mutex
+	instances store.Store[string, Instance]
+}
+
+// NewMemoryStore returns a Store keeping the instances in s, a maps.Map is used when s is omitted
+func NewMemoryStore(s ...store.Store[string, Instance]) Store {
+	var instances store.Store[string, Instance] = xmaps.NewMap[string, Instance]()
+	if len(s) > 0 && s[0] != nil {
+		instances = s[0]
+	}
+
+	return &memoryStore{instances: instances}
+}
+
+func (m *memoryStore) Load(ctx context.Context, id string) (*Instance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inst, ok := m.instances.Load(id)
+	if !ok {
+		return nil, ErrInstanceNotFound
+	}
+	return inst.clone(), nil
+}
+
+func (m *memoryStore) Save(ctx context.Context, inst *Instance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.instances.Store(inst.FlowId, *inst.clone())
+	return nil
+}
+
+func (m *memoryStore) Running(ctx context.Context) ([]*Instance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var running []*Instance
+	m.instances.Range(func(id string, inst Instance) bool {
+		if !inst.Finished() {
+			running = append(running, inst.clone())
+		}
+		return true
+	})
+	return running, nil
+}
+
+// clone copies inst, the stored instances don't share Data with the callers
+func (inst *Instance) clone() *Instance {
+	var c = *inst
+	c.Data = maps.Clone(inst.Data)
+	c.Participants = slices.Clone(inst.Participants)
+	c.Watchs = slices.Clone(inst.Watchs)
+	return &c
+}
+
+// ApprovalStore persists the approvals of a Workflow
+type ApprovalStore interface {
+	// Load returns the approval id, ErrInstanceNotFound when there is none
+	Load(ctx context.Context, id string) (*Approval, error)
+	Save(ctx context.Context, a *Approval) error
+	// Pending lists the pending approvals waiting for user
+	Pending(ctx context.Context, user string) ([]*Approval, error)
+}
+
+type memoryApprovalStore struct {
+	mu        sync.Mutex
+	approvals store.Store[string, Approval]
+}
+
+// NewMemoryApprovalStore returns an ApprovalStore keeping the approvals in s, a maps.Map is used when s is omitted
+func NewMemoryApprovalStore(s ...store.Store[string, Approval]) ApprovalStore {
+	var approvals store.Store[string, Approval] = xmaps.NewMap[string, Approval]()
+	if len(s) > 0 && s[0] != nil {
+		approvals = s[0]
+	}
+
+	return &memoryApprovalStore{approvals: approvals}
+}
+
+func (m *memoryApprovalStore) Load(ctx context.Context, id string) (*Approval, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.approvals.Load(id)
+	if !ok {
+		return nil, ErrInstanceNotFound
+	}
+	return a.clone(), nil
+}
+
+func (m *memoryApprovalStore) Save(ctx context.Context, a *Approval) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.approvals.Store(a.FlowId, *a.clone())
+	return nil
+}
+
+func (m *memoryApprovalStore) Pending(ctx context.Context, user string) ([]*Approval, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pending []*Approval
+	m.approvals.Range(func(id string, a Approval) bool {
+		if a.Status == ApprovalPending && slices.Contains(a.Waiting, user) {
+			pending = append(pending, a.clone())
+		}
+		return true
+	})
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].UpdatedAt.Before(pending[j].UpdatedAt)
+	})
+	return pending, nil
+}
+
+func (a *Approval) clone() *Approval {
+	var c = *a
+	c.Participants = slices.Clone(a.Participants)
+	c.Watchs = slices.Clone(a.Watchs)
+	c.Approved = slices.Clone(a.Approved)
+	c.Waiting = slices.Clone(a.Waiting)
+	c.History = slices.Clone(a.History)
+	return &c
+}
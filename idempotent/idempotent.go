@@ -0,0 +1,251 @@
+package idempotent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/hysios/x/events/common"
+	"github.com/hysios/x/mq"
+	"go.uber.org/zap"
+)
+
+// Deduplicator skips messages whose key was already processed. A key is leased
+// for Lease before the handler runs, marked done for TTL after it succeeds and
+// released when it fails. A redelivery after a failure, or after a crash once
+// the lease expired, is processed again while a redelivery after success is
+// skipped. A message whose key is leased by another consumer is redelivered.
+type Deduplicator struct {
+	store    Store
+	ttl      time.Duration
+	lease    time.Duration
+	key      func(msg mq.Message) string
+	eventKey func(msg *common.Message) string
+	log      *zap.Logger
+}
+
+type Opt func(*Deduplicator)
+
+var (
+	DefaultTTL   = 24 * time.Hour
+	DefaultLease = time.Minute
+)
+
+// ErrProcessing is returned by Do when another consumer holds the lease of the key
+var ErrProcessing = errors.New("idempotent: key is being processed")
+
+// New
+func New(store Store, opts ...Opt) *Deduplicator {
+	var d = &Deduplicator{
+		store:    store,
+		ttl:      DefaultTTL,
+		lease:    DefaultLease,
+		key:      mq.IDOf,
+		eventKey: func(msg *common.Message) string { return msg.UUID },
+		log:      zap.NewNop(),
+	}
+
+	for _, o := range opts {
+		o(d)
+	}
+	return d
+}
+
+// WithTTL sets how long processed keys are remembered
+func WithTTL(ttl time.Duration) Opt {
+	return func(d *Deduplicator) {
+		d.ttl = ttl
+	}
+}
+
+// WithLease sets how long a key is leased to the handler, a key whose
+// handler crashed is processed again once its lease expires
+func WithLease(lease time.Duration) Opt {
+	return func(d *Deduplicator) {
+		d.lease = lease
+	}
+}
+
+// WithKey sets the key extractor of mq messages, it defaults to mq.IDOf
+func WithKey(fn func(msg mq.Message) string) Opt {
+	return func(d *Deduplicator) {
+		d.key = fn
+	}
+}
+
+// WithEventKey sets the key extractor of events messages, it defaults to the message UUID
+func WithEventKey(fn func(msg *common.Message) string) Opt {
+	return func(d *Deduplicator) {
+		d.eventKey = fn
+	}
+}
+
+// WithLogger
+func WithLogger(log *zap.Logger) Opt {
+	return func(d *Deduplicator) {
+		d.log = log
+	}
+}
+
+// Do runs fn unless key was already processed, key is done when fn succeeds
+// and released when it fails. It returns ErrProcessing while another consumer
+// processes key. Messages with an empty key are always processed.
+func (d *Deduplicator) Do(ctx context.Context, key string, fn func() error) error {
+	if key == "" {
+		return fn()
+	}
+
+	state, err := d.store.Claim(ctx, key, d.lease)
+	if err != nil {
+		return err
+	}
+
+	switch state {
+	case Processed:
+		d.log.Debug("skip duplicate message", zap.String("key", key))
+		return nil
+	case Processing:
+		return fmt.Errorf("%w: %s", ErrProcessing, key)
+	}
+
+	if err := fn(); err != nil {
+		d.release(ctx, key)
+		return err
+	}
+
+	d.done(ctx, key)
+	return nil
+}
+
+// done marks key processed, the lease expires when it fails and a redelivery is processed again
+func (d *Deduplicator) done(ctx context.Context, key string) {
+	if err := d.store.Done(ctx, key, d.ttl); err != nil {
+		d.log.Warn("done key error", zap.String("key", key), zap.Error(err))
+	}
+}
+
+func (d *Deduplicator) release(ctx context.Context, key string) {
+	if err := d.store.Release(ctx, key); err != nil {
+		d.log.Warn("release key error", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// Messages filters the duplicates out of a subscription, duplicates are acked and dropped.
+// The messages whose key is leased by another consumer are nacked. Acking a
+// forwarded message marks its key done, nacking it releases the key.
+func (d *Deduplicator) Messages(ctx context.Context, in <-chan mq.Message) <-chan mq.Message {
+	var out = make(chan mq.Message)
+
+	go func() {
+		defer close(out)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-in:
+				if !ok {
+					return
+				}
+
+				var key = d.key(msg)
+				if key != "" {
+					state, err := d.store.Claim(ctx, key, d.lease)
+					if err != nil {
+						d.log.Warn("claim key error", zap.String("key", key), zap.Error(err))
+						mq.Nack(msg)
+						continue
+					}
+
+					switch state {
+					case Processed:
+						d.log.Debug("skip duplicate message", zap.String("key", key))
+						msg.Ack()
+						continue
+					case Processing:
+						d.log.Debug("key is being processed", zap.String("key", key))
+						mq.Nack(msg)
+						continue
+					}
+					msg = &claimedMessage{Message: msg, d: d, ctx: ctx, key: key}
+				}
+
+				select {
+				case out <- msg:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// Typed wraps a typed handler, duplicates are skipped and acked
+func Typed[T any](d *Deduplicator, handler mq.TypedHandler[T]) mq.TypedHandler[T] {
+	return func(ctx context.Context, val T, meta mq.Meta) error {
+		return d.Do(ctx, d.key(meta.Message), func() error {
+			return handler(ctx, val, meta)
+		})
+	}
+}
+
+// Middleware is an events Router middleware skipping the duplicate messages
+func (d *Deduplicator) Middleware(h message.HandlerFunc) message.HandlerFunc {
+	return func(msg *message.Message) (produced []*message.Message, err error) {
+		err = d.Do(msg.Context(), d.eventKey(msg), func() error {
+			produced, err = h(msg)
+			return err
+		})
+		return
+	}
+}
+
+type claimedMessage struct {
+	mq.Message
+
+	d    *Deduplicator
+	ctx  context.Context
+	key  string
+	once sync.Once
+}
+
+func (m *claimedMessage) ID() string {
+	return mq.IDOf(m.Message)
+}
+
+func (m *claimedMessage) Key() string {
+	return mq.KeyOf(m.Message)
+}
+
+func (m *claimedMessage) Headers() map[string]string {
+	return mq.HeadersOf(m.Message)
+}
+
+// Context returns the context of the wrapped message, nil when it has none
+func (m *claimedMessage) Context() context.Context {
+	if c, ok := m.Message.(mq.Contexter); ok {
+		return c.Context()
+	}
+	return nil
+}
+
+// Ack marks the key done and acks the message
+func (m *claimedMessage) Ack() bool {
+	m.once.Do(func() {
+		m.d.done(m.ctx, m.key)
+	})
+	return m.Message.Ack()
+}
+
+// Nack releases the key and nacks the message
+func (m *claimedMessage) Nack() bool {
+	m.once.Do(func() {
+		m.d.release(m.ctx, m.key)
+	})
+	return mq.Nack(m.Message)
+}
@@ -0,0 +1,205 @@
+package idempotent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hysios/x/mq"
+	"github.com/hysios/x/mq/memory"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func testStore(t *testing.T, s Store, expire func(time.Duration)) {
+	var ctx = context.Background()
+
+	state, err := s.Claim(ctx, "a", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, Claimed, state)
+
+	state, err = s.Claim(ctx, "a", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, Processing, state)
+
+	assert.NoError(t, s.Release(ctx, "a"))
+	state, err = s.Claim(ctx, "a", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, Claimed, state)
+
+	assert.NoError(t, s.Done(ctx, "a", time.Hour))
+	state, err = s.Claim(ctx, "a", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, Processed, state)
+
+	// an expired lease is taken over
+	state, _ = s.Claim(ctx, "b", 10*time.Millisecond)
+	assert.Equal(t, Claimed, state)
+	expire(20 * time.Millisecond)
+	state, _ = s.Claim(ctx, "b", 10*time.Millisecond)
+	assert.Equal(t, Claimed, state)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(), time.Sleep)
+}
+
+func TestMemoryStoreConcurrentClaim(t *testing.T) {
+	var (
+		s       = NewMemoryStore()
+		wg      sync.WaitGroup
+		claimed int32
+	)
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if state, _ := s.Claim(context.Background(), "key", time.Minute); state == Claimed {
+				atomic.AddInt32(&claimed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), claimed)
+}
+
+func TestRedisStore(t *testing.T) {
+	var mr = miniredis.RunT(t)
+	testStore(t, NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()})), mr.FastForward)
+	assert.True(t, mr.Exists(Namespace+":a"))
+}
+
+func TestLeaseExpired(t *testing.T) {
+	var (
+		s     = NewMemoryStore()
+		d     = New(s, WithLease(10*time.Millisecond))
+		ctx   = context.Background()
+		calls int
+	)
+
+	// a consumer crashed while processing k
+	state, _ := s.Claim(ctx, "k", 10*time.Millisecond)
+	assert.Equal(t, Claimed, state)
+
+	var fn = func() error {
+		calls++
+		return nil
+	}
+	assert.ErrorIs(t, d.Do(ctx, "k", fn), ErrProcessing)
+
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, d.Do(ctx, "k", fn))
+	assert.NoError(t, d.Do(ctx, "k", fn))
+	assert.Equal(t, 1, calls)
+}
+
+func TestMessages(t *testing.T) {
+	var (
+		driver = memory.Open(memory.DefaultConfig)
+		d      = New(NewMemoryStore())
+		ctx    = context.Background()
+	)
+
+	ch, _ := driver.Subscribe("orders")
+	msgs := d.Messages(ctx, ch)
+
+	assert.NoError(t, driver.Publish("orders", []byte("1"), mq.MessageID("m1")))
+	assert.NoError(t, driver.Publish("orders", []byte("1"), mq.MessageID("m1")))
+	assert.NoError(t, driver.Publish("orders", []byte("2"), mq.MessageID("m2")))
+
+	msg := <-msgs
+	assert.Equal(t, "m1", mq.IDOf(msg))
+	assert.True(t, msg.Ack())
+
+	msg = <-msgs
+	assert.Equal(t, "m2", mq.IDOf(msg))
+	// a nacked message is redelivered and processed again
+	assert.True(t, mq.Nack(msg))
+
+	msg = <-msgs
+	assert.Equal(t, "m2", mq.IDOf(msg))
+}
+
+type fakeMessage struct {
+	id, key string
+	headers map[string]string
+	ctx     context.Context
+}
+
+func (m *fakeMessage) Payload() []byte            { return nil }
+func (m *fakeMessage) Ack() bool                  { return true }
+func (m *fakeMessage) ID() string                 { return m.id }
+func (m *fakeMessage) Key() string                { return m.key }
+func (m *fakeMessage) Headers() map[string]string { return m.headers }
+func (m *fakeMessage) Context() context.Context   { return m.ctx }
+
+type traceKey struct{}
+
+func TestMessagesForward(t *testing.T) {
+	var (
+		d   = New(NewMemoryStore())
+		ctx = context.Background()
+		in  = make(chan mq.Message, 1)
+	)
+	in <- &fakeMessage{id: "m1", key: "k1", headers: map[string]string{"h": "v"}, ctx: context.WithValue(ctx, traceKey{}, "trace")}
+
+	// the key, the headers and the context of the message are kept
+	msg := <-d.Messages(ctx, in)
+	assert.IsType(t, &claimedMessage{}, msg)
+	assert.Equal(t, "m1", mq.IDOf(msg))
+	assert.Equal(t, "k1", mq.KeyOf(msg))
+	assert.Equal(t, "v", mq.HeadersOf(msg)["h"])
+	assert.Equal(t, "trace", msg.(mq.Contexter).Context().Value(traceKey{}))
+}
+
+func TestTyped(t *testing.T) {
+	var (
+		d     = New(NewMemoryStore())
+		calls int
+		fail  = true
+	)
+
+	handler := Typed(d, func(ctx context.Context, val string, meta mq.Meta) error {
+		calls++
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	driver := memory.Open(memory.DefaultConfig)
+	ch, _ := driver.Subscribe("t")
+	assert.NoError(t, driver.Publish("t", nil, mq.MessageID("x")))
+	var meta = mq.Meta{Topic: "t", Message: <-ch}
+
+	assert.Error(t, handler(context.Background(), "v", meta))
+	fail = false
+	assert.NoError(t, handler(context.Background(), "v", meta))
+	assert.NoError(t, handler(context.Background(), "v", meta))
+	assert.Equal(t, 2, calls)
+}
+
+func TestMiddleware(t *testing.T) {
+	var (
+		d     = New(NewMemoryStore())
+		calls int
+	)
+
+	h := d.Middleware(func(msg *message.Message) ([]*message.Message, error) {
+		calls++
+		return nil, nil
+	})
+
+	msg := message.NewMessage("uuid-1", []byte("x"))
+	_, err := h(msg)
+	assert.NoError(t, err)
+	_, err = h(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+}
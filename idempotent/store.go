@@ -0,0 +1,164 @@
+package idempotent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hysios/x/maps"
+	"github.com/hysios/x/store"
+	"github.com/redis/go-redis/v9"
+)
+
+// State of a key claimed in a Store
+type State int
+
+const (
+	// Claimed means the caller holds the processing lease of the key
+	Claimed State = iota
+	// Processing means another consumer holds an unexpired lease on the key
+	Processing
+	// Processed means the key is done
+	Processed
+)
+
+// Store records the keys of the messages being processed and processed
+type Store interface {
+	// Claim atomically takes a processing lease on key for lease unless the key
+	// is processed or leased already, an expired lease is taken over
+	Claim(ctx context.Context, key string, lease time.Duration) (State, error)
+	// Done marks key processed for ttl, forever when ttl is 0
+	Done(ctx context.Context, key string, ttl time.Duration) error
+	// Release forgets key so that a redelivered message is processed again
+	Release(ctx context.Context, key string) error
+}
+
+var Namespace = "$$idempotent"
+
+const sweepEvery = 1024
+
+// Entry is a key of the memory store
+type Entry struct {
+	Done bool
+	// Expire is zero for the keys processed forever
+	Expire time.Time
+}
+
+func (e Entry) expired(now time.Time) bool {
+	return !e.Expire.IsZero() && !e.Expire.After(now)
+}
+
+type memoryStore struct {
+	mu     sync.Mutex
+	keys   store.Store[string, Entry]
+	claims int
+}
+
+// NewMemoryStore returns a Store keeping keys in s, a maps.Map is used when s is omitted
+func NewMemoryStore(s ...store.Store[string, Entry]) Store {
+	var keys store.Store[string, Entry] = maps.NewMap[string, Entry]()
+	if len(s) > 0 && s[0] != nil {
+		keys = s[0]
+	}
+
+	return &memoryStore{keys: keys}
+}
+
+func (m *memoryStore) Claim(ctx context.Context, key string, lease time.Duration) (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var now = time.Now()
+	if m.claims++; m.claims%sweepEvery == 0 {
+		m.sweep(now)
+	}
+
+	if e, ok := m.keys.Load(key); ok && !e.expired(now) {
+		if e.Done {
+			return Processed, nil
+		}
+		return Processing, nil
+	}
+
+	m.keys.Store(key, Entry{Expire: now.Add(lease)})
+	return Claimed, nil
+}
+
+func (m *memoryStore) Done(ctx context.Context, key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var e = Entry{Done: true}
+	if ttl > 0 {
+		e.Expire = time.Now().Add(ttl)
+	}
+	m.keys.Store(key, e)
+	return nil
+}
+
+func (m *memoryStore) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys.Delete(key)
+	return nil
+}
+
+// sweep removes the expired keys
+func (m *memoryStore) sweep(now time.Time) {
+	m.keys.Range(func(key string, e Entry) bool {
+		if e.expired(now) {
+			m.keys.Delete(key)
+		}
+		return true
+	})
+}
+
+type redisStore struct {
+	cli       *redis.Client
+	namespace string
+}
+
+// NewRedisStore returns a Store recording keys with SET NX, the leases expire with PX
+func NewRedisStore(cli *redis.Client, namespace ...string) Store {
+	var ns = Namespace
+	if len(namespace) > 0 && namespace[0] != "" {
+		ns = namespace[0]
+	}
+
+	return &redisStore{cli: cli, namespace: ns}
+}
+
+func (r *redisStore) key(key string) string {
+	return r.namespace + ":" + key
+}
+
+// claimScript sets the lease unless the key exists, it returns the value of the key otherwise
+var claimScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], "processing", "NX", "PX", ARGV[1]) then
+	return ""
+end
+return redis.call("GET", KEYS[1])
+`)
+
+func (r *redisStore) Claim(ctx context.Context, key string, lease time.Duration) (State, error) {
+	v, err := claimScript.Run(ctx, r.cli, []string{r.key(key)}, lease.Milliseconds()).Text()
+	switch {
+	case err != nil:
+		return Processing, err
+	case v == "":
+		return Claimed, nil
+	case v == "done":
+		return Processed, nil
+	default:
+		return Processing, nil
+	}
+}
+
+func (r *redisStore) Done(ctx context.Context, key string, ttl time.Duration) error {
+	return r.cli.Set(ctx, r.key(key), "done", ttl).Err()
+}
+
+func (r *redisStore) Release(ctx context.Context, key string) error {
+	return r.cli.Del(ctx, r.key(key)).Err()
+}
@@ -1,6 +1,7 @@
 package job
 
 import (
+	"context"
 	"errors"
 	"os"
 	"os/signal"
@@ -11,6 +12,7 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/gocraft/work"
+	"github.com/hysios/x/otel"
 )
 
 type Worker struct {
@@ -67,6 +69,7 @@ func NewWorker(cfg *Config) *Worker {
 	}
 
 	pool := work.NewWorkerPool(cfg.Context, uint(cfg.Concurrency), cfg.Namespace, redisPool)
+	pool.Middleware(Tracing)
 
 	return &Worker{
 		Pool: pool,
@@ -100,6 +103,21 @@ func (w *Worker) Enqueue(jobName string, jobData map[string]interface{}) (*Job,
 	return enqueuer.Enqueue(jobName, jobData)
 }
 
+// EnqueueContext enqueues a job carrying the trace of ctx, the run continues it
+func (w *Worker) EnqueueContext(ctx context.Context, jobName string, jobData map[string]interface{}) (job *Job, err error) {
+	ctx, span := otel.StartPublish(ctx, System, jobName)
+	defer func() { otel.End(span, err) }()
+
+	// jobData is the caller's
+	var args = make(map[string]interface{}, len(jobData)+2)
+	for k, v := range jobData {
+		args[k] = v
+	}
+	otel.InjectArgs(ctx, args)
+
+	return w.Enqueue(jobName, args)
+}
+
 // EnqueueIn
 func (w *Worker) EnqueueIn(jobName string, delay time.Duration, jobData map[string]interface{}) (*ScheduledJob, error) {
 	var enqueuer = work.NewEnqueuer(w.cfg.Namespace, w.cfg.RedisPool)
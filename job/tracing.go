@@ -0,0 +1,34 @@
+package job
+
+import (
+	"context"
+
+	"github.com/gocraft/work"
+	"github.com/hysios/x/otel"
+)
+
+// System is the messaging system of the enqueue spans
+const System = "gocraft_work"
+
+// ContextKey is the reserved job argument holding the context of the run, it's
+// only set while the job runs
+const ContextKey = "_x.job.ctx"
+
+// Tracing is a worker middleware that runs the job in a span continuing the trace of EnqueueContext
+func Tracing(job *work.Job, next work.NextMiddlewareFunc) (err error) {
+	ctx, span := otel.StartJob(otel.ExtractArgs(context.Background(), job.Args), job.Name, job.ID)
+	defer func() { otel.End(span, err) }()
+
+	job.Args[ContextKey] = ctx
+	defer delete(job.Args, ContextKey)
+
+	return next()
+}
+
+// JobContext returns the context of the running job, it carries the span of the run
+func JobContext(job *work.Job) context.Context {
+	if ctx, ok := job.Args[ContextKey].(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
@@ -2,11 +2,15 @@ package amqp
 
 import (
 	"context"
+	"errors"
 	"time"
 
+	"github.com/ThreeDotsLabs/watermill"
 	"github.com/hysios/x/mq"
+	"github.com/hysios/x/otel"
 	"github.com/mitchellh/mapstructure"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Config struct {
@@ -15,6 +19,10 @@ type Config struct {
 	QueueName      string        `mapstructure:"queue_name"`
 	PublishTimeout time.Duration `mapstructure:"publish_timeout"`
 	Durable        bool          `mapstructure:"durable"`
+	// DelayMode selects how delayed messages are published, DelayTTL or DelayPlugin
+	DelayMode string `mapstructure:"delay_mode"`
+	// Topology is declared at Open
+	Topology Topology `mapstructure:"topology"`
 }
 
 type amqpDriver struct {
@@ -23,6 +31,9 @@ type amqpDriver struct {
 	QueueName      string
 	PublishTimeout time.Duration
 	Durable        bool
+	DelayMode      string
+
+	topology Topology
 }
 
 var (
@@ -31,11 +42,17 @@ var (
 		ExchangeName:   "events",
 		PublishTimeout: 5 * time.Second,
 		Durable:        true,
+		DelayMode:      DelayTTL,
 	}
 )
 
 var Default *amqpDriver
 
+// System is the messaging system of the spans
+const System = "rabbitmq"
+
+var errNacked = errors.New("mq/amqp: message nacked")
+
 func Open(cfg Config) (*amqpDriver, error) {
 	conn, err := amqp.Dial(cfg.URL)
 	if err != nil {
@@ -48,6 +65,12 @@ func Open(cfg Config) (*amqpDriver, error) {
 		PublishTimeout: cfg.PublishTimeout,
 		QueueName:      cfg.QueueName,
 		Durable:        cfg.Durable,
+		DelayMode:      cfg.DelayMode,
+	}
+
+	if err := driver.Declare(cfg.Topology); err != nil {
+		conn.Close()
+		return nil, err
 	}
 
 	if Default == nil {
@@ -89,33 +112,27 @@ func (a *amqpDriver) CreateExchange(name string) error {
 	return nil
 }
 
-// createExchange
+// createExchange declares the exchange as the topology defines it
 func (a *amqpDriver) createExchange(ch *amqp.Channel, name string) error {
-	err := ch.ExchangeDeclare(
-		name,
-		amqp.ExchangeTopic,
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return a.declareExchange(ch, a.exchange(name))
 }
 
-// queueBind
+// queueBind declares the queue bound to topic, a queue declared by the topology
+// keeps its own arguments and bindings
 func (a *amqpDriver) queueBind(ch *amqp.Channel, queue, topic string) (amqp.Queue, error) {
+	if spec, ok := a.queue(queue); ok {
+		return a.declareQueue(ch, spec)
+	}
+
+	// a server named queue is private to the subscription and deleted with it
+	var temporary = queue == ""
 	q, err := ch.QueueDeclare(
 		queue,
-		a.Durable, // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
+		a.Durable && !temporary, // durable
+		temporary,               // delete when unused
+		temporary,               // exclusive
+		false,                   // no-wait
+		nil,                     // arguments
 	)
 	if err != nil {
 		return amqp.Queue{}, err
@@ -135,18 +152,31 @@ func (a *amqpDriver) queueBind(ch *amqp.Channel, queue, topic string) (amqp.Queu
 }
 
 // Publish
-func (a *amqpDriver) Publish(topic string, payload []byte, opts ...mq.PubOpt) error {
+func (a *amqpDriver) Publish(topic string, payload []byte, opts ...mq.PubOpt) (err error) {
 	var opt = &mq.PubOption{}
 	for _, o := range opts {
 		o(opt)
 	}
 
+	var messageID = opt.MessageID
+	if messageID == "" {
+		messageID = watermill.NewUUID()
+	}
+
+	var parent = opt.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	parent, span := otel.StartPublish(parent, System, topic, otel.MessageID(messageID))
+	defer func() { otel.End(span, err) }()
+
 	anch, err := a.conn.Channel()
 	if err != nil {
 		return err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), a.PublishTimeout)
+	ctx, cancel := context.WithTimeout(parent, a.PublishTimeout)
 	defer cancel()
 
 	if err := a.createExchange(anch, a.ExchangeName); err != nil {
@@ -158,16 +188,34 @@ func (a *amqpDriver) Publish(topic string, payload []byte, opts ...mq.PubOpt) er
 		deliveryMode = amqp.Persistent
 	}
 
+	var msg = amqp.Publishing{
+		ContentType:  "text/plain",
+		MessageId:    messageID,
+		Body:         payload,
+		DeliveryMode: deliveryMode,
+		Headers:      amqp.Table{},
+	}
+
+	for k, v := range opt.Headers {
+		msg.Headers[k] = v
+	}
+
+	var carrier = make(map[string]string)
+	otel.Inject(parent, carrier)
+	for k, v := range carrier {
+		msg.Headers[k] = v
+	}
+
+	if delay := opt.Delay(); delay > 0 {
+		return a.publishDelayed(ctx, anch, topic, msg, delay)
+	}
+
 	return anch.PublishWithContext(ctx,
 		a.ExchangeName, // exchange
 		topic,
 		false, // mandatory
 		false, // immediate
-		amqp.Publishing{
-			ContentType:  "text/plain",
-			Body:         payload,
-			DeliveryMode: deliveryMode,
-		})
+		msg)
 }
 
 // Subscribe
@@ -191,6 +239,12 @@ func (a *amqpDriver) Subscribe(topic string, opts ...mq.SubOpt) (<-chan mq.Messa
 		return nil, err
 	}
 
+	if opt.Prefetch > 0 {
+		if err := anch.Qos(opt.Prefetch, 0, false); err != nil {
+			return nil, err
+		}
+	}
+
 	msgs, err := anch.Consume(
 		q.Name,      // queue
 		opt.Consume, // consumer
@@ -204,26 +258,87 @@ func (a *amqpDriver) Subscribe(topic string, opts ...mq.SubOpt) (<-chan mq.Messa
 		return nil, err
 	}
 
-	var ch = make(chan mq.Message)
+	var ch = make(chan mq.Message, opt.Prefetch)
+	if opt.Context == nil {
+		go func() {
+			for d := range msgs {
+				ch <- a.message(topic, d)
+			}
+		}()
+		return ch, nil
+	}
+
+	// closing the channel gives the unacked deliveries back to the broker
 	go func() {
+		<-opt.Context.Done()
+		anch.Close()
+	}()
+
+	go func() {
+		defer close(ch)
+
 		for d := range msgs {
-			ch <- &message{d}
+			select {
+			case ch <- a.message(topic, d):
+			case <-opt.Context.Done():
+			}
 		}
 	}()
 
 	return ch, nil
 }
 
+// message starts the consumer span of d
+func (a *amqpDriver) message(topic string, d amqp.Delivery) *message {
+	var msg = &message{Delivery: d}
+	msg.ctx, msg.span = otel.StartConsume(otel.Extract(context.Background(), msg.Headers()),
+		System, topic, otel.MessageID(d.MessageId))
+	return msg
+}
+
 type message struct {
 	amqp.Delivery
+
+	ctx  context.Context
+	span trace.Span
 }
 
 func (m *message) Payload() []byte {
 	return m.Body
 }
 
+func (m *message) ID() string {
+	return m.MessageId
+}
+
+// Headers returns the string headers of the delivery
+func (m *message) Headers() map[string]string {
+	var headers = make(map[string]string, len(m.Delivery.Headers))
+	for k, v := range m.Delivery.Headers {
+		if s, ok := v.(string); ok {
+			headers[k] = s
+		}
+	}
+	return headers
+}
+
+// Context carries the consumer span of the message
+func (m *message) Context() context.Context {
+	return m.ctx
+}
+
+// Ack ends the consumer span
 func (m *message) Ack() bool {
-	return m.Delivery.Ack(false) == nil
+	err := m.Delivery.Ack(false)
+	otel.End(m.span, err)
+	return err == nil
+}
+
+// Nack requeues the message
+func (m *message) Nack() bool {
+	err := m.Delivery.Nack(false, true)
+	otel.End(m.span, errNacked)
+	return err == nil
 }
 
 func init() {
@@ -281,6 +396,12 @@ func mergeConfigs(defaultCfg, userCfg Config, rawConfig mq.Config) Config {
 	if _, exists := rawConfig["durable"]; exists {
 		result.Durable = userCfg.Durable
 	}
+	if _, exists := rawConfig["delay_mode"]; exists {
+		result.DelayMode = userCfg.DelayMode
+	}
+	if _, exists := rawConfig["topology"]; exists {
+		result.Topology = userCfg.Topology
+	}
 
 	return result
 }
@@ -0,0 +1,91 @@
+package amqp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// DelayTTL parks delayed messages in per-delay queues whose TTL dead-letters them to the exchange
+	DelayTTL = "ttl"
+	// DelayPlugin publishes through an x-delayed-message exchange, it requires the
+	// rabbitmq_delayed_message_exchange plugin
+	DelayPlugin = "plugin"
+)
+
+// delayQueueName
+func (a *amqpDriver) delayQueueName(delay time.Duration) string {
+	return fmt.Sprintf("%s.delay.%d", a.ExchangeName, delay.Milliseconds())
+}
+
+// publishDelayed
+func (a *amqpDriver) publishDelayed(ctx context.Context, ch *amqp.Channel, topic string, msg amqp.Publishing, delay time.Duration) error {
+	switch a.DelayMode {
+	case DelayPlugin:
+		return a.publishDelayedPlugin(ctx, ch, topic, msg, delay)
+	case DelayTTL, "":
+		return a.publishDelayedTTL(ctx, ch, topic, msg, delay)
+	default:
+		return fmt.Errorf("mq/amqp: unknown delay mode %q", a.DelayMode)
+	}
+}
+
+// publishDelayedTTL publishes msg to a fanout exchange bound to a queue without
+// consumers, the queue TTL dead-letters it to the main exchange with its original
+// routing key. Delays are rounded up to the second to bound the number of queues.
+func (a *amqpDriver) publishDelayedTTL(ctx context.Context, ch *amqp.Channel, topic string, msg amqp.Publishing, delay time.Duration) error {
+	delay = (delay + time.Second - 1).Truncate(time.Second)
+	var name = a.delayQueueName(delay)
+
+	if err := ch.ExchangeDeclare(name, amqp.ExchangeFanout, a.Durable, false, false, false, nil); err != nil {
+		return err
+	}
+
+	q, err := ch.QueueDeclare(
+		name,
+		a.Durable, // durable
+		false,     // delete when unused
+		false,     // exclusive
+		false,     // no-wait
+		amqp.Table{
+			"x-message-ttl":          delay.Milliseconds(),
+			"x-dead-letter-exchange": a.ExchangeName,
+			// drop the idle delay queues, after every message in them was dead-lettered
+			"x-expires": (2*delay + time.Minute).Milliseconds(),
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := ch.QueueBind(q.Name, "", name, false, nil); err != nil {
+		return err
+	}
+
+	return ch.PublishWithContext(ctx, name, topic, false, false, msg)
+}
+
+// publishDelayedPlugin
+func (a *amqpDriver) publishDelayedPlugin(ctx context.Context, ch *amqp.Channel, topic string, msg amqp.Publishing, delay time.Duration) error {
+	var name = a.ExchangeName + ".delayed"
+
+	if err := ch.ExchangeDeclare(name, "x-delayed-message", a.Durable, false, false, false, amqp.Table{
+		"x-delayed-type": amqp.ExchangeTopic,
+	}); err != nil {
+		return err
+	}
+
+	if err := ch.ExchangeBind(a.ExchangeName, "#", name, false, nil); err != nil {
+		return err
+	}
+
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
+	msg.Headers["x-delay"] = delay.Milliseconds()
+
+	return ch.PublishWithContext(ctx, name, topic, false, false, msg)
+}
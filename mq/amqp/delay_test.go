@@ -0,0 +1,28 @@
+package amqp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hysios/x/mq"
+)
+
+// TestMergeConfigsDelayMode 测试 delay_mode 配置合并
+func TestMergeConfigsDelayMode(t *testing.T) {
+	result := mergeConfigs(DefaultConfig, Config{DelayMode: DelayPlugin}, mq.Config{"delay_mode": DelayPlugin})
+	if result.DelayMode != DelayPlugin {
+		t.Errorf("DelayMode不匹配: 期望 %s, 实际 %s", DelayPlugin, result.DelayMode)
+	}
+
+	result = mergeConfigs(DefaultConfig, Config{}, mq.Config{})
+	if result.DelayMode != DelayTTL {
+		t.Errorf("DelayMode应该保持默认值: 期望 %s, 实际 %s", DelayTTL, result.DelayMode)
+	}
+}
+
+func TestDelayQueueName(t *testing.T) {
+	var a = &amqpDriver{ExchangeName: "events"}
+	if name := a.delayQueueName(3 * time.Second); name != "events.delay.3000" {
+		t.Errorf("delay queue name: %s", name)
+	}
+}
@@ -0,0 +1,204 @@
+package amqp
+
+import (
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Topology declares the exchanges and queues of the broker, it's applied at Open.
+// Declaring is idempotent as long as a definition doesn't change, the broker
+// rejects redeclaring an existing exchange or queue with other arguments.
+type Topology struct {
+	Exchanges []Exchange `mapstructure:"exchanges"`
+	Queues    []Queue    `mapstructure:"queues"`
+}
+
+type Exchange struct {
+	Name string `mapstructure:"name"`
+	// Kind is topic, direct, fanout or headers, it defaults to topic
+	Kind string `mapstructure:"kind"`
+	// Durable defaults to the Durable of the driver
+	Durable    *bool                  `mapstructure:"durable"`
+	AutoDelete bool                   `mapstructure:"auto_delete"`
+	Internal   bool                   `mapstructure:"internal"`
+	Args       map[string]interface{} `mapstructure:"args"`
+	// Bindings bind the exchange to source exchanges
+	Bindings []Binding `mapstructure:"bindings"`
+}
+
+type Queue struct {
+	Name string `mapstructure:"name"`
+	// Type is classic, quorum or stream
+	Type string `mapstructure:"type"`
+	// Durable defaults to the Durable of the driver
+	Durable    *bool `mapstructure:"durable"`
+	AutoDelete bool  `mapstructure:"auto_delete"`
+	Exclusive  bool  `mapstructure:"exclusive"`
+	// MessageTTL drops or dead-letters the messages older than it
+	MessageTTL time.Duration `mapstructure:"message_ttl"`
+	// Expires deletes the queue after it has been unused for that long
+	Expires        time.Duration `mapstructure:"expires"`
+	MaxLength      int           `mapstructure:"max_length"`
+	MaxLengthBytes int           `mapstructure:"max_length_bytes"`
+	// Overflow is drop-head, reject-publish or reject-publish-dlx
+	Overflow             string                 `mapstructure:"overflow"`
+	DeadLetterExchange   string                 `mapstructure:"dead_letter_exchange"`
+	DeadLetterRoutingKey string                 `mapstructure:"dead_letter_routing_key"`
+	Args                 map[string]interface{} `mapstructure:"args"`
+	// Bindings bind the queue to exchanges
+	Bindings []Binding `mapstructure:"bindings"`
+}
+
+type Binding struct {
+	// Exchange is the source exchange, it defaults to the ExchangeName of the driver
+	Exchange string `mapstructure:"exchange"`
+	// Key is the routing key, a headers exchange matches Args instead
+	Key  string                 `mapstructure:"key"`
+	Args map[string]interface{} `mapstructure:"args"`
+}
+
+var exchangeKinds = map[string]bool{
+	amqp.ExchangeTopic:   true,
+	amqp.ExchangeDirect:  true,
+	amqp.ExchangeFanout:  true,
+	amqp.ExchangeHeaders: true,
+}
+
+// Declare applies the topology, exchanges are declared before the queues bound to them
+func (a *amqpDriver) Declare(topo Topology) error {
+	ch, err := a.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	for _, ex := range topo.Exchanges {
+		if err := a.declareExchange(ch, ex); err != nil {
+			return err
+		}
+	}
+
+	for _, ex := range topo.Exchanges {
+		for _, b := range ex.Bindings {
+			if err := ch.ExchangeBind(ex.Name, b.Key, a.source(b), false, b.Args); err != nil {
+				return fmt.Errorf("mq/amqp: bind exchange %s: %w", ex.Name, err)
+			}
+		}
+	}
+
+	for _, q := range topo.Queues {
+		if _, err := a.declareQueue(ch, q); err != nil {
+			return err
+		}
+	}
+
+	a.topology = topo
+	return nil
+}
+
+// declareExchange
+func (a *amqpDriver) declareExchange(ch *amqp.Channel, ex Exchange) error {
+	var kind = ex.Kind
+	if kind == "" {
+		kind = amqp.ExchangeTopic
+	}
+	if !exchangeKinds[kind] {
+		return fmt.Errorf("mq/amqp: exchange %s: unknown kind %s", ex.Name, kind)
+	}
+
+	if err := ch.ExchangeDeclare(ex.Name, kind, a.durable(ex.Durable), ex.AutoDelete, ex.Internal, false, ex.Args); err != nil {
+		return fmt.Errorf("mq/amqp: declare exchange %s: %w", ex.Name, err)
+	}
+	return nil
+}
+
+// declareQueue declares q and its bindings
+func (a *amqpDriver) declareQueue(ch *amqp.Channel, q Queue) (amqp.Queue, error) {
+	queue, err := ch.QueueDeclare(q.Name, a.durable(q.Durable), q.AutoDelete, q.Exclusive, false, q.table())
+	if err != nil {
+		return amqp.Queue{}, fmt.Errorf("mq/amqp: declare queue %s: %w", q.Name, err)
+	}
+
+	for _, b := range q.Bindings {
+		if err := ch.QueueBind(queue.Name, b.Key, a.source(b), false, b.Args); err != nil {
+			return amqp.Queue{}, fmt.Errorf("mq/amqp: bind queue %s: %w", q.Name, err)
+		}
+	}
+
+	return queue, nil
+}
+
+// exchange returns the declaration of the exchange name, a durable topic exchange when it's not declared
+func (a *amqpDriver) exchange(name string) Exchange {
+	for _, ex := range a.topology.Exchanges {
+		if ex.Name == name {
+			return ex
+		}
+	}
+	var durable = true
+	return Exchange{Name: name, Kind: amqp.ExchangeTopic, Durable: &durable}
+}
+
+// queue returns the declaration of the queue name
+func (a *amqpDriver) queue(name string) (Queue, bool) {
+	for _, q := range a.topology.Queues {
+		if name != "" && q.Name == name {
+			return q, true
+		}
+	}
+	return Queue{}, false
+}
+
+func (a *amqpDriver) durable(v *bool) bool {
+	if v == nil {
+		return a.Durable
+	}
+	return *v
+}
+
+func (a *amqpDriver) source(b Binding) string {
+	if b.Exchange == "" {
+		return a.ExchangeName
+	}
+	return b.Exchange
+}
+
+// table returns the queue arguments
+func (q Queue) table() amqp.Table {
+	var args = amqp.Table{}
+	for k, v := range q.Args {
+		args[k] = v
+	}
+
+	if q.Type != "" {
+		args["x-queue-type"] = q.Type
+	}
+	if q.MessageTTL > 0 {
+		args["x-message-ttl"] = q.MessageTTL.Milliseconds()
+	}
+	if q.Expires > 0 {
+		args["x-expires"] = q.Expires.Milliseconds()
+	}
+	if q.MaxLength > 0 {
+		args["x-max-length"] = int64(q.MaxLength)
+	}
+	if q.MaxLengthBytes > 0 {
+		args["x-max-length-bytes"] = int64(q.MaxLengthBytes)
+	}
+	if q.Overflow != "" {
+		args["x-overflow"] = q.Overflow
+	}
+	if q.DeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = q.DeadLetterExchange
+	}
+	if q.DeadLetterRoutingKey != "" {
+		args["x-dead-letter-routing-key"] = q.DeadLetterRoutingKey
+	}
+
+	if len(args) == 0 {
+		return nil
+	}
+	return args
+}
@@ -0,0 +1,71 @@
+package amqp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hysios/x/mq"
+	"github.com/mitchellh/mapstructure"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTopologyConfig 测试从 mq.Config 加载拓扑
+func TestTopologyConfig(t *testing.T) {
+	var raw = mq.Config{
+		"topology": map[string]interface{}{
+			"exchanges": []interface{}{
+				map[string]interface{}{"name": "orders", "kind": "direct"},
+				map[string]interface{}{"name": "orders.dlx", "kind": "fanout", "durable": false},
+			},
+			"queues": []interface{}{
+				map[string]interface{}{
+					"name":                 "billing",
+					"type":                 "quorum",
+					"message_ttl":          "30s",
+					"max_length":           1000,
+					"dead_letter_exchange": "orders.dlx",
+					"bindings": []interface{}{
+						map[string]interface{}{"exchange": "orders", "key": "created"},
+						map[string]interface{}{"exchange": "orders", "key": "paid"},
+					},
+				},
+			},
+		},
+	}
+
+	var cfg Config
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
+		Result:     &cfg,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, decoder.Decode(raw))
+
+	cfg = mergeConfigs(DefaultConfig, cfg, raw)
+	assert.Len(t, cfg.Topology.Exchanges, 2)
+	assert.Nil(t, cfg.Topology.Exchanges[0].Durable)
+	assert.False(t, *cfg.Topology.Exchanges[1].Durable)
+
+	var q = cfg.Topology.Queues[0]
+	assert.Equal(t, 30*time.Second, q.MessageTTL)
+	assert.Len(t, q.Bindings, 2)
+	assert.Equal(t, amqp.Table{
+		"x-queue-type":           "quorum",
+		"x-message-ttl":          int64(30000),
+		"x-max-length":           int64(1000),
+		"x-dead-letter-exchange": "orders.dlx",
+	}, q.table())
+
+	var d = &amqpDriver{ExchangeName: "events", Durable: true, topology: cfg.Topology}
+	assert.Equal(t, "direct", d.exchange("orders").Kind)
+	assert.True(t, d.durable(d.exchange("orders").Durable))
+	assert.True(t, d.durable(d.exchange("events").Durable))
+	assert.Equal(t, "events", d.source(Binding{}))
+
+	_, ok := d.queue("billing")
+	assert.True(t, ok)
+	_, ok = d.queue("")
+	assert.False(t, ok)
+	assert.Nil(t, Queue{}.table())
+}
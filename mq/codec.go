@@ -0,0 +1,67 @@
+package mq
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/hysios/x/cache"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encodes and decodes typed payloads, it is the pair of cache.Encoder and cache.Decoder
+type Codec interface {
+	cache.Encoder
+	cache.Decoder
+}
+
+type codec struct {
+	cache.Encoder
+	cache.Decoder
+}
+
+var (
+	// DefaultCodec is the JSON codec shared with the cache package
+	DefaultCodec Codec = NewCodec(cache.DefaultEncoder, cache.DefaultDecoder)
+	// ProtoCodec encodes payloads that implement proto.Message
+	ProtoCodec Codec = &protoCodec{}
+)
+
+// NewCodec combines an encoder and a decoder into a Codec
+func NewCodec(enc cache.Encoder, dec cache.Decoder) Codec {
+	return &codec{Encoder: enc, Decoder: dec}
+}
+
+type protoCodec struct{}
+
+func (p *protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("mq: %T is not a proto.Message", v)
+	}
+
+	return proto.Marshal(m)
+}
+
+func (p *protoCodec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+
+	// v is a pointer to a message pointer, e.g. **pb.User
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("mq: %T is not a proto.Message", v)
+	}
+
+	rv = rv.Elem()
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		rv.Set(reflect.New(rv.Type().Elem()))
+	}
+
+	m, ok := rv.Interface().(proto.Message)
+	if !ok {
+		return fmt.Errorf("mq: %T is not a proto.Message", v)
+	}
+
+	return proto.Unmarshal(data, m)
+}
@@ -0,0 +1,232 @@
+package delay
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/hysios/x/mq"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Scheduler keeps delayed messages in a redis sorted set scored by their delivery
+// time and publishes them through the wrapped driver once they are due. It gives
+// mq.DeliverAt and mq.Delay to drivers without native delayed delivery.
+type Scheduler struct {
+	mq.Driver
+
+	cli *redis.Client
+	opt Option
+}
+
+type Option struct {
+	// Key of the sorted set
+	Key string
+	// Interval between two polls
+	Interval time.Duration
+	// BatchSize is the max number of due messages moved per poll
+	BatchSize int
+	// RetryDelay postpones a due message whose publish failed
+	RetryDelay time.Duration
+	// Lease is how long a claimed message is hidden from the other pollers, it is
+	// due again when the poller crashes before publishing it
+	Lease time.Duration
+	Log   *zap.Logger
+}
+
+type Opt func(*Option)
+
+var DefaultOption = Option{
+	Key:        "$$mq:delay",
+	Interval:   time.Second,
+	BatchSize:  100,
+	RetryDelay: 5 * time.Second,
+	Lease:      30 * time.Second,
+}
+
+type entry struct {
+	ID        string            `json:"id"`
+	Topic     string            `json:"topic"`
+	Payload   []byte            `json:"payload"`
+	ReplyTo   string            `json:"reply_to,omitempty"`
+	Queue     string            `json:"queue,omitempty"`
+	MessageID string            `json:"message_id,omitempty"`
+	Key       string            `json:"key,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+// claimDue atomically returns the members due at ARGV[1] and moves their score
+// to the lease deadline ARGV[3], they are removed once published
+var claimDue = redis.NewScript(`
+local items = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, item in ipairs(items) do
+	redis.call('ZADD', KEYS[1], ARGV[3], item)
+end
+return items
+`)
+
+// New wraps driver, delayed publishes are stored in redis until Run moves them
+func New(cli *redis.Client, driver mq.Driver, opts ...Opt) *Scheduler {
+	var opt = DefaultOption
+	opt.Log = zap.NewNop()
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return &Scheduler{
+		Driver: driver,
+		cli:    cli,
+		opt:    opt,
+	}
+}
+
+// WithKey
+func WithKey(key string) Opt {
+	return func(o *Option) {
+		o.Key = key
+	}
+}
+
+// WithInterval
+func WithInterval(d time.Duration) Opt {
+	return func(o *Option) {
+		o.Interval = d
+	}
+}
+
+// WithLease
+func WithLease(d time.Duration) Opt {
+	return func(o *Option) {
+		o.Lease = d
+	}
+}
+
+// WithLogger
+func WithLogger(log *zap.Logger) Opt {
+	return func(o *Option) {
+		o.Log = log
+	}
+}
+
+// Publish publishes the message right away unless it has a future delivery time
+func (s *Scheduler) Publish(topic string, payload []byte, opts ...mq.PubOpt) error {
+	var opt = &mq.PubOption{}
+	for _, o := range opts {
+		o(opt)
+	}
+
+	if opt.Delay() <= 0 {
+		return s.Driver.Publish(topic, payload, opts...)
+	}
+
+	data, err := json.Marshal(&entry{
+		ID:        watermill.NewUUID(),
+		Topic:     topic,
+		Payload:   payload,
+		ReplyTo:   opt.ReplyTo,
+		Queue:     opt.Queue,
+		MessageID: opt.MessageID,
+		Key:       opt.Key,
+		Headers:   opt.Headers,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.cli.ZAdd(context.Background(), s.opt.Key, redis.Z{
+		Score:  float64(opt.DeliverAt.UnixMilli()),
+		Member: data,
+	}).Err()
+}
+
+// Run moves the due messages until ctx is done
+func (s *Scheduler) Run(ctx context.Context) error {
+	var ticker = time.NewTicker(s.opt.Interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := s.Poll(ctx)
+			if err != nil {
+				s.opt.Log.Warn("delay poll error", zap.Error(err))
+			}
+			if err != nil || n < s.opt.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll publishes one batch of due messages and returns how many were due. The
+// due messages are leased while publishing and removed once published, a crash
+// redelivers them after the lease.
+func (s *Scheduler) Poll(ctx context.Context) (int, error) {
+	var now = time.Now()
+
+	items, err := claimDue.Run(ctx, s.cli, []string{s.opt.Key},
+		strconv.FormatInt(now.UnixMilli(), 10), s.opt.BatchSize,
+		strconv.FormatInt(now.Add(s.opt.Lease).UnixMilli(), 10)).StringSlice()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, item := range items {
+		var e entry
+		if err := json.Unmarshal([]byte(item), &e); err != nil {
+			s.opt.Log.Warn("drop malformed delayed message", zap.String("item", item), zap.Error(err))
+			if err := s.cli.ZRem(ctx, s.opt.Key, item).Err(); err != nil {
+				return len(items), err
+			}
+			continue
+		}
+
+		var opts []mq.PubOpt
+		if e.ReplyTo != "" {
+			opts = append(opts, mq.ReplyTo(e.ReplyTo))
+		}
+		if e.Queue != "" {
+			opts = append(opts, mq.QueueTo(e.Queue))
+		}
+		if e.MessageID != "" {
+			opts = append(opts, mq.MessageID(e.MessageID))
+		}
+		if e.Key != "" {
+			opts = append(opts, mq.Key(e.Key))
+		}
+		if len(e.Headers) > 0 {
+			opts = append(opts, mq.Headers(e.Headers))
+		}
+
+		if err := s.Driver.Publish(e.Topic, e.Payload, opts...); err != nil {
+			s.opt.Log.Warn("publish delayed message error", zap.String("topic", e.Topic), zap.Error(err))
+			if err := s.cli.ZAdd(ctx, s.opt.Key, redis.Z{
+				Score:  float64(now.Add(s.opt.RetryDelay).UnixMilli()),
+				Member: item,
+			}).Err(); err != nil {
+				return len(items), err
+			}
+			continue
+		}
+
+		if err := s.cli.ZRem(ctx, s.opt.Key, item).Err(); err != nil {
+			return len(items), err
+		}
+	}
+
+	return len(items), nil
+}
+
+// Pending returns the number of messages waiting for delivery
+func (s *Scheduler) Pending(ctx context.Context) (int64, error) {
+	return s.cli.ZCard(ctx, s.opt.Key).Result()
+}
@@ -0,0 +1,97 @@
+package delay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hysios/x/mq"
+	"github.com/hysios/x/mq/memory"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler(t *testing.T) {
+	var (
+		mr     = miniredis.RunT(t)
+		cli    = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		driver = memory.Open(memory.DefaultConfig)
+		s      = New(cli, driver)
+		ctx    = context.Background()
+	)
+
+	ch, _ := s.Subscribe("orders")
+
+	assert.NoError(t, s.Publish("orders", []byte("now")))
+	assert.NoError(t, s.Publish("orders", []byte("later"), mq.Delay(50*time.Millisecond), mq.MessageID("m1")))
+	assert.Equal(t, "now", string((<-ch).Payload()))
+
+	n, err := s.Poll(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	pending, _ := s.Pending(ctx)
+	assert.Equal(t, int64(1), pending)
+
+	time.Sleep(60 * time.Millisecond)
+	n, err = s.Poll(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	msg := <-ch
+	assert.Equal(t, "later", string(msg.Payload()))
+	assert.Equal(t, "m1", mq.IDOf(msg))
+
+	pending, _ = s.Pending(ctx)
+	assert.Equal(t, int64(0), pending)
+}
+
+func TestSchedulerPastDeliverAt(t *testing.T) {
+	var (
+		mr     = miniredis.RunT(t)
+		driver = memory.Open(memory.DefaultConfig)
+		s      = New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), driver)
+	)
+
+	ch, _ := s.Subscribe("orders")
+	assert.NoError(t, s.Publish("orders", []byte("past"), mq.DeliverAt(time.Now().Add(-time.Minute))))
+	assert.Equal(t, "past", string((<-ch).Payload()))
+}
+
+func TestSchedulerLease(t *testing.T) {
+	var (
+		mr  = miniredis.RunT(t)
+		cli = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		s   = New(cli, memory.Open(memory.DefaultConfig), WithLease(30*time.Millisecond))
+		ctx = context.Background()
+	)
+
+	ch, _ := s.Subscribe("orders")
+	assert.NoError(t, s.Publish("orders", []byte("later"), mq.Delay(time.Millisecond)))
+	time.Sleep(5 * time.Millisecond)
+
+	// a poller crashed after claiming the message
+	var now = time.Now()
+	items, err := claimDue.Run(ctx, cli, []string{s.opt.Key},
+		now.UnixMilli(), 10, now.Add(s.opt.Lease).UnixMilli()).StringSlice()
+	assert.NoError(t, err)
+	assert.Len(t, items, 1)
+
+	n, err := s.Poll(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	pending, _ := s.Pending(ctx)
+	assert.Equal(t, int64(1), pending)
+
+	// the lease expired
+	time.Sleep(40 * time.Millisecond)
+	n, err = s.Poll(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "later", string((<-ch).Payload()))
+
+	pending, _ = s.Pending(ctx)
+	assert.Equal(t, int64(0), pending)
+}
@@ -0,0 +1,238 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// ErrDrainTimeout is returned by Handle when the handlers outlive DrainTimeout
+var ErrDrainTimeout = errors.New("mq: drain timeout")
+
+// HandlerFunc processes a message, a nil error acks it, otherwise it is nacked
+type HandlerFunc func(ctx context.Context, msg Message) error
+
+type HandleOption struct {
+	// Concurrency is the number of workers
+	Concurrency int
+	// Key returns the ordering key of a message, messages with the same key are
+	// processed in order by the same worker. It defaults to KeyOf.
+	Key func(msg Message) string
+	// DrainTimeout bounds the time spent processing the queued messages on shutdown,
+	// the messages left afterwards are nacked
+	DrainTimeout time.Duration
+
+	subOpts  []SubOpt
+	prefetch int
+}
+
+type HandleOpt func(*HandleOption)
+
+var DefaultHandleOption = HandleOption{
+	Concurrency:  1,
+	Key:          KeyOf,
+	DrainTimeout: 30 * time.Second,
+}
+
+// Concurrency sets the number of workers
+func Concurrency(n int) HandleOpt {
+	return func(o *HandleOption) {
+		o.Concurrency = n
+	}
+}
+
+// Prefetch sets how many unacked messages the broker may push to the subscription
+func Prefetch(n int) HandleOpt {
+	return func(o *HandleOption) {
+		o.prefetch = n
+	}
+}
+
+// OrderBy sets the ordering key of messages
+func OrderBy(key func(msg Message) string) HandleOpt {
+	return func(o *HandleOption) {
+		o.Key = key
+	}
+}
+
+// DrainTimeout
+func DrainTimeout(d time.Duration) HandleOpt {
+	return func(o *HandleOption) {
+		o.DrainTimeout = d
+	}
+}
+
+// Subscription is a topic of a Subscriber, it's what Handle consumes
+type Subscription struct {
+	Subscriber Subscriber
+	Topic      string
+	Options    []SubOpt
+}
+
+// Bind binds sub to topic, opts are passed to Subscribe
+func Bind(sub Subscriber, topic string, opts ...SubOpt) Subscription {
+	return Subscription{Subscriber: sub, Topic: topic, Options: opts}
+}
+
+// SubscribeWith passes options to the underlying Subscribe
+func SubscribeWith(opts ...SubOpt) HandleOpt {
+	return func(o *HandleOption) {
+		o.subOpts = append(o.subOpts, opts...)
+	}
+}
+
+// Handle subscribes to the topic of sub and processes the messages with a pool
+// of workers until ctx is done. Messages with an ordering key are hashed to a
+// worker so their order is kept, the others are spread round robin. A panic in
+// handler nacks the message. The consume middlewares of a driver returned by
+// Wrap run around handler.
+//
+//	mq.Handle(ctx, mq.Bind(driver, "orders"), handler, mq.Concurrency(8), mq.Prefetch(32))
+//
+// On shutdown the subscription is ended, the messages it buffered are nacked
+// and the queued ones are processed for DrainTimeout. Handle then returns
+// ErrDrainTimeout without waiting for the handlers ignoring their context.
+func Handle(ctx context.Context, sub Subscription, handler HandlerFunc, opts ...HandleOpt) error {
+	var opt = DefaultHandleOption
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	if opt.Concurrency < 1 {
+		opt.Concurrency = 1
+	}
+
+	// the subscription ends once the dispatch stops
+	subCtx, unsubscribe := context.WithCancel(ctx)
+	defer unsubscribe()
+
+	var subOpts = append(append(sub.Options[:len(sub.Options):len(sub.Options)], opt.subOpts...), Until(subCtx))
+	if opt.prefetch > 0 {
+		subOpts = append(subOpts, func(o *SubOption) {
+			o.Prefetch = opt.prefetch
+		})
+	}
+
+	msgs, err := sub.Subscriber.Subscribe(sub.Topic, subOpts...)
+	if err != nil {
+		return err
+	}
+
+	handler = wrapHandler(sub.Subscriber, sub.Topic, handler)
+
+	var (
+		// the handlers keep running while draining, they are cancelled after DrainTimeout
+		hctx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+		queues       = make([]chan Message, opt.Concurrency)
+		wg           sync.WaitGroup
+	)
+	defer cancel()
+
+	for i := range queues {
+		queues[i] = make(chan Message, opt.prefetch/opt.Concurrency+1)
+
+		wg.Add(1)
+		go func(queue chan Message) {
+			defer wg.Done()
+
+			for msg := range queue {
+				if hctx.Err() != nil {
+					Nack(msg)
+					continue
+				}
+
+				if err := safeHandle(hctx, handler, msg); err != nil {
+					Nack(msg)
+				} else {
+					msg.Ack()
+				}
+			}
+		}(queues[i])
+	}
+
+	dispatch(ctx, msgs, queues, opt.Key)
+
+	unsubscribe()
+	nackBuffered(msgs)
+
+	for _, queue := range queues {
+		close(queue)
+	}
+
+	var done = make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(opt.DrainTimeout):
+		// the workers nack what is left in their queue
+		cancel()
+		return ErrDrainTimeout
+	}
+}
+
+// nackBuffered gives back the messages received by the subscription and not dispatched yet
+func nackBuffered(msgs <-chan Message) {
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				return
+			}
+			Nack(msg)
+		default:
+			return
+		}
+	}
+}
+
+// dispatch
+func dispatch(ctx context.Context, msgs <-chan Message, queues []chan Message, key func(msg Message) string) {
+	var next int
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-msgs:
+			if !ok {
+				return
+			}
+
+			var i int
+			if k := key(msg); k != "" {
+				h := fnv.New32a()
+				h.Write([]byte(k))
+				i = int(h.Sum32() % uint32(len(queues)))
+			} else {
+				i = next % len(queues)
+				next++
+			}
+
+			select {
+			case queues[i] <- msg:
+			case <-ctx.Done():
+				Nack(msg)
+				return
+			}
+		}
+	}
+}
+
+// safeHandle converts a handler panic to an error
+func safeHandle(ctx context.Context, handler HandlerFunc, msg Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("mq: handler panic: %v", r)
+		}
+	}()
+
+	return handler(ctx, msg)
+}
@@ -0,0 +1,203 @@
+package mq
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type keyedMessage struct {
+	fakeMessage
+	key string
+}
+
+func (m *keyedMessage) Key() string { return m.key }
+
+type chanSubscriber struct {
+	ch  chan Message
+	opt SubOption
+}
+
+func (c *chanSubscriber) Subscribe(topic string, opts ...SubOpt) (<-chan Message, error) {
+	for _, o := range opts {
+		o(&c.opt)
+	}
+	return c.ch, nil
+}
+
+func TestHandleConcurrency(t *testing.T) {
+	var (
+		sub           = &chanSubscriber{ch: make(chan Message, 10)}
+		acked         = make(chan bool, 10)
+		ctx, cancel   = context.WithCancel(context.Background())
+		running, peak int32
+		done          = make(chan error)
+	)
+
+	for i := 0; i < 4; i++ {
+		sub.ch <- &fakeMessage{acked: acked}
+	}
+
+	go func() {
+		done <- Handle(ctx, Bind(sub, "orders"), func(ctx context.Context, msg Message) error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil
+		}, Concurrency(4), Prefetch(8))
+	}()
+
+	for i := 0; i < 4; i++ {
+		assert.True(t, waitAck(t, acked))
+	}
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Equal(t, int32(4), peak)
+	assert.Equal(t, 8, sub.opt.Prefetch)
+}
+
+func TestHandleOrderedByKey(t *testing.T) {
+	var (
+		sub         = &chanSubscriber{ch: make(chan Message, 100)}
+		acked       = make(chan bool, 100)
+		ctx, cancel = context.WithCancel(context.Background())
+		mu          sync.Mutex
+		got         = make(map[string][]int)
+		done        = make(chan error)
+	)
+
+	for i := 0; i < 30; i++ {
+		var key = []string{"a", "b", "c"}[i%3]
+		sub.ch <- &keyedMessage{fakeMessage: fakeMessage{payload: []byte{byte(i)}, acked: acked}, key: key}
+	}
+
+	go func() {
+		done <- Handle(ctx, Bind(sub, "orders"), func(ctx context.Context, msg Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got[KeyOf(msg)] = append(got[KeyOf(msg)], int(msg.Payload()[0]))
+			return nil
+		}, Concurrency(3))
+	}()
+
+	for i := 0; i < 30; i++ {
+		waitAck(t, acked)
+	}
+	cancel()
+	assert.NoError(t, <-done)
+
+	for _, vals := range got {
+		for i := 1; i < len(vals); i++ {
+			assert.Less(t, vals[i-1], vals[i])
+		}
+	}
+}
+
+func TestHandlePanicNacks(t *testing.T) {
+	var (
+		sub         = &chanSubscriber{ch: make(chan Message, 1)}
+		acked       = make(chan bool, 1)
+		ctx, cancel = context.WithCancel(context.Background())
+		done        = make(chan error)
+	)
+
+	sub.ch <- &fakeMessage{acked: acked}
+	go func() {
+		done <- Handle(ctx, Bind(sub, "orders"), func(ctx context.Context, msg Message) error {
+			panic("boom")
+		})
+	}()
+
+	assert.False(t, waitAck(t, acked))
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestHandleDrain(t *testing.T) {
+	var (
+		sub         = &chanSubscriber{ch: make(chan Message, 1)}
+		acked       = make(chan bool, 1)
+		ctx, cancel = context.WithCancel(context.Background())
+		started     = make(chan struct{})
+		done        = make(chan error)
+	)
+
+	sub.ch <- &fakeMessage{acked: acked}
+	go func() {
+		done <- Handle(ctx, Bind(sub, "orders"), func(hctx context.Context, msg Message) error {
+			close(started)
+			time.Sleep(30 * time.Millisecond)
+			return hctx.Err()
+		})
+	}()
+
+	<-started
+	cancel()
+	assert.NoError(t, <-done)
+	// the in-flight message completes with a live context and is acked
+	assert.True(t, waitAck(t, acked))
+}
+
+func TestHandleDrainTimeout(t *testing.T) {
+	var (
+		sub         = &chanSubscriber{ch: make(chan Message, 1)}
+		acked       = make(chan bool, 1)
+		ctx, cancel = context.WithCancel(context.Background())
+		started     = make(chan struct{})
+		release     = make(chan struct{})
+		done        = make(chan error)
+	)
+	defer close(release)
+
+	sub.ch <- &fakeMessage{acked: acked}
+	go func() {
+		done <- Handle(ctx, Bind(sub, "orders"), func(hctx context.Context, msg Message) error {
+			// ignores its context
+			close(started)
+			<-release
+			return nil
+		}, DrainTimeout(20*time.Millisecond))
+	}()
+
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, ErrDrainTimeout)
+	case <-time.After(time.Second):
+		t.Fatal("Handle waits for the handler")
+	}
+	assert.Error(t, sub.opt.Context.Err(), "the subscription is ended")
+}
+
+func TestTypedSubscriberHandle(t *testing.T) {
+	var (
+		d           = newFakeDriver()
+		topic       = NewTopic[order]("order.created")
+		ctx, cancel = context.WithCancel(context.Background())
+		done        = make(chan error)
+	)
+
+	go func() {
+		done <- topic.Subscriber(d).Handle(ctx, func(ctx context.Context, val order, meta Meta) error {
+			assert.Equal(t, 7, val.Id)
+			return nil
+		}, Concurrency(2))
+	}()
+
+	assert.NoError(t, topic.Publisher(d).Publish(ctx, order{Id: 7}))
+	assert.True(t, waitAck(t, d.acked))
+	cancel()
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,176 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/hysios/x/mq"
+	"github.com/mitchellh/mapstructure"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.uber.org/zap"
+)
+
+type Config struct {
+	Brokers  []string `mapstructure:"brokers"`
+	ClientID string   `mapstructure:"client_id"`
+	// CommitInterval is how often the acked offsets are committed
+	CommitInterval time.Duration `mapstructure:"commit_interval"`
+	PublishTimeout time.Duration `mapstructure:"publish_timeout"`
+	// StartOffset is where a new consumer group starts, "earliest" or "latest"
+	StartOffset string `mapstructure:"start_offset"`
+	// Buffer is the channel size of every subscription
+	Buffer int `mapstructure:"buffer"`
+	// Options are appended to the options of every client
+	Options []kgo.Opt `mapstructure:"-"`
+	// Log receives the fetch errors of the subscriptions
+	Log *zap.Logger `mapstructure:"-"`
+}
+
+var (
+	DefaultConfig = Config{
+		Brokers:        []string{"localhost:9092"},
+		ClientID:       "mq",
+		CommitInterval: 5 * time.Second,
+		PublishTimeout: 5 * time.Second,
+		StartOffset:    "earliest",
+		Buffer:         64,
+	}
+)
+
+// IDHeader carries the message id
+const IDHeader = "message-id"
+
+type kafkaDriver struct {
+	cfg      Config
+	producer *kgo.Client
+
+	mu   sync.Mutex
+	subs map[*subscription]bool
+}
+
+var Default *kafkaDriver
+
+func Open(cfg Config) (*kafkaDriver, error) {
+	if cfg.Log == nil {
+		cfg.Log = zap.NewNop()
+	}
+
+	producer, err := kgo.NewClient(cfg.clientOpts(
+		kgo.RecordPartitioner(kgo.StickyKeyPartitioner(nil)),
+	)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var driver = &kafkaDriver{
+		cfg:      cfg,
+		producer: producer,
+		subs:     make(map[*subscription]bool),
+	}
+
+	if Default == nil {
+		Default = driver
+		return Default, nil
+	}
+
+	return driver, nil
+}
+
+// clientOpts
+func (cfg Config) clientOpts(opts ...kgo.Opt) []kgo.Opt {
+	var base = []kgo.Opt{
+		kgo.SeedBrokers(cfg.Brokers...),
+		kgo.AllowAutoTopicCreation(),
+	}
+
+	if cfg.ClientID != "" {
+		base = append(base, kgo.ClientID(cfg.ClientID))
+	}
+
+	base = append(base, opts...)
+	return append(base, cfg.Options...)
+}
+
+// Publish produces the message synchronously, mq.Key selects the partition
+func (k *kafkaDriver) Publish(topic string, payload []byte, opts ...mq.PubOpt) error {
+	var opt = &mq.PubOption{}
+	for _, o := range opts {
+		o(opt)
+	}
+
+	if opt.Delay() > 0 {
+		return mq.ErrDelayUnsupported
+	}
+
+	var id = opt.MessageID
+	if id == "" {
+		id = watermill.NewUUID()
+	}
+
+	var record = &kgo.Record{
+		Topic:   topic,
+		Value:   payload,
+		Headers: []kgo.RecordHeader{{Key: IDHeader, Value: []byte(id)}},
+	}
+	if opt.Key != "" {
+		record.Key = []byte(opt.Key)
+	}
+	for k, v := range opt.Headers {
+		record.Headers = append(record.Headers, kgo.RecordHeader{Key: k, Value: []byte(v)})
+	}
+
+	var ctx = opt.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, k.cfg.PublishTimeout)
+	defer cancel()
+
+	return k.producer.ProduceSync(ctx, record).FirstErr()
+}
+
+// Close stops the subscriptions, commits their acked offsets and closes the producer
+func (k *kafkaDriver) Close() error {
+	k.mu.Lock()
+	var subs = k.subs
+	k.subs = make(map[*subscription]bool)
+	k.mu.Unlock()
+
+	for sub := range subs {
+		sub.close()
+	}
+
+	k.producer.Close()
+	return nil
+}
+
+func init() {
+	mq.Register("kafka", func(c mq.Config) mq.Driver {
+		var cfg = DefaultConfig
+
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			DecodeHook: mapstructure.ComposeDecodeHookFunc(
+				mapstructure.StringToTimeDurationHookFunc(),
+				mapstructure.StringToSliceHookFunc(","),
+			),
+			Result: &cfg,
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		if err := decoder.Decode(c); err != nil {
+			panic(err)
+		}
+
+		driver, err := Open(cfg)
+		if err != nil {
+			panic(err)
+		}
+
+		return driver
+	})
+}
@@ -0,0 +1,138 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hysios/x/mq"
+	"github.com/stretchr/testify/assert"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func testDriver(t *testing.T, topics ...string) (*kafkaDriver, *kfake.Cluster) {
+	c, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(2, topics...))
+	if err != nil {
+		t.Fatalf("start fake cluster: %v", err)
+	}
+	t.Cleanup(c.Close)
+
+	var cfg = DefaultConfig
+	cfg.Brokers = c.ListenAddrs()
+	cfg.CommitInterval = 100 * time.Millisecond
+
+	d, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("open driver: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	return d, c
+}
+
+func recv(t *testing.T, ch <-chan mq.Message) mq.Message {
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(10 * time.Second):
+		t.Fatal("receive timeout")
+	}
+	return nil
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	d, _ := testDriver(t, "orders")
+
+	ch, err := d.Subscribe("orders", mq.Queue("billing"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, d.Publish("orders", []byte("o1"), mq.Key("user-1"), mq.MessageID("m1")))
+
+	msg := recv(t, ch)
+	assert.Equal(t, "o1", string(msg.Payload()))
+	assert.Equal(t, "m1", mq.IDOf(msg))
+	assert.Equal(t, "user-1", mq.KeyOf(msg))
+	assert.True(t, msg.Ack())
+	assert.False(t, msg.Ack())
+}
+
+func TestPartitionKeyOrder(t *testing.T) {
+	d, _ := testDriver(t, "orders")
+
+	ch, err := d.Subscribe("orders", mq.Queue("billing"))
+	assert.NoError(t, err)
+
+	for _, v := range []string{"1", "2", "3"} {
+		assert.NoError(t, d.Publish("orders", []byte(v), mq.Key("user-1")))
+	}
+
+	for _, v := range []string{"1", "2", "3"} {
+		msg := recv(t, ch)
+		assert.Equal(t, v, string(msg.Payload()))
+		msg.Ack()
+	}
+}
+
+func TestNackAndCommit(t *testing.T) {
+	d, c := testDriver(t, "orders")
+
+	ch, err := d.Subscribe("orders", mq.Queue("billing"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, d.Publish("orders", []byte("a"), mq.Key("k")))
+	assert.NoError(t, d.Publish("orders", []byte("b"), mq.Key("k")))
+
+	a := recv(t, ch)
+	b := recv(t, ch)
+	assert.True(t, b.Ack())
+	assert.True(t, mq.Nack(a))
+
+	a = recv(t, ch)
+	assert.Equal(t, "a", string(a.Payload()))
+	assert.True(t, a.Ack())
+
+	adm := kadm.NewClient(newClient(t, c))
+	assert.Eventually(t, func() bool {
+		offsets, err := adm.FetchOffsets(context.Background(), "billing")
+		if err != nil {
+			return false
+		}
+
+		var committed int64
+		offsets.Each(func(o kadm.OffsetResponse) {
+			committed += o.At
+		})
+		return committed == 2
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestDelayUnsupported(t *testing.T) {
+	d, _ := testDriver(t, "orders")
+	assert.ErrorIs(t, d.Publish("orders", nil, mq.Delay(time.Minute)), mq.ErrDelayUnsupported)
+}
+
+func newClient(t *testing.T, c *kfake.Cluster) *kgo.Client {
+	cl, err := kgo.NewClient(kgo.SeedBrokers(c.ListenAddrs()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cl.Close)
+	return cl
+}
+
+func TestCloseWithPendingResend(t *testing.T) {
+	d, _ := testDriver(t, "orders")
+
+	ch, err := d.Subscribe("orders", mq.Queue("billing"))
+	assert.NoError(t, err)
+	assert.NoError(t, d.Publish("orders", []byte("a")))
+
+	// the resend waits for a reader when the driver is closed
+	assert.True(t, mq.Nack(recv(t, ch)))
+	assert.NoError(t, d.Close())
+
+	for range ch {
+	}
+}
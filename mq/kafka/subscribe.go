@@ -0,0 +1,316 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/hysios/x/mq"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.uber.org/zap"
+)
+
+type subscription struct {
+	cl     *kgo.Client
+	ch     chan mq.Message
+	cancel context.CancelFunc
+	done   chan struct{}
+	log    *zap.Logger
+
+	// resends are the nacked messages waiting to be delivered again, ch is closed once they are done
+	resends sync.WaitGroup
+	closing bool
+
+	// group is empty when the subscription reads every partition without a consumer group
+	group string
+
+	mu    sync.Mutex
+	parts map[topicPartition]*partition
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// partition tracks the delivered offsets of an assigned partition, acked offsets
+// are only marked for commit once every earlier offset is acked as well
+type partition struct {
+	offsets []int64
+	acked   map[int64]bool
+	epoch   int32
+	revoked bool
+}
+
+// Subscribe consumes topic, mq.Queue is the consumer group. Acked offsets are
+// committed every CommitInterval and when partitions are revoked.
+func (k *kafkaDriver) Subscribe(topic string, opts ...mq.SubOpt) (<-chan mq.Message, error) {
+	var opt = &mq.SubOption{}
+	for _, o := range opts {
+		o(opt)
+	}
+
+	var sub = &subscription{
+		ch:    make(chan mq.Message, k.cfg.Buffer),
+		done:  make(chan struct{}),
+		log:   k.cfg.Log,
+		group: opt.Queue,
+		parts: make(map[topicPartition]*partition),
+	}
+
+	var start = kgo.NewOffset().AtStart()
+	if k.cfg.StartOffset == "latest" {
+		start = kgo.NewOffset().AtEnd()
+	}
+
+	var clientOpts = []kgo.Opt{
+		kgo.ConsumeTopics(append([]string{topic}, opt.Subjects...)...),
+		kgo.ConsumeResetOffset(start),
+	}
+
+	if sub.group != "" {
+		clientOpts = append(clientOpts,
+			kgo.ConsumerGroup(sub.group),
+			kgo.AutoCommitMarks(),
+			kgo.AutoCommitInterval(k.cfg.CommitInterval),
+			kgo.OnPartitionsRevoked(sub.onRevoked),
+			kgo.OnPartitionsLost(sub.onLost),
+		)
+	}
+
+	cl, err := kgo.NewClient(k.cfg.clientOpts(clientOpts...)...)
+	if err != nil {
+		return nil, err
+	}
+	sub.cl = cl
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub.cancel = cancel
+	go sub.poll(ctx)
+
+	k.mu.Lock()
+	k.subs[sub] = true
+	k.mu.Unlock()
+
+	if opt.Context != nil {
+		go func() {
+			select {
+			case <-opt.Context.Done():
+				k.unsubscribe(sub)
+			case <-sub.done:
+			}
+		}()
+	}
+
+	return sub.ch, nil
+}
+
+// unsubscribe closes sub unless the driver already did
+func (k *kafkaDriver) unsubscribe(sub *subscription) {
+	k.mu.Lock()
+	var ok = k.subs[sub]
+	delete(k.subs, sub)
+	k.mu.Unlock()
+
+	if ok {
+		sub.close()
+	}
+}
+
+// poll
+func (s *subscription) poll(ctx context.Context) {
+	defer close(s.done)
+
+	for {
+		fetches := s.cl.PollFetches(ctx)
+		if ctx.Err() != nil || fetches.IsClientClosed() {
+			return
+		}
+
+		fetches.EachError(func(topic string, partition int32, err error) {
+			s.log.Warn("kafka fetch error", zap.String("topic", topic), zap.Int32("partition", partition), zap.String("group", s.group), zap.Error(err))
+		})
+
+		fetches.EachRecord(func(r *kgo.Record) {
+			var msg = &message{Record: r, sub: s, part: s.track(r)}
+			select {
+			case s.ch <- msg:
+			case <-ctx.Done():
+			}
+		})
+	}
+}
+
+// track
+func (s *subscription) track(r *kgo.Record) *partition {
+	if s.group == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tp = topicPartition{topic: r.Topic, partition: r.Partition}
+	p, ok := s.parts[tp]
+	if !ok {
+		p = &partition{acked: make(map[int64]bool)}
+		s.parts[tp] = p
+	}
+
+	p.offsets = append(p.offsets, r.Offset)
+	p.epoch = r.LeaderEpoch
+	return p
+}
+
+// ack marks the contiguous acked offsets of the partition for the next commit
+func (s *subscription) ack(r *kgo.Record, p *partition) {
+	if p == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.revoked {
+		return
+	}
+
+	p.acked[r.Offset] = true
+
+	var next int64 = -1
+	for len(p.offsets) > 0 && p.acked[p.offsets[0]] {
+		delete(p.acked, p.offsets[0])
+		next = p.offsets[0] + 1
+		p.offsets = p.offsets[1:]
+	}
+
+	if next < 0 {
+		return
+	}
+
+	s.cl.MarkCommitOffsets(map[string]map[int32]kgo.EpochOffset{
+		r.Topic: {r.Partition: {Epoch: p.epoch, Offset: next}},
+	})
+}
+
+// onRevoked commits what was acked before the partitions move to another member
+func (s *subscription) onRevoked(ctx context.Context, cl *kgo.Client, revoked map[string][]int32) {
+	s.drop(revoked)
+	_ = cl.CommitMarkedOffsets(ctx)
+}
+
+// onLost forgets the partitions, their offsets can't be committed anymore
+func (s *subscription) onLost(ctx context.Context, cl *kgo.Client, lost map[string][]int32) {
+	s.drop(lost)
+}
+
+// drop
+func (s *subscription) drop(parts map[string][]int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for topic, partitions := range parts {
+		for _, n := range partitions {
+			var tp = topicPartition{topic: topic, partition: n}
+			if p, ok := s.parts[tp]; ok {
+				p.revoked = true
+				delete(s.parts, tp)
+			}
+		}
+	}
+}
+
+// resend delivers a nacked message again, its offset stays uncommitted meanwhile
+func (s *subscription) resend(msg *message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return
+	}
+
+	s.resends.Add(1)
+	go func() {
+		defer s.resends.Done()
+
+		select {
+		case s.ch <- msg:
+		case <-s.done:
+		}
+	}()
+}
+
+// close
+func (s *subscription) close() {
+	s.cancel()
+	<-s.done
+
+	// the pending resends give up once done is closed
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+	s.resends.Wait()
+
+	if s.group != "" {
+		_ = s.cl.CommitMarkedOffsets(context.Background())
+	}
+	s.cl.Close()
+	close(s.ch)
+}
+
+type message struct {
+	*kgo.Record
+
+	sub  *subscription
+	part *partition
+	once sync.Once
+}
+
+func (m *message) Payload() []byte {
+	return m.Value
+}
+
+// ID returns the message-id header, or the record position when it's not set
+func (m *message) ID() string {
+	for _, h := range m.Record.Headers {
+		if h.Key == IDHeader {
+			return string(h.Value)
+		}
+	}
+	return fmt.Sprintf("%s:%d:%d", m.Topic, m.Partition, m.Offset)
+}
+
+func (m *message) Key() string {
+	return string(m.Record.Key)
+}
+
+// Headers returns the record headers except the message id
+func (m *message) Headers() map[string]string {
+	var headers = make(map[string]string, len(m.Record.Headers))
+	for _, h := range m.Record.Headers {
+		if h.Key != IDHeader {
+			headers[h.Key] = string(h.Value)
+		}
+	}
+	return headers
+}
+
+// Ack marks the offset for the next batched commit
+func (m *message) Ack() bool {
+	var ok bool
+	m.once.Do(func() {
+		ok = true
+		m.sub.ack(m.Record, m.part)
+	})
+	return ok
+}
+
+// Nack redelivers the record to the subscription
+func (m *message) Nack() bool {
+	var ok bool
+	m.once.Do(func() {
+		ok = true
+		m.sub.resend(&message{Record: m.Record, sub: m.sub, part: m.part})
+	})
+	return ok
+}
@@ -0,0 +1,273 @@
+package memory
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/hysios/x/mq"
+	"github.com/mitchellh/mapstructure"
+)
+
+type Config struct {
+	// Buffer is the channel size of every subscription
+	Buffer int `mapstructure:"buffer"`
+}
+
+var DefaultConfig = Config{
+	Buffer: 64,
+}
+
+var ErrClosed = errors.New("mq/memory: driver closed")
+
+type memoryDriver struct {
+	mu     sync.RWMutex
+	groups map[string]map[string]*group
+	buffer int
+	closed bool
+}
+
+// group is a set of competing consumers sharing one channel
+type group struct {
+	mu     sync.RWMutex
+	ch     chan mq.Message
+	done   chan struct{}
+	closed bool
+	// refs is the number of subscriptions sharing the group, guarded by the driver
+	refs int
+}
+
+func newGroup(buffer int) *group {
+	return &group{ch: make(chan mq.Message, buffer), done: make(chan struct{})}
+}
+
+// Open creates an in-process driver, messages are only delivered to subscriptions made before publishing
+func Open(cfg Config) *memoryDriver {
+	return &memoryDriver{
+		groups: make(map[string]map[string]*group),
+		buffer: cfg.Buffer,
+	}
+}
+
+// Publish
+func (d *memoryDriver) Publish(topic string, payload []byte, opts ...mq.PubOpt) error {
+	var opt = &mq.PubOption{}
+	for _, o := range opts {
+		o(opt)
+	}
+
+	d.mu.RLock()
+	closed := d.closed
+	d.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+
+	var id = opt.MessageID
+	if id == "" {
+		id = watermill.NewUUID()
+	}
+
+	if delay := opt.Delay(); delay > 0 {
+		time.AfterFunc(delay, func() {
+			d.deliver(topic, id, opt.Key, opt.Headers, payload)
+		})
+		return nil
+	}
+
+	d.deliver(topic, id, opt.Key, opt.Headers, payload)
+	return nil
+}
+
+// deliver sends the message to every group subscribed to topic
+func (d *memoryDriver) deliver(topic, id, key string, headers map[string]string, payload []byte) {
+	d.mu.RLock()
+	var groups = make([]*group, 0, len(d.groups[topic]))
+	for _, g := range d.groups[topic] {
+		groups = append(groups, g)
+	}
+	d.mu.RUnlock()
+
+	for _, g := range groups {
+		g.send(&message{
+			id:      id,
+			key:     key,
+			headers: headers,
+			topic:   topic,
+			payload: payload,
+			group:   g,
+		})
+	}
+}
+
+// Subscribe subscribes to topic, subscriptions with the same mq.Queue compete for messages
+func (d *memoryDriver) Subscribe(topic string, opts ...mq.SubOpt) (<-chan mq.Message, error) {
+	var opt = &mq.SubOption{}
+	for _, o := range opts {
+		o(opt)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil, ErrClosed
+	}
+
+	var name = opt.Queue
+	if name == "" {
+		name = "$" + watermill.NewShortUUID()
+	}
+
+	groups, ok := d.groups[topic]
+	if !ok {
+		groups = make(map[string]*group)
+		d.groups[topic] = groups
+	}
+
+	g, ok := groups[name]
+	if !ok {
+		g = newGroup(d.buffer)
+		groups[name] = g
+	}
+	g.refs++
+
+	if opt.Context != nil {
+		go func() {
+			select {
+			case <-opt.Context.Done():
+				d.unsubscribe(topic, name, g)
+			case <-g.done:
+			}
+		}()
+	}
+
+	return g.ch, nil
+}
+
+// unsubscribe releases a subscription of g, the last one closes the group
+func (d *memoryDriver) unsubscribe(topic, name string, g *group) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed || d.groups[topic][name] != g {
+		return
+	}
+
+	if g.refs--; g.refs > 0 {
+		return
+	}
+
+	delete(d.groups[topic], name)
+	if len(d.groups[topic]) == 0 {
+		delete(d.groups, topic)
+	}
+	g.close()
+}
+
+// Close closes all subscription channels
+func (d *memoryDriver) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+
+	for _, groups := range d.groups {
+		for _, g := range groups {
+			g.close()
+		}
+	}
+	d.groups = nil
+	return nil
+}
+
+// send delivers msg unless the group is closed, a blocked send is released by close
+func (g *group) send(msg *message) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if g.closed {
+		return
+	}
+
+	select {
+	case g.ch <- msg:
+	case <-g.done:
+	}
+}
+
+// close releases the blocked senders, then closes the channel once no send is in progress
+func (g *group) close() {
+	close(g.done)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.closed = true
+	close(g.ch)
+}
+
+type message struct {
+	id      string
+	key     string
+	headers map[string]string
+	topic   string
+	payload []byte
+	group   *group
+
+	once sync.Once
+}
+
+func (m *message) ID() string {
+	return m.id
+}
+
+func (m *message) Key() string {
+	return m.key
+}
+
+func (m *message) Headers() map[string]string {
+	return m.headers
+}
+
+func (m *message) Payload() []byte {
+	return m.payload
+}
+
+func (m *message) Ack() bool {
+	var ok bool
+	m.once.Do(func() { ok = true })
+	return ok
+}
+
+// Nack redelivers the message to its group
+func (m *message) Nack() bool {
+	var ok bool
+	m.once.Do(func() {
+		ok = true
+		go m.group.send(&message{
+			id:      m.id,
+			key:     m.key,
+			headers: m.headers,
+			topic:   m.topic,
+			payload: m.payload,
+			group:   m.group,
+		})
+	})
+	return ok
+}
+
+func init() {
+	mq.Register("memory", func(c mq.Config) mq.Driver {
+		var cfg = DefaultConfig
+		if err := mapstructure.Decode(c, &cfg); err != nil {
+			panic(err)
+		}
+
+		return Open(cfg)
+	})
+}
@@ -0,0 +1,125 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hysios/x/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+func recv(t *testing.T, ch <-chan mq.Message) mq.Message {
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("receive timeout")
+	}
+	return nil
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	d, err := mq.Open("memory", mq.Config{"buffer": 4})
+	assert.NoError(t, err)
+
+	a, _ := d.Subscribe("user.created")
+	b, _ := d.Subscribe("user.created")
+
+	assert.NoError(t, d.Publish("user.created", []byte("hello")))
+	assert.Equal(t, "hello", string(recv(t, a).Payload()))
+	assert.Equal(t, "hello", string(recv(t, b).Payload()))
+}
+
+func TestQueueGroup(t *testing.T) {
+	var d = Open(DefaultConfig)
+
+	a, _ := d.Subscribe("user.created", mq.Queue("workers"))
+	b, _ := d.Subscribe("user.created", mq.Queue("workers"))
+	assert.Equal(t, a, b)
+
+	assert.NoError(t, d.Publish("user.created", []byte("hello")))
+	msg := recv(t, a)
+	assert.True(t, msg.Ack())
+	assert.False(t, msg.Ack())
+}
+
+func TestNackRedelivers(t *testing.T) {
+	var d = Open(DefaultConfig)
+
+	ch, _ := d.Subscribe("user.created")
+	assert.NoError(t, d.Publish("user.created", []byte("hello")))
+
+	assert.True(t, mq.Nack(recv(t, ch)))
+	assert.Equal(t, "hello", string(recv(t, ch).Payload()))
+
+	assert.NoError(t, d.Close())
+	assert.ErrorIs(t, d.Publish("user.created", nil), ErrClosed)
+}
+
+func TestDeliverAt(t *testing.T) {
+	var d = Open(DefaultConfig)
+
+	ch, _ := d.Subscribe("user.created")
+	var start = time.Now()
+	assert.NoError(t, d.Publish("user.created", []byte("later"), mq.Delay(30*time.Millisecond)))
+
+	assert.Equal(t, "later", string(recv(t, ch).Payload()))
+	assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+}
+
+func TestCloseReleasesBlockedSend(t *testing.T) {
+	var d = Open(Config{Buffer: 1})
+
+	ch, _ := d.Subscribe("user.created")
+	assert.NoError(t, d.Publish("user.created", []byte("1")))
+
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		// the buffer is full, the publish blocks until Close
+		d.Publish("user.created", []byte("2"))
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, d.Close())
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("blocked publish not released")
+	}
+
+	<-ch
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestUntil(t *testing.T) {
+	var (
+		d           = Open(DefaultConfig)
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	defer d.Close()
+
+	a, _ := d.Subscribe("user.created", mq.Queue("mail"), mq.Until(ctx))
+	b, _ := d.Subscribe("user.created", mq.Queue("mail"))
+
+	// the group lives until its last subscription ends
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, d.Publish("user.created", []byte("1")))
+	assert.Equal(t, "1", string(recv(t, b).Payload()))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	c, _ := d.Subscribe("user.created", mq.Until(ctx))
+	cancel()
+
+	select {
+	case _, ok := <-c:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("subscription not closed")
+	}
+	assert.Equal(t, a, b)
+}
@@ -0,0 +1,393 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"go.uber.org/zap"
+)
+
+type (
+	// PublishFunc publishes a message, it's what the publish middlewares wrap
+	PublishFunc func(ctx context.Context, topic string, payload []byte, opts ...PubOpt) error
+
+	PublishMiddleware func(next PublishFunc) PublishFunc
+	ConsumeMiddleware func(next HandlerFunc) HandlerFunc
+)
+
+// Middleware is applied by Wrap, it's a PublishMiddleware, a ConsumeMiddleware
+// or one of the built-ins that wrap both sides
+type Middleware interface {
+	apply(w *wrappedDriver)
+}
+
+func (m PublishMiddleware) apply(w *wrappedDriver) {
+	w.pubs = append(w.pubs, m)
+}
+
+func (m ConsumeMiddleware) apply(w *wrappedDriver) {
+	w.consumes = append(w.consumes, m)
+}
+
+// both is a middleware wrapping publish and consume
+type both struct {
+	pub     PublishMiddleware
+	consume ConsumeMiddleware
+}
+
+func (m both) apply(w *wrappedDriver) {
+	m.pub.apply(w)
+	m.consume.apply(w)
+}
+
+// Chain combines middlewares into one, the first one is the outermost
+func Chain(mws ...Middleware) Middleware {
+	return chain(mws)
+}
+
+type chain []Middleware
+
+func (c chain) apply(w *wrappedDriver) {
+	for _, mw := range c {
+		mw.apply(w)
+	}
+}
+
+// HandlerWrapper is implemented by subscribers that decorate the handlers of Handle
+type HandlerWrapper interface {
+	WrapHandler(handler HandlerFunc) HandlerFunc
+}
+
+type wrappedDriver struct {
+	Driver
+
+	pubs     []PublishMiddleware
+	consumes []ConsumeMiddleware
+	publish  PublishFunc
+}
+
+// Wrap decorates driver with middlewares, the first one is the outermost. Publish
+// middlewares read the context given with WithContext. Consume middlewares apply
+// to the handlers run by Handle and TypedSubscriber, not to the raw channel
+// returned by Subscribe.
+func Wrap(driver Driver, mws ...Middleware) Driver {
+	var w = &wrappedDriver{Driver: driver}
+	for _, mw := range mws {
+		mw.apply(w)
+	}
+
+	w.publish = func(ctx context.Context, topic string, payload []byte, opts ...PubOpt) error {
+		return driver.Publish(topic, payload, append(opts[:len(opts):len(opts)], WithContext(ctx))...)
+	}
+	for i := len(w.pubs) - 1; i >= 0; i-- {
+		w.publish = w.pubs[i](w.publish)
+	}
+
+	return w
+}
+
+// Publish runs the publish middlewares
+func (w *wrappedDriver) Publish(topic string, payload []byte, opts ...PubOpt) error {
+	var opt = &PubOption{}
+	for _, o := range opts {
+		o(opt)
+	}
+
+	var ctx = opt.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return w.publish(ctx, topic, payload, opts...)
+}
+
+// WrapHandler runs the consume middlewares around handler
+func (w *wrappedDriver) WrapHandler(handler HandlerFunc) HandlerFunc {
+	if inner, ok := w.Driver.(HandlerWrapper); ok {
+		handler = inner.WrapHandler(handler)
+	}
+
+	for i := len(w.consumes) - 1; i >= 0; i-- {
+		handler = w.consumes[i](handler)
+	}
+	return handler
+}
+
+// Unwrap returns the decorated driver
+func (w *wrappedDriver) Unwrap() Driver {
+	return w.Driver
+}
+
+// wrapHandler applies the consume middlewares of sub, if any
+func wrapHandler(sub Subscriber, topic string, handler HandlerFunc) HandlerFunc {
+	if w, ok := sub.(HandlerWrapper); ok {
+		handler = w.WrapHandler(handler)
+	}
+
+	return func(ctx context.Context, msg Message) error {
+		ctx = messageContext(ctx, msg)
+		return handler(context.WithValue(ctx, topicKey{}, topic), msg)
+	}
+}
+
+type topicKey struct{}
+
+// TopicFromContext returns the topic of the message being handled
+func TopicFromContext(ctx context.Context) string {
+	topic, _ := ctx.Value(topicKey{}).(string)
+	return topic
+}
+
+// Logging logs every publish and every handled message
+func Logging(log *zap.Logger) Middleware {
+	return both{
+		pub: func(next PublishFunc) PublishFunc {
+			return func(ctx context.Context, topic string, payload []byte, opts ...PubOpt) error {
+				var start = time.Now()
+				err := next(ctx, topic, payload, opts...)
+				if err != nil {
+					log.Warn("mq publish error", zap.String("topic", topic), zap.Error(err))
+				} else {
+					log.Debug("mq publish", zap.String("topic", topic), zap.Int("size", len(payload)), zap.Duration("took", time.Since(start)))
+				}
+				return err
+			}
+		},
+		consume: func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, msg Message) error {
+				var start = time.Now()
+				err := next(ctx, msg)
+
+				var fields = []zap.Field{
+					zap.String("topic", TopicFromContext(ctx)),
+					zap.String("id", IDOf(msg)),
+					zap.Duration("took", time.Since(start)),
+				}
+				if err != nil {
+					log.Warn("mq handle error", append(fields, zap.Error(err))...)
+				} else {
+					log.Debug("mq handle", fields...)
+				}
+				return err
+			}
+		},
+	}
+}
+
+// Retry retries failed publishes and handlers up to maxAttempts times in total,
+// the delay starts at backoff and doubles on every attempt up to maxBackoff
+func Retry(maxAttempts int, backoff, maxBackoff time.Duration) Middleware {
+	var retry = func(ctx context.Context, fn func() error) error {
+		var (
+			err   error
+			delay = backoff
+		)
+
+		for attempt := 1; ; attempt++ {
+			if err = fn(); err == nil || attempt >= maxAttempts {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(delay):
+			}
+
+			if delay *= 2; delay > maxBackoff {
+				delay = maxBackoff
+			}
+		}
+	}
+
+	return both{
+		pub: func(next PublishFunc) PublishFunc {
+			return func(ctx context.Context, topic string, payload []byte, opts ...PubOpt) error {
+				return retry(ctx, func() error {
+					return next(ctx, topic, payload, opts...)
+				})
+			}
+		},
+		consume: func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, msg Message) error {
+				return retry(ctx, func() error {
+					return next(ctx, msg)
+				})
+			}
+		},
+	}
+}
+
+// Recoverer turns a handler panic into an error
+func Recoverer() ConsumeMiddleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, msg Message) error {
+			return safeHandle(ctx, next, msg)
+		}
+	}
+}
+
+// Timeout bounds the time of a publish or a handler. The publish and the handler
+// get a context with a deadline and are expected to return when it's done, the
+// drivers read the publish context given with WithContext.
+func Timeout(d time.Duration) Middleware {
+	return both{
+		pub: func(next PublishFunc) PublishFunc {
+			return func(ctx context.Context, topic string, payload []byte, opts ...PubOpt) error {
+				ctx, cancel := context.WithTimeout(ctx, d)
+				defer cancel()
+
+				err := next(ctx, topic, payload, opts...)
+				if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					return fmt.Errorf("mq: publish %s: %w: %w", topic, ctx.Err(), err)
+				}
+				return err
+			}
+		},
+		consume: func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, msg Message) error {
+				ctx, cancel := context.WithTimeout(ctx, d)
+				defer cancel()
+
+				return next(ctx, msg)
+			}
+		},
+	}
+}
+
+// Propagator carries context values across a message in its headers
+type Propagator interface {
+	Inject(ctx context.Context, headers map[string]string)
+	Extract(ctx context.Context, headers map[string]string) context.Context
+}
+
+// Tracing injects the context of a publish into the message headers and
+// extracts it back into the context of the handler
+func Tracing(p Propagator) Middleware {
+	return both{
+		pub: func(next PublishFunc) PublishFunc {
+			return func(ctx context.Context, topic string, payload []byte, opts ...PubOpt) error {
+				var headers = make(map[string]string)
+				p.Inject(ctx, headers)
+
+				return next(ctx, topic, payload, append(opts[:len(opts):len(opts)], Headers(headers))...)
+			}
+		},
+		consume: func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, msg Message) error {
+				if headers := HeadersOf(msg); len(headers) > 0 {
+					ctx = p.Extract(ctx, headers)
+				}
+				return next(ctx, msg)
+			}
+		},
+	}
+}
+
+// CorrelationHeader is the header of the correlation id, the same as watermill's
+const CorrelationHeader = "correlation_id"
+
+type correlationKey struct{}
+
+// WithCorrelationID
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationIDFromContext
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// CorrelationID propagates the correlation id, a new one is generated when the
+// publishing context has none
+var CorrelationID Propagator = correlationPropagator{}
+
+type correlationPropagator struct{}
+
+func (correlationPropagator) Inject(ctx context.Context, headers map[string]string) {
+	var id = CorrelationIDFromContext(ctx)
+	if id == "" {
+		id = watermill.NewShortUUID()
+	}
+	headers[CorrelationHeader] = id
+}
+
+func (correlationPropagator) Extract(ctx context.Context, headers map[string]string) context.Context {
+	if id := headers[CorrelationHeader]; id != "" {
+		return WithCorrelationID(ctx, id)
+	}
+	return ctx
+}
+
+// ErrInvalidPayload is returned by publishes rejected by Validate
+var ErrInvalidPayload = errors.New("mq: invalid payload")
+
+type ValidateOption struct {
+	// DeadLetter receives the invalid messages
+	DeadLetter DeadLetterFunc
+	// Logger logs the invalid messages dropped without DeadLetter
+	Logger *zap.Logger
+}
+
+type ValidateOpt func(*ValidateOption)
+
+var DefaultValidateOption = ValidateOption{
+	Logger: zap.NewNop(),
+}
+
+// WithDeadLetter sends the invalid messages to dl
+func WithDeadLetter(dl DeadLetterFunc) ValidateOpt {
+	return func(o *ValidateOption) {
+		o.DeadLetter = dl
+	}
+}
+
+// WithValidateLogger
+func WithValidateLogger(log *zap.Logger) ValidateOpt {
+	return func(o *ValidateOption) {
+		o.Logger = log
+	}
+}
+
+// Validate checks payloads with fn. An invalid publish fails with ErrInvalidPayload,
+// an invalid message is acked without being handled as a redelivery won't fix it.
+// It goes to the dead letter, or is logged when there is none, and is nacked
+// when the dead letter fails.
+//
+//	mq.Validate(check, mq.WithDeadLetter(mq.DeadLetterTo(driver, "orders.invalid")))
+func Validate(fn func(topic string, payload []byte) error, opts ...ValidateOpt) Middleware {
+	var opt = DefaultValidateOption
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return both{
+		pub: func(next PublishFunc) PublishFunc {
+			return func(ctx context.Context, topic string, payload []byte, opts ...PubOpt) error {
+				if err := fn(topic, payload); err != nil {
+					return fmt.Errorf("%w: %s: %v", ErrInvalidPayload, topic, err)
+				}
+				return next(ctx, topic, payload, opts...)
+			}
+		},
+		consume: func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, msg Message) error {
+				var topic = TopicFromContext(ctx)
+				if err := fn(topic, msg.Payload()); err != nil {
+					err = fmt.Errorf("%w: %s: %v", ErrInvalidPayload, topic, err)
+					if opt.DeadLetter == nil {
+						opt.Logger.Warn("mq: drop invalid message", zap.String("topic", topic), zap.String("id", IDOf(msg)), zap.Error(err))
+						return nil
+					}
+					return sendDeadLetter(ctx, opt.DeadLetter, Meta{Topic: topic, Message: msg}, err)
+				}
+				return next(ctx, msg)
+			}
+		},
+	}
+}
@@ -0,0 +1,194 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type failingPublisher struct {
+	Driver
+	fails int
+	calls int
+}
+
+func (f *failingPublisher) Publish(topic string, payload []byte, opts ...PubOpt) error {
+	if f.calls++; f.calls <= f.fails {
+		return errors.New("unavailable")
+	}
+	return f.Driver.Publish(topic, payload, opts...)
+}
+
+func TestWrapOrder(t *testing.T) {
+	var (
+		d     = newFakeDriver()
+		calls []string
+		trace = func(name string) Middleware {
+			return both{
+				pub: func(next PublishFunc) PublishFunc {
+					return func(ctx context.Context, topic string, payload []byte, opts ...PubOpt) error {
+						calls = append(calls, "pub:"+name)
+						return next(ctx, topic, payload, opts...)
+					}
+				},
+				consume: func(next HandlerFunc) HandlerFunc {
+					return func(ctx context.Context, msg Message) error {
+						calls = append(calls, "consume:"+name)
+						return next(ctx, msg)
+					}
+				},
+			}
+		}
+		w           = Wrap(d, trace("a"), trace("b"))
+		ctx, cancel = context.WithCancel(context.Background())
+		done        = make(chan error)
+	)
+
+	assert.NoError(t, w.Publish("orders", []byte("o1")))
+	go func() {
+		done <- Handle(ctx, Bind(w, "orders"), func(ctx context.Context, msg Message) error {
+			assert.Equal(t, "orders", TopicFromContext(ctx))
+			return nil
+		})
+	}()
+
+	assert.True(t, waitAck(t, d.acked))
+	cancel()
+	<-done
+	assert.Equal(t, []string{"pub:a", "pub:b", "consume:a", "consume:b"}, calls)
+}
+
+func TestRetry(t *testing.T) {
+	var (
+		d = &failingPublisher{Driver: newFakeDriver(), fails: 2}
+		w = Wrap(d, Retry(3, time.Millisecond, 2*time.Millisecond))
+	)
+
+	assert.NoError(t, w.Publish("orders", []byte("o1")))
+	assert.Equal(t, 3, d.calls)
+
+	d.calls, d.fails = 0, 5
+	assert.Error(t, w.Publish("orders", []byte("o1")))
+	assert.Equal(t, 3, d.calls)
+
+	var attempts int
+	err := w.(HandlerWrapper).WrapHandler(func(ctx context.Context, msg Message) error {
+		attempts++
+		return errors.New("failed")
+	})(context.Background(), &fakeMessage{})
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestTracing(t *testing.T) {
+	var (
+		d   = newFakeDriver()
+		w   = Wrap(d, Tracing(CorrelationID), Recoverer())
+		ctx = WithCorrelationID(context.Background(), "req-1")
+	)
+
+	assert.NoError(t, w.Publish("orders", []byte("o1"), WithContext(ctx)))
+
+	msg := <-d.ch
+	assert.Equal(t, "req-1", HeadersOf(msg)[CorrelationHeader])
+
+	var got string
+	err := w.(HandlerWrapper).WrapHandler(func(ctx context.Context, msg Message) error {
+		got = CorrelationIDFromContext(ctx)
+		panic("boom")
+	})(context.Background(), msg)
+	assert.Error(t, err)
+	assert.Equal(t, "req-1", got)
+
+	// a correlation id is generated when the context has none
+	assert.NoError(t, w.Publish("orders", []byte("o2")))
+	assert.NotEmpty(t, HeadersOf(<-d.ch)[CorrelationHeader])
+}
+
+func TestTimeoutAndValidate(t *testing.T) {
+	var (
+		dead     []error
+		d        = newFakeDriver()
+		core, ob = observer.New(zap.DebugLevel)
+		w        = Wrap(d,
+			Logging(zap.New(core)),
+			Timeout(10*time.Millisecond),
+			Validate(func(topic string, payload []byte) error {
+				if len(payload) == 0 {
+					return errors.New("empty")
+				}
+				return nil
+			}, WithDeadLetter(func(ctx context.Context, meta Meta, err error) error {
+				dead = append(dead, err)
+				return nil
+			})))
+	)
+
+	assert.ErrorIs(t, w.Publish("orders", nil), ErrInvalidPayload)
+	assert.NoError(t, w.Publish("orders", []byte("o1")))
+	assert.Equal(t, 2, ob.Len())
+
+	var handler = w.(HandlerWrapper).WrapHandler(func(ctx context.Context, msg Message) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.ErrorIs(t, handler(context.Background(), <-d.ch), context.DeadlineExceeded)
+	// invalid messages are dead lettered and acked
+	assert.NoError(t, handler(context.Background(), &fakeMessage{}))
+	assert.Len(t, dead, 1)
+	assert.ErrorIs(t, dead[0], ErrInvalidPayload)
+}
+
+func TestValidateLogger(t *testing.T) {
+	var (
+		core, ob = observer.New(zap.DebugLevel)
+		w        = Wrap(newFakeDriver(), Validate(func(topic string, payload []byte) error {
+			return errors.New("invalid")
+		}, WithValidateLogger(zap.New(core))))
+		handler = w.(HandlerWrapper).WrapHandler(func(ctx context.Context, msg Message) error {
+			t.Error("handler should not be called")
+			return nil
+		})
+	)
+
+	// dropped without a dead letter
+	assert.NoError(t, handler(context.Background(), &fakeMessage{}))
+	assert.Equal(t, 1, ob.FilterMessage("mq: drop invalid message").Len())
+}
+
+// blockingDriver publishes once the publish context is done
+type blockingDriver struct {
+	fakeDriver
+	returned chan struct{}
+}
+
+func (b *blockingDriver) Publish(topic string, payload []byte, opts ...PubOpt) error {
+	var opt PubOption
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	<-opt.Context.Done()
+	close(b.returned)
+	return opt.Context.Err()
+}
+
+func TestTimeoutPublish(t *testing.T) {
+	var (
+		d = &blockingDriver{returned: make(chan struct{})}
+		w = Wrap(d, Timeout(10*time.Millisecond))
+	)
+
+	err := w.Publish("orders", []byte("o1"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	select {
+	case <-d.returned:
+	default:
+		t.Fatal("publish still running")
+	}
+}
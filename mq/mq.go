@@ -1,6 +1,8 @@
 package mq
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/hysios/x/providers"
@@ -24,8 +26,90 @@ type Message interface {
 	Ack() bool
 }
 
+// Nacker is implemented by messages that can be negatively acknowledged and redelivered
+type Nacker interface {
+	Nack() bool
+}
+
+// Identifier is implemented by messages that carry a message id
+type Identifier interface {
+	ID() string
+}
+
+// IDOf returns the id of msg, or an empty string when the driver does not provide one
+func IDOf(msg Message) string {
+	if i, ok := msg.(Identifier); ok {
+		return i.ID()
+	}
+	return ""
+}
+
+// Keyer is implemented by messages that carry a partition key
+type Keyer interface {
+	Key() string
+}
+
+// KeyOf returns the partition key of msg, or an empty string when it has none
+func KeyOf(msg Message) string {
+	if k, ok := msg.(Keyer); ok {
+		return k.Key()
+	}
+	return ""
+}
+
+// Headerer is implemented by messages that carry headers
+type Headerer interface {
+	Headers() map[string]string
+}
+
+// HeadersOf returns the headers of msg, or nil when it has none
+func HeadersOf(msg Message) map[string]string {
+	if h, ok := msg.(Headerer); ok {
+		return h.Headers()
+	}
+	return nil
+}
+
+// Contexter is implemented by messages that carry the context of their delivery,
+// such as the trace extracted from the headers
+type Contexter interface {
+	Context() context.Context
+}
+
+// messageContext returns ctx with the values of the message context, the
+// cancellation of ctx is kept
+func messageContext(ctx context.Context, msg Message) context.Context {
+	if c, ok := msg.(Contexter); ok && c.Context() != nil {
+		return valueContext{Context: ctx, values: c.Context()}
+	}
+	return ctx
+}
+
+type valueContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c valueContext) Value(key interface{}) interface{} {
+	if v := c.values.Value(key); v != nil {
+		return v
+	}
+	return c.Context.Value(key)
+}
+
+// Nack negatively acknowledges msg, it reports false when the driver does not support it
+func Nack(msg Message) bool {
+	if n, ok := msg.(Nacker); ok {
+		return n.Nack()
+	}
+	return false
+}
+
 type Config map[string]interface{}
 
+// ErrDelayUnsupported is returned by drivers without delayed delivery, wrap them with mq/delay
+var ErrDelayUnsupported = errors.New("mq: driver does not support delayed delivery")
+
 var provider providers.Provider[string, providers.Ctor[Config, Driver]]
 
 func Register(name string, ctor providers.Ctor[Config, Driver]) {
@@ -4,21 +4,25 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/hysios/x/mq"
+	"github.com/mitchellh/mapstructure"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/nats-io/stan.go"
 )
 
 type Config struct {
-	URL              string
-	SubscribersCount int
-	QueueGroupPrefix string
-	Stream           bool
-	Subjects         []string
-	CloseTimeout     time.Duration
-	AckWaitTimeout   time.Duration
-	StanOptions      []stan.Option
-	NatsOptions      []nats.Option
+	URL              string        `mapstructure:"url"`
+	SubscribersCount int           `mapstructure:"subscribers_count"`
+	QueueGroupPrefix string        `mapstructure:"queue_group_prefix"`
+	Stream           bool          `mapstructure:"stream"`
+	Subjects         []string      `mapstructure:"subjects"`
+	CloseTimeout     time.Duration `mapstructure:"close_timeout"`
+	AckWaitTimeout   time.Duration `mapstructure:"ack_wait_timeout"`
+	StanOptions      []stan.Option `mapstructure:"-"`
+	NatsOptions      []nats.Option `mapstructure:"-"`
+	// Topology is declared at Open
+	Topology Topology `mapstructure:"topology"`
 }
 
 var (
@@ -42,16 +46,46 @@ func Open(cfg Config) (*natsDriver, error) {
 		return nil, fmt.Errorf("cannot create jetstream: %w", err)
 	}
 
+	var driver = &natsDriver{
+		conn:     conn,
+		js:       js,
+		consumes: make(map[jetstream.ConsumeContext]bool),
+	}
+
+	if err := driver.Declare(cfg.Topology); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
 	if Default == nil {
-		Default = &natsDriver{
-			conn: conn,
-			js:   js,
-		}
+		Default = driver
 		return Default, nil
 	}
 
-	return &natsDriver{
-		conn: conn,
-		js:   js,
-	}, nil
+	return driver, nil
+}
+
+func init() {
+	mq.Register("nats", func(c mq.Config) mq.Driver {
+		var cfg = DefaultConfig
+
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
+			Result:     &cfg,
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		if err := decoder.Decode(c); err != nil {
+			panic(err)
+		}
+
+		driver, err := Open(cfg)
+		if err != nil {
+			panic(err)
+		}
+
+		return driver
+	})
 }
@@ -1,7 +1,10 @@
 package nats
 
+import "github.com/hysios/x/mq"
+
+type SubOpt = mq.SubOpt
+
+// WithSubjects sets the subjects of the stream created for the topic
 func WithSubjects(subjects ...string) SubOpt {
-	return func(cfg *SubOption) {
-		cfg.Subjects = subjects
-	}
+	return mq.Subjects(subjects...)
 }
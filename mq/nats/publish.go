@@ -2,15 +2,32 @@ package nats
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/hysios/x/mq"
+	"github.com/hysios/x/otel"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 )
 
+// System is the messaging system of the spans
+const System = "nats"
+
+var errNacked = errors.New("mq/nats: message nacked")
+
+// DeliverAtHeader carries the delivery time of a delayed message in unix milliseconds
+const DeliverAtHeader = "X-Deliver-At"
+
 type natsDriver struct {
-	conn     *nats.Conn
-	js       jetstream.JetStream
+	conn *nats.Conn
+	js   jetstream.JetStream
+
+	mu       sync.Mutex
 	consumes map[jetstream.ConsumeContext]bool
+	topology Topology
 }
 
 var Default *natsDriver
@@ -20,11 +37,62 @@ func (n *natsDriver) CreateStream(name string, cfg jetstream.StreamConfig) (jets
 	return n.js.CreateStream(context.TODO(), cfg)
 }
 
-func (n *natsDriver) Publish(topic string, payload []byte) error {
-	_, err := n.js.Publish(context.TODO(), topic, payload)
+func (n *natsDriver) Publish(topic string, payload []byte, opts ...mq.PubOpt) (err error) {
+	var opt = &mq.PubOption{}
+	for _, o := range opts {
+		o(opt)
+	}
+
+	var ctx = opt.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ctx, span := otel.StartPublish(ctx, System, topic, otel.MessageID(opt.MessageID))
+	defer func() { otel.End(span, err) }()
+
+	var (
+		msg     = nats.NewMsg(topic)
+		pubOpts []jetstream.PublishOpt
+	)
+	msg.Data = payload
+
+	for k, v := range opt.Headers {
+		msg.Header.Set(k, v)
+	}
+
+	var carrier = make(map[string]string)
+	otel.Inject(ctx, carrier)
+	for k, v := range carrier {
+		msg.Header.Set(k, v)
+	}
+
+	if opt.MessageID != "" {
+		pubOpts = append(pubOpts, jetstream.WithMsgID(opt.MessageID))
+	}
+
+	if opt.Delay() > 0 {
+		msg.Header.Set(DeliverAtHeader, strconv.FormatInt(opt.DeliverAt.UnixMilli(), 10))
+	}
+
+	_, err = n.js.PublishMsg(ctx, msg, pubOpts...)
 	return err
 }
 
-func Publish(topic string, payload []byte) error {
-	return Default.Publish(topic, payload)
+// deliverAt returns the delivery time carried by msg, it's zero when the message isn't delayed
+func deliverAt(msg jetstream.Msg) time.Time {
+	v := msg.Headers().Get(DeliverAtHeader)
+	if v == "" {
+		return time.Time{}
+	}
+
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+func Publish(topic string, payload []byte, opts ...mq.PubOpt) error {
+	return Default.Publish(topic, payload, opts...)
 }
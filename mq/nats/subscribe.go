@@ -2,56 +2,113 @@ package nats
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/hysios/x/mq"
+	"github.com/hysios/x/otel"
 	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type SubOption struct {
-	Subjects []string
-}
-
-type SubOpt func(*SubOption)
-
 type msgWarp struct {
 	jetstream.Msg
+
+	ctx  context.Context
+	span trace.Span
 }
 
 func (m *msgWarp) Payload() []byte {
 	return m.Msg.Data()
 }
 
+// ID returns the Nats-Msg-Id header, or the stream sequence when it's not set
+func (m *msgWarp) ID() string {
+	if id := m.Msg.Headers().Get(jetstream.MsgIDHeader); id != "" {
+		return id
+	}
+
+	meta, err := m.Msg.Metadata()
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", meta.Stream, meta.Sequence.Stream)
+}
+
+// Headers returns the first value of every header
+func (m *msgWarp) Headers() map[string]string {
+	var headers = make(map[string]string)
+	for k, v := range m.Msg.Headers() {
+		if len(v) > 0 {
+			headers[k] = v[0]
+		}
+	}
+	return headers
+}
+
+// Context carries the consumer span of the message
+func (m *msgWarp) Context() context.Context {
+	return m.ctx
+}
+
+// Ack ends the consumer span
 func (m *msgWarp) Ack() bool {
-	return m.Msg.Ack() == nil
+	err := m.Msg.Ack()
+	otel.End(m.span, err)
+	return err == nil
+}
+
+func (m *msgWarp) Nack() bool {
+	err := m.Msg.Nak()
+	otel.End(m.span, errNacked)
+	return err == nil
 }
 
-func (n *natsDriver) Subscribe(topic string, opts ...SubOpt) (<-chan mq.Message, error) {
+// Subscribe subscribes to the stream named topic, mq.Queue names a durable consumer
+// shared by the subscribers of the same queue
+func (n *natsDriver) Subscribe(topic string, opts ...mq.SubOpt) (<-chan mq.Message, error) {
 	var (
-		ch     = make(chan mq.Message)
-		ctx, _ = context.WithTimeout(context.Background(), 10000*time.Hour)
-		opt    = &SubOption{}
+		ctx = context.Background()
+		opt = &mq.SubOption{}
 	)
 
 	for _, o := range opts {
 		o(opt)
 	}
 
+	var (
+		ch   = make(chan mq.Message, opt.Prefetch)
+		done <-chan struct{}
+	)
+	if opt.Context != nil {
+		done = opt.Context.Done()
+	}
+
 	// Create a stream
-	s, err := n.js.Stream(context.TODO(), topic)
+	s, err := n.js.Stream(ctx, topic)
 	if err != nil {
-		s, _ = n.js.CreateStream(ctx, jetstream.StreamConfig{
+		s, err = n.js.CreateStream(ctx, jetstream.StreamConfig{
 			Name:     topic,
 			Subjects: opt.Subjects,
 		})
+		if err != nil {
+			log.Printf("create stream error: %v", err)
+			return nil, err
+		}
 	}
 
-	// Create durable consumer
-	c, err := s.CreateOrUpdateConsumer(context.TODO(), jetstream.ConsumerConfig{
-		// Durable:   "CONS",
-		AckPolicy: jetstream.AckExplicitPolicy,
-	})
+	// Create durable consumer, a consumer declared by the topology is used as is
+	var c jetstream.Consumer
+	if _, ok := n.consumer(topic, opt.Queue); ok {
+		c, err = s.Consumer(ctx, opt.Queue)
+	} else {
+		c, err = s.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+			Durable:       opt.Queue,
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			MaxAckPending: opt.Prefetch,
+		})
+	}
 	if err != nil {
 		log.Printf("create consumer error: %v", err)
 		return nil, err
@@ -59,28 +116,58 @@ func (n *natsDriver) Subscribe(topic string, opts ...SubOpt) (<-chan mq.Message,
 
 	// Receive messages continuously in a callback
 	cons, err := c.Consume(func(msg jetstream.Msg) {
-		ch <- &msgWarp{msg}
+		// delayed messages are redelivered once they are due
+		if wait := time.Until(deliverAt(msg)); wait > 0 {
+			_ = msg.NakWithDelay(wait)
+			return
+		}
+
+		var m = &msgWarp{Msg: msg}
+		m.ctx, m.span = otel.StartConsume(otel.Extract(context.Background(), m.Headers()),
+			System, topic, otel.MessageID(m.ID()))
+		select {
+		case ch <- m:
+		case <-done:
+			// the subscription ended, the message goes back to the stream
+			m.Nack()
+		}
 	})
 	if err != nil {
 		log.Printf("consume error: %v", err)
 		return nil, err
 	}
+
+	n.mu.Lock()
 	n.consumes[cons] = true
+	n.mu.Unlock()
+
+	if opt.Context != nil {
+		go func() {
+			<-opt.Context.Done()
+
+			n.mu.Lock()
+			delete(n.consumes, cons)
+			n.mu.Unlock()
+			cons.Stop()
+		}()
+	}
 
 	return ch, nil
 }
 
 // Close
 func (n *natsDriver) Close() error {
-	n.conn.Close()
-
+	n.mu.Lock()
 	for cons := range n.consumes {
 		cons.Stop()
 	}
+	n.mu.Unlock()
+
+	n.conn.Close()
 	return nil
 }
 
 // Subscribe subscribes to a topic and returns a channel to receive messages.
-func Subscribe(topic string, opts ...SubOpt) (<-chan mq.Message, error) {
+func Subscribe(topic string, opts ...mq.SubOpt) (<-chan mq.Message, error) {
 	return Default.Subscribe(topic, opts...)
 }
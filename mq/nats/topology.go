@@ -0,0 +1,151 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Topology declares the jetstream streams and durable consumers, it's applied at Open.
+// Streams and consumers are created or updated, so declaring twice is harmless.
+type Topology struct {
+	Streams   []Stream   `mapstructure:"streams"`
+	Consumers []Consumer `mapstructure:"consumers"`
+}
+
+type Stream struct {
+	Name     string   `mapstructure:"name"`
+	Subjects []string `mapstructure:"subjects"`
+	// Retention is limits, interest or workqueue
+	Retention string        `mapstructure:"retention"`
+	MaxAge    time.Duration `mapstructure:"max_age"`
+	MaxMsgs   int64         `mapstructure:"max_msgs"`
+	MaxBytes  int64         `mapstructure:"max_bytes"`
+	// Discard is old or new
+	Discard string `mapstructure:"discard"`
+	// Storage is file or memory
+	Storage  string `mapstructure:"storage"`
+	Replicas int    `mapstructure:"replicas"`
+	// Duplicates is the window in which a message id is deduplicated
+	Duplicates time.Duration `mapstructure:"duplicates"`
+}
+
+type Consumer struct {
+	Stream string `mapstructure:"stream"`
+	// Name is the durable name, Subscribe with mq.Queue(Name) uses the declared consumer
+	Name           string   `mapstructure:"name"`
+	FilterSubjects []string `mapstructure:"filter_subjects"`
+	// DeliverPolicy is all, last, new or last_per_subject
+	DeliverPolicy string        `mapstructure:"deliver_policy"`
+	AckWait       time.Duration `mapstructure:"ack_wait"`
+	MaxDeliver    int           `mapstructure:"max_deliver"`
+	MaxAckPending int           `mapstructure:"max_ack_pending"`
+	Replicas      int           `mapstructure:"replicas"`
+}
+
+// Declare applies the topology
+func (n *natsDriver) Declare(topo Topology) error {
+	var ctx = context.Background()
+
+	for _, s := range topo.Streams {
+		cfg, err := s.config()
+		if err != nil {
+			return err
+		}
+
+		if _, err := n.js.CreateOrUpdateStream(ctx, cfg); err != nil {
+			return fmt.Errorf("mq/nats: declare stream %s: %w", s.Name, err)
+		}
+	}
+
+	for _, c := range topo.Consumers {
+		cfg, err := c.config()
+		if err != nil {
+			return err
+		}
+
+		if _, err := n.js.CreateOrUpdateConsumer(ctx, c.Stream, cfg); err != nil {
+			return fmt.Errorf("mq/nats: declare consumer %s: %w", c.Name, err)
+		}
+	}
+
+	n.mu.Lock()
+	n.topology = topo
+	n.mu.Unlock()
+	return nil
+}
+
+// consumer returns the declared consumer name of stream
+func (n *natsDriver) consumer(stream, name string) (Consumer, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, c := range n.topology.Consumers {
+		if name != "" && c.Stream == stream && c.Name == name {
+			return c, true
+		}
+	}
+	return Consumer{}, false
+}
+
+// config
+func (s Stream) config() (jetstream.StreamConfig, error) {
+	var cfg = jetstream.StreamConfig{
+		Name:       s.Name,
+		Subjects:   s.Subjects,
+		MaxAge:     s.MaxAge,
+		MaxMsgs:    s.MaxMsgs,
+		MaxBytes:   s.MaxBytes,
+		Replicas:   s.Replicas,
+		Duplicates: s.Duplicates,
+	}
+
+	if cfg.MaxMsgs == 0 {
+		cfg.MaxMsgs = -1
+	}
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = -1
+	}
+
+	if err := policy(s.Retention, &cfg.Retention); err != nil {
+		return cfg, fmt.Errorf("mq/nats: stream %s retention: %w", s.Name, err)
+	}
+	if err := policy(s.Discard, &cfg.Discard); err != nil {
+		return cfg, fmt.Errorf("mq/nats: stream %s discard: %w", s.Name, err)
+	}
+	if err := policy(s.Storage, &cfg.Storage); err != nil {
+		return cfg, fmt.Errorf("mq/nats: stream %s storage: %w", s.Name, err)
+	}
+
+	return cfg, nil
+}
+
+// config
+func (c Consumer) config() (jetstream.ConsumerConfig, error) {
+	var cfg = jetstream.ConsumerConfig{
+		Durable:        c.Name,
+		AckPolicy:      jetstream.AckExplicitPolicy,
+		AckWait:        c.AckWait,
+		MaxDeliver:     c.MaxDeliver,
+		MaxAckPending:  c.MaxAckPending,
+		FilterSubjects: c.FilterSubjects,
+		Replicas:       c.Replicas,
+	}
+
+	if err := policy(c.DeliverPolicy, &cfg.DeliverPolicy); err != nil {
+		return cfg, fmt.Errorf("mq/nats: consumer %s deliver policy: %w", c.Name, err)
+	}
+
+	return cfg, nil
+}
+
+// policy parses a policy name with the json decoding of jetstream, an empty name keeps the default
+func policy(name string, v json.Unmarshaler) error {
+	if name == "" {
+		return nil
+	}
+	return v.UnmarshalJSON([]byte(`"` + name + `"`))
+}
@@ -0,0 +1,66 @@
+package nats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopologyConfig(t *testing.T) {
+	var cfg = DefaultConfig
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
+		Result:     &cfg,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, decoder.Decode(map[string]interface{}{
+		"topology": map[string]interface{}{
+			"streams": []interface{}{
+				map[string]interface{}{
+					"name":      "orders",
+					"subjects":  []string{"orders.>"},
+					"retention": "workqueue",
+					"max_age":   "24h",
+					"storage":   "memory",
+					"replicas":  3,
+				},
+			},
+			"consumers": []interface{}{
+				map[string]interface{}{
+					"stream":         "orders",
+					"name":           "billing",
+					"deliver_policy": "new",
+					"ack_wait":       "10s",
+				},
+			},
+		},
+	}))
+
+	stream, err := cfg.Topology.Streams[0].config()
+	assert.NoError(t, err)
+	assert.Equal(t, jetstream.WorkQueuePolicy, stream.Retention)
+	assert.Equal(t, jetstream.MemoryStorage, stream.Storage)
+	assert.Equal(t, 24*time.Hour, stream.MaxAge)
+	assert.Equal(t, 3, stream.Replicas)
+	assert.Equal(t, int64(-1), stream.MaxMsgs)
+
+	consumer, err := cfg.Topology.Consumers[0].config()
+	assert.NoError(t, err)
+	assert.Equal(t, "billing", consumer.Durable)
+	assert.Equal(t, jetstream.DeliverNewPolicy, consumer.DeliverPolicy)
+	assert.Equal(t, jetstream.AckExplicitPolicy, consumer.AckPolicy)
+	assert.Equal(t, 10*time.Second, consumer.AckWait)
+
+	_, err = Stream{Name: "orders", Retention: "forever"}.config()
+	assert.Error(t, err)
+
+	var d = &natsDriver{topology: cfg.Topology}
+	_, ok := d.consumer("orders", "billing")
+	assert.True(t, ok)
+	_, ok = d.consumer("orders", "")
+	assert.False(t, ok)
+}
@@ -1,8 +1,18 @@
 package mq
 
+import (
+	"context"
+	"time"
+)
+
 type SubOption struct {
-	Queue   string
-	Consume string
+	Queue    string
+	Consume  string
+	Subjects []string
+	// Prefetch is the max number of unacked messages delivered to the subscription
+	Prefetch int
+	// Context ends the subscription when it's done
+	Context context.Context
 }
 
 type SubOpt func(*SubOption)
@@ -19,9 +29,31 @@ func Consume(name string) SubOpt {
 	}
 }
 
+// Until ends the subscription when ctx is done, the broker takes back the
+// unacked messages and the drivers close the channel when they can
+func Until(ctx context.Context) SubOpt {
+	return func(o *SubOption) {
+		o.Context = ctx
+	}
+}
+
+// Subjects sets extra subjects or binding keys the subscription listens on
+func Subjects(subjects ...string) SubOpt {
+	return func(o *SubOption) {
+		o.Subjects = subjects
+	}
+}
+
 type PubOption struct {
-	ReplyTo string
-	Queue   string
+	ReplyTo   string
+	Queue     string
+	MessageID string
+	DeliverAt time.Time
+	Key       string
+	// Headers are carried along with the payload by the drivers that support them
+	Headers map[string]string
+	// Context is the context of the publish, it's read by the middlewares
+	Context context.Context
 }
 
 type PubOpt func(*PubOption)
@@ -37,3 +69,63 @@ func QueueTo(name string) PubOpt {
 		o.Queue = name
 	}
 }
+
+// MessageID sets the id of the published message, drivers generate one when it's empty
+func MessageID(id string) PubOpt {
+	return func(o *PubOption) {
+		o.MessageID = id
+	}
+}
+
+// Key sets the partition key, messages with the same key keep their order
+func Key(key string) PubOpt {
+	return func(o *PubOption) {
+		o.Key = key
+	}
+}
+
+// Header sets a message header
+func Header(key, value string) PubOpt {
+	return func(o *PubOption) {
+		if o.Headers == nil {
+			o.Headers = make(map[string]string)
+		}
+		o.Headers[key] = value
+	}
+}
+
+// Headers sets several message headers
+func Headers(headers map[string]string) PubOpt {
+	return func(o *PubOption) {
+		for k, v := range headers {
+			Header(k, v)(o)
+		}
+	}
+}
+
+// WithContext sets the context of the publish
+func WithContext(ctx context.Context) PubOpt {
+	return func(o *PubOption) {
+		o.Context = ctx
+	}
+}
+
+// DeliverAt delays the delivery of the message until t, a past t delivers it right away
+func DeliverAt(t time.Time) PubOpt {
+	return func(o *PubOption) {
+		o.DeliverAt = t
+	}
+}
+
+// Delay delays the delivery of the message by d
+func Delay(d time.Duration) PubOpt {
+	return DeliverAt(time.Now().Add(d))
+}
+
+// Delay returns how long the delivery must wait, it's not positive when there is no delay
+func (o *PubOption) Delay() time.Duration {
+	if o.DeliverAt.IsZero() {
+		return 0
+	}
+	return time.Until(o.DeliverAt)
+}
@@ -0,0 +1,169 @@
+package redisstream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/hysios/x/mq"
+	"github.com/mitchellh/mapstructure"
+	"github.com/redis/go-redis/v9"
+)
+
+type Config struct {
+	Addr     string `mapstructure:"addr"`
+	Password string `mapstructure:"password"`
+	DB       int    `mapstructure:"db"`
+	// MaxLen caps the stream length approximately, zero keeps every entry
+	MaxLen int64 `mapstructure:"max_len"`
+	// Count is the max number of entries read per XREADGROUP
+	Count int64 `mapstructure:"count"`
+	// Block is how long a read waits for new entries
+	Block time.Duration `mapstructure:"block"`
+	// ClaimIdle is how long an entry stays pending before another consumer reclaims it
+	ClaimIdle time.Duration `mapstructure:"claim_idle"`
+	// ClaimInterval is how often the pending entries are checked
+	ClaimInterval time.Duration `mapstructure:"claim_interval"`
+	// StartID is where a new consumer group starts, "$" reads only new entries, "0" the whole stream
+	StartID string `mapstructure:"start_id"`
+	Buffer  int    `mapstructure:"buffer"`
+	// Client is used instead of dialing Addr when set
+	Client *redis.Client `mapstructure:"-"`
+}
+
+var DefaultConfig = Config{
+	Addr:          "localhost:6379",
+	MaxLen:        100000,
+	Count:         16,
+	Block:         time.Second,
+	ClaimIdle:     30 * time.Second,
+	ClaimInterval: 10 * time.Second,
+	StartID:       "$",
+	Buffer:        64,
+}
+
+const (
+	fieldPayload = "payload"
+	fieldID      = "id"
+	fieldKey     = "key"
+	// fieldHeader prefixes the header fields
+	fieldHeader = "h:"
+)
+
+type streamDriver struct {
+	cli *redis.Client
+	cfg Config
+
+	mu   sync.Mutex
+	subs map[*subscription]bool
+}
+
+var Default *streamDriver
+
+func Open(cfg Config) (*streamDriver, error) {
+	var cli = cfg.Client
+	if cli == nil {
+		cli = redis.NewClient(&redis.Options{
+			Addr:     cfg.Addr,
+			Password: cfg.Password,
+			DB:       cfg.DB,
+		})
+	}
+
+	if err := cli.Ping(context.Background()).Err(); err != nil {
+		return nil, err
+	}
+
+	var driver = &streamDriver{
+		cli:  cli,
+		cfg:  cfg,
+		subs: make(map[*subscription]bool),
+	}
+
+	if Default == nil {
+		Default = driver
+		return Default, nil
+	}
+
+	return driver, nil
+}
+
+// Publish appends the message to the stream named topic with XADD
+func (d *streamDriver) Publish(topic string, payload []byte, opts ...mq.PubOpt) error {
+	var opt = &mq.PubOption{}
+	for _, o := range opts {
+		o(opt)
+	}
+
+	if opt.Delay() > 0 {
+		return mq.ErrDelayUnsupported
+	}
+
+	var id = opt.MessageID
+	if id == "" {
+		id = watermill.NewUUID()
+	}
+
+	var values = []interface{}{fieldPayload, payload, fieldID, id}
+	if opt.Key != "" {
+		values = append(values, fieldKey, opt.Key)
+	}
+	for k, v := range opt.Headers {
+		values = append(values, fieldHeader+k, v)
+	}
+
+	var ctx = opt.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return d.cli.XAdd(ctx, &redis.XAddArgs{
+		Stream: topic,
+		MaxLen: d.cfg.MaxLen,
+		Approx: d.cfg.MaxLen > 0,
+		Values: values,
+	}).Err()
+}
+
+// Close stops the subscriptions
+func (d *streamDriver) Close() error {
+	d.mu.Lock()
+	var subs = d.subs
+	d.subs = make(map[*subscription]bool)
+	d.mu.Unlock()
+
+	for sub := range subs {
+		sub.close()
+	}
+
+	if d.cfg.Client == nil {
+		return d.cli.Close()
+	}
+	return nil
+}
+
+func init() {
+	mq.Register("redis", func(c mq.Config) mq.Driver {
+		var cfg = DefaultConfig
+
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
+			Result:     &cfg,
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		if err := decoder.Decode(c); err != nil {
+			panic(err)
+		}
+
+		driver, err := Open(cfg)
+		if err != nil {
+			panic(err)
+		}
+
+		return driver
+	})
+}
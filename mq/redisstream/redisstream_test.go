@@ -0,0 +1,140 @@
+package redisstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hysios/x/mq"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func testDriver(t *testing.T) (*streamDriver, *miniredis.Miniredis) {
+	var mr = miniredis.RunT(t)
+
+	var cfg = DefaultConfig
+	cfg.Client = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	cfg.Block = 20 * time.Millisecond
+	cfg.ClaimIdle = 50 * time.Millisecond
+	cfg.ClaimInterval = 20 * time.Millisecond
+	cfg.MaxLen = 10
+
+	d, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("open driver: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d, mr
+}
+
+func recv(t *testing.T, ch <-chan mq.Message) mq.Message {
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("receive timeout")
+	}
+	return nil
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	d, mr := testDriver(t)
+
+	ch, err := d.Subscribe("orders", mq.Queue("billing"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, d.Publish("orders", []byte("o1"), mq.MessageID("m1"), mq.Key("user-1"), mq.Header("trace", "t1")))
+
+	msg := recv(t, ch)
+	assert.Equal(t, "o1", string(msg.Payload()))
+	assert.Equal(t, "m1", mq.IDOf(msg))
+	assert.Equal(t, "user-1", mq.KeyOf(msg))
+	assert.Equal(t, map[string]string{"trace": "t1"}, mq.HeadersOf(msg))
+	assert.True(t, msg.Ack())
+	assert.False(t, msg.Ack())
+
+	pending, err := d.cli.XPending(context.Background(), "orders", "billing").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), pending.Count)
+	assert.True(t, mr.Exists("orders"))
+}
+
+func TestReclaimPending(t *testing.T) {
+	d, _ := testDriver(t)
+
+	ch, err := d.Subscribe("orders", mq.Queue("billing"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, d.Publish("orders", []byte("o1")))
+
+	// the entry waits in the channel longer than ClaimIdle, XAUTOCLAIM would
+	// deliver it a second time, the in-flight entries of a consumer are skipped
+	time.Sleep(120 * time.Millisecond)
+	msg := recv(t, ch)
+	select {
+	case dup := <-ch:
+		t.Fatalf("delivered twice: %s", dup.Payload())
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// it was delivered once and never claimed
+	pending, err := d.cli.XPendingExt(context.Background(), &redis.XPendingExtArgs{Stream: "orders", Group: "billing", Start: "-", End: "+", Count: 10}).Result()
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, int64(1), pending[0].RetryCount)
+
+	assert.True(t, mq.Nack(msg))
+	msg = recv(t, ch)
+	assert.Equal(t, "o1", string(msg.Payload()))
+
+	// another consumer takes over the entry left pending
+	other, err := d.Subscribe("orders", mq.Queue("billing"), mq.Consume("other"))
+	assert.NoError(t, err)
+
+	msg = recv(t, other)
+	assert.Equal(t, "o1", string(msg.Payload()))
+	assert.True(t, msg.Ack())
+}
+
+func TestFanout(t *testing.T) {
+	d, _ := testDriver(t)
+
+	a, _ := d.Subscribe("orders")
+	b, _ := d.Subscribe("orders", mq.Queue("billing"))
+
+	assert.NoError(t, d.Publish("orders", []byte("o1")))
+	assert.Equal(t, "o1", string(recv(t, a).Payload()))
+	assert.Equal(t, "o1", string(recv(t, b).Payload()))
+}
+
+func TestMaxLen(t *testing.T) {
+	d, _ := testDriver(t)
+
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, d.Publish("orders", []byte("x")))
+	}
+
+	n, err := d.cli.XLen(context.Background(), "orders").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), n)
+	assert.ErrorIs(t, d.Publish("orders", nil, mq.Delay(time.Minute)), mq.ErrDelayUnsupported)
+}
+
+func TestUntil(t *testing.T) {
+	d, _ := testDriver(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := d.Subscribe("orders", mq.Queue("billing"), mq.Until(ctx))
+	assert.NoError(t, err)
+	cancel()
+
+	select {
+	case _, ok := <-ch:
+		assert.False(t, ok)
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription not closed")
+	}
+	assert.Empty(t, d.subs)
+}
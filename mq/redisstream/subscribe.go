@@ -0,0 +1,405 @@
+package redisstream
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/hysios/x/mq"
+	"github.com/redis/go-redis/v9"
+)
+
+type subscription struct {
+	d        *streamDriver
+	stream   string
+	group    string
+	consumer string
+	count    int64
+
+	ch     chan mq.Message
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu sync.Mutex
+	// inflight are the entries delivered to this subscription and not acked yet,
+	// reclaim skips them while they wait in the channel
+	inflight map[string]bool
+	closing  bool
+	ctx      context.Context
+}
+
+// Subscribe reads the stream named topic. With mq.Queue the subscription joins
+// that consumer group, entries left pending longer than ClaimIdle are reclaimed
+// from the other consumers with XPENDING and XCLAIM, see reclaim. Without a
+// queue every entry is delivered and acks are no-op.
+func (d *streamDriver) Subscribe(topic string, opts ...mq.SubOpt) (<-chan mq.Message, error) {
+	var opt = &mq.SubOption{}
+	for _, o := range opts {
+		o(opt)
+	}
+
+	var sub = &subscription{
+		d:        d,
+		stream:   topic,
+		group:    opt.Queue,
+		consumer: opt.Consume,
+		count:    d.cfg.Count,
+		ch:       make(chan mq.Message, d.cfg.Buffer),
+		inflight: make(map[string]bool),
+	}
+
+	if opt.Prefetch > 0 {
+		sub.count = int64(opt.Prefetch)
+	}
+
+	if sub.consumer == "" {
+		sub.consumer = watermill.NewShortUUID()
+	}
+
+	if sub.group != "" {
+		err := d.cli.XGroupCreateMkStream(context.Background(), topic, sub.group, d.cfg.StartID).Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub.ctx, sub.cancel = ctx, cancel
+
+	if sub.group != "" {
+		sub.wg.Add(2)
+		go sub.readGroup(ctx)
+		go sub.reclaim(ctx)
+	} else {
+		last, err := sub.lastID(ctx)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		sub.wg.Add(1)
+		go sub.read(ctx, last)
+	}
+
+	d.mu.Lock()
+	d.subs[sub] = true
+	d.mu.Unlock()
+
+	if opt.Context != nil {
+		go func() {
+			select {
+			case <-opt.Context.Done():
+				d.unsubscribe(sub)
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	return sub.ch, nil
+}
+
+// unsubscribe closes sub unless the driver already did
+func (d *streamDriver) unsubscribe(sub *subscription) {
+	d.mu.Lock()
+	var ok = d.subs[sub]
+	delete(d.subs, sub)
+	d.mu.Unlock()
+
+	if ok {
+		sub.close()
+	}
+}
+
+// lastID returns the id of the newest entry, reading after it only yields new entries
+func (s *subscription) lastID(ctx context.Context) (string, error) {
+	entries, err := s.d.cli.XRevRangeN(ctx, s.stream, "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+
+	if len(entries) == 0 {
+		return "0-0", nil
+	}
+	return entries[0].ID, nil
+}
+
+// read
+func (s *subscription) read(ctx context.Context, last string) {
+	defer s.wg.Done()
+
+	for ctx.Err() == nil {
+		streams, err := s.d.cli.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{s.stream, last},
+			Count:   s.count,
+			Block:   s.d.cfg.Block,
+		}).Result()
+		if err != nil {
+			s.wait(ctx, err)
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, entry := range stream.Messages {
+				last = entry.ID
+				s.deliver(ctx, entry)
+			}
+		}
+	}
+}
+
+// readGroup
+func (s *subscription) readGroup(ctx context.Context) {
+	defer s.wg.Done()
+
+	for ctx.Err() == nil {
+		streams, err := s.d.cli.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.group,
+			Consumer: s.consumer,
+			Streams:  []string{s.stream, ">"},
+			Count:    s.count,
+			Block:    s.d.cfg.Block,
+		}).Result()
+		if err != nil {
+			s.wait(ctx, err)
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, entry := range stream.Messages {
+				s.deliver(ctx, entry)
+			}
+		}
+	}
+}
+
+// reclaim takes over the entries left pending for ClaimIdle by the other
+// consumers of the group. The entries of this consumer still waiting in the
+// channel are left alone, claiming them would deliver them twice. XAUTOCLAIM
+// can't skip them: it claims every idle entry of the range, the ones of the
+// caller included, so the pending entries are listed with XPENDING and the
+// others are claimed with XCLAIM.
+func (s *subscription) reclaim(ctx context.Context) {
+	defer s.wg.Done()
+
+	var ticker = time.NewTicker(s.d.cfg.ClaimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var start = "-"
+		for ctx.Err() == nil {
+			pending, err := s.d.cli.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream: s.stream,
+				Group:  s.group,
+				Idle:   s.d.cfg.ClaimIdle,
+				Start:  start,
+				End:    "+",
+				Count:  s.count,
+			}).Result()
+			if err != nil || len(pending) == 0 {
+				break
+			}
+
+			var ids []string
+			for _, p := range pending {
+				if p.Consumer != s.consumer || !s.isInflight(p.ID) {
+					ids = append(ids, p.ID)
+				}
+			}
+
+			if len(ids) > 0 {
+				entries, err := s.d.cli.XClaim(ctx, &redis.XClaimArgs{
+					Stream:   s.stream,
+					Group:    s.group,
+					Consumer: s.consumer,
+					MinIdle:  s.d.cfg.ClaimIdle,
+					Messages: ids,
+				}).Result()
+				if err != nil {
+					break
+				}
+
+				for _, entry := range entries {
+					s.deliver(ctx, entry)
+				}
+			}
+
+			if int64(len(pending)) < s.count {
+				break
+			}
+			start = "(" + pending[len(pending)-1].ID
+		}
+	}
+}
+
+// wait backs off after a read error, redis.Nil only means the block timed out
+func (s *subscription) wait(ctx context.Context, err error) {
+	if errors.Is(err, redis.Nil) {
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(s.d.cfg.Block):
+	}
+}
+
+// deliver
+func (s *subscription) deliver(ctx context.Context, entry redis.XMessage) {
+	var msg = &message{
+		sub:     s,
+		entryID: entry.ID,
+	}
+
+	if v, ok := entry.Values[fieldPayload].(string); ok {
+		msg.payload = []byte(v)
+	}
+	if v, ok := entry.Values[fieldID].(string); ok {
+		msg.id = v
+	}
+	if v, ok := entry.Values[fieldKey].(string); ok {
+		msg.key = v
+	}
+	for k, v := range entry.Values {
+		if s, ok := v.(string); ok && strings.HasPrefix(k, fieldHeader) {
+			if msg.headers == nil {
+				msg.headers = make(map[string]string)
+			}
+			msg.headers[strings.TrimPrefix(k, fieldHeader)] = s
+		}
+	}
+
+	s.mu.Lock()
+	s.inflight[entry.ID] = true
+	s.mu.Unlock()
+
+	s.send(ctx, msg)
+}
+
+func (s *subscription) send(ctx context.Context, msg *message) {
+	select {
+	case s.ch <- msg:
+	case <-ctx.Done():
+		s.done(msg.entryID)
+	}
+}
+
+func (s *subscription) isInflight(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.inflight[id]
+}
+
+// done forgets the entry, reclaim may deliver it again
+func (s *subscription) done(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.inflight, id)
+}
+
+// resend delivers a nacked message again, the entry stays pending meanwhile
+func (s *subscription) resend(msg *message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		delete(s.inflight, msg.entryID)
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.send(s.ctx, msg)
+	}()
+}
+
+// close
+func (s *subscription) close() {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+
+	s.cancel()
+	s.wg.Wait()
+	close(s.ch)
+}
+
+type message struct {
+	sub     *subscription
+	entryID string
+	once    sync.Once
+	id      string
+	key     string
+	headers map[string]string
+	payload []byte
+}
+
+func (m *message) Payload() []byte {
+	return m.payload
+}
+
+// ID returns the published message id, or the stream entry id when it's not set
+func (m *message) ID() string {
+	if m.id != "" {
+		return m.id
+	}
+	return m.entryID
+}
+
+func (m *message) Key() string {
+	return m.key
+}
+
+func (m *message) Headers() map[string]string {
+	return m.headers
+}
+
+// Ack acknowledges the entry with XACK
+func (m *message) Ack() bool {
+	var ok bool
+	m.once.Do(func() {
+		defer m.sub.done(m.entryID)
+
+		if m.sub.group == "" {
+			ok = true
+			return
+		}
+
+		n, err := m.sub.d.cli.XAck(context.Background(), m.sub.stream, m.sub.group, m.entryID).Result()
+		ok = err == nil && n > 0
+	})
+	return ok
+}
+
+// Nack delivers the entry again to the subscription, it stays pending in the group
+// and is reclaimed by another consumer when this one goes away
+func (m *message) Nack() bool {
+	if m.sub.group == "" {
+		return false
+	}
+
+	var ok bool
+	m.once.Do(func() {
+		ok = true
+		m.sub.resend(&message{
+			sub:     m.sub,
+			entryID: m.entryID,
+			id:      m.id,
+			key:     m.key,
+			headers: m.headers,
+			payload: m.payload,
+		})
+	})
+	return ok
+}
@@ -0,0 +1,195 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+)
+
+// Topic binds a topic name to the payload type carried on it
+type Topic[T any] struct {
+	Name  string
+	Codec Codec
+}
+
+// Meta carries the delivery information of a decoded message
+type Meta struct {
+	Topic   string
+	Message Message
+}
+
+type (
+	TypedHandler[T any] func(ctx context.Context, val T, meta Meta) error
+	// DeadLetterFunc receives messages whose payload cannot be decoded, the
+	// message is nacked when it returns an error
+	DeadLetterFunc func(ctx context.Context, meta Meta, err error) error
+)
+
+// NewTopic creates a topic, the codec defaults to DefaultCodec
+func NewTopic[T any](name string, codec ...Codec) Topic[T] {
+	var c = DefaultCodec
+	if len(codec) > 0 && codec[0] != nil {
+		c = codec[0]
+	}
+
+	return Topic[T]{Name: name, Codec: c}
+}
+
+// Encode
+func (t Topic[T]) Encode(val T) ([]byte, error) {
+	return t.codec().Marshal(val)
+}
+
+// Decode
+func (t Topic[T]) Decode(data []byte) (val T, err error) {
+	err = t.codec().Unmarshal(data, &val)
+	return
+}
+
+// Publisher returns a typed publisher of the topic
+func (t Topic[T]) Publisher(pub Publisher, opts ...PubOpt) *TypedPublisher[T] {
+	return NewTypedPublisher(pub, t, opts...)
+}
+
+// Subscriber returns a typed subscriber of the topic
+func (t Topic[T]) Subscriber(sub Subscriber, opts ...SubOpt) *TypedSubscriber[T] {
+	return NewTypedSubscriber(sub, t, opts...)
+}
+
+func (t Topic[T]) codec() Codec {
+	if t.Codec == nil {
+		return DefaultCodec
+	}
+	return t.Codec
+}
+
+type TypedPublisher[T any] struct {
+	Topic Topic[T]
+
+	pub  Publisher
+	opts []PubOpt
+}
+
+// NewTypedPublisher creates a publisher that encodes values with the topic codec
+func NewTypedPublisher[T any](pub Publisher, topic Topic[T], opts ...PubOpt) *TypedPublisher[T] {
+	return &TypedPublisher[T]{
+		Topic: topic,
+		pub:   pub,
+		opts:  opts,
+	}
+}
+
+// Publish encodes val and publishes it to the topic
+func (p *TypedPublisher[T]) Publish(ctx context.Context, val T, opts ...PubOpt) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := p.Topic.Encode(val)
+	if err != nil {
+		return fmt.Errorf("mq: encode %s: %w", p.Topic.Name, err)
+	}
+
+	return p.pub.Publish(p.Topic.Name, data, append(p.opts[:len(p.opts):len(p.opts)], opts...)...)
+}
+
+type TypedSubscriber[T any] struct {
+	Topic Topic[T]
+	// DeadLetter is called when a payload cannot be decoded, the message is acked afterwards
+	DeadLetter DeadLetterFunc
+
+	sub  Subscriber
+	opts []SubOpt
+}
+
+// NewTypedSubscriber creates a subscriber that decodes payloads with the topic codec
+func NewTypedSubscriber[T any](sub Subscriber, topic Topic[T], opts ...SubOpt) *TypedSubscriber[T] {
+	return &TypedSubscriber[T]{
+		Topic: topic,
+		sub:   sub,
+		opts:  opts,
+	}
+}
+
+// Subscribe subscribes to the topic and calls handler for every message until ctx is done.
+// A nil error from handler acks the message, otherwise it is nacked.
+func (s *TypedSubscriber[T]) Subscribe(ctx context.Context, handler TypedHandler[T]) error {
+	msgs, err := s.sub.Subscribe(s.Topic.Name, s.opts...)
+	if err != nil {
+		return err
+	}
+
+	var fn = wrapHandler(s.sub, s.Topic.Name, s.HandlerFunc(handler))
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+
+				if err := safeHandle(ctx, fn, msg); err != nil {
+					Nack(msg)
+				} else {
+					msg.Ack()
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Handle processes the topic with Handle until ctx is done
+func (s *TypedSubscriber[T]) Handle(ctx context.Context, handler TypedHandler[T], opts ...HandleOpt) error {
+	return Handle(ctx, Bind(s.sub, s.Topic.Name, s.opts...), s.HandlerFunc(handler), opts...)
+}
+
+// HandlerFunc decodes the payload and calls handler. Payloads that can't be decoded
+// go to DeadLetter and are acked, they won't be decoded on redelivery either.
+// They are nacked when DeadLetter fails.
+func (s *TypedSubscriber[T]) HandlerFunc(handler TypedHandler[T]) HandlerFunc {
+	return func(ctx context.Context, msg Message) error {
+		var meta = Meta{Topic: s.Topic.Name, Message: msg}
+
+		val, err := s.Topic.Decode(msg.Payload())
+		if err != nil {
+			if s.DeadLetter != nil {
+				return sendDeadLetter(ctx, s.DeadLetter, meta, err)
+			}
+			return nil
+		}
+
+		return handler(ctx, val, meta)
+	}
+}
+
+const (
+	// OriginalTopicHeader carries the topic a dead letter was consumed from
+	OriginalTopicHeader = "x-original-topic"
+	// OriginalIDHeader carries the id of a replayed message, the replay has a new one
+	OriginalIDHeader = "x-original-id"
+)
+
+// sendDeadLetter sends meta to fn, the error is returned when it fails
+func sendDeadLetter(ctx context.Context, fn DeadLetterFunc, meta Meta, err error) error {
+	if dlErr := fn(ctx, meta, err); dlErr != nil {
+		return fmt.Errorf("mq: dead letter %s: %w", IDOf(meta.Message), dlErr)
+	}
+	return nil
+}
+
+// DeadLetterTo returns a DeadLetterFunc that republishes the raw payload to topic,
+// the original topic is kept in the OriginalTopicHeader header
+func DeadLetterTo(pub Publisher, topic string, opts ...PubOpt) DeadLetterFunc {
+	return func(ctx context.Context, meta Meta, err error) error {
+		var pubOpts = append([]PubOpt{
+			Headers(HeadersOf(meta.Message)),
+			Header(OriginalTopicHeader, meta.Topic),
+			Key(KeyOf(meta.Message)),
+		}, opts...)
+
+		return pub.Publish(topic, meta.Message.Payload(), pubOpts...)
+	}
+}
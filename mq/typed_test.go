@@ -0,0 +1,146 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type fakeMessage struct {
+	payload []byte
+	headers map[string]string
+	acked   chan bool
+}
+
+func (m *fakeMessage) Payload() []byte            { return m.payload }
+func (m *fakeMessage) Headers() map[string]string { return m.headers }
+func (m *fakeMessage) Ack() bool                  { m.acked <- true; return true }
+func (m *fakeMessage) Nack() bool                 { m.acked <- false; return true }
+
+type fakeDriver struct {
+	topic string
+	ch    chan Message
+	acked chan bool
+}
+
+func newFakeDriver() *fakeDriver {
+	return &fakeDriver{ch: make(chan Message, 10), acked: make(chan bool, 10)}
+}
+
+func (f *fakeDriver) Publish(topic string, payload []byte, opts ...PubOpt) error {
+	var opt PubOption
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	f.topic = topic
+	f.ch <- &fakeMessage{payload: payload, headers: opt.Headers, acked: f.acked}
+	return nil
+}
+
+func (f *fakeDriver) Subscribe(topic string, opts ...SubOpt) (<-chan Message, error) {
+	return f.ch, nil
+}
+
+type order struct {
+	Id    int
+	Price float64
+}
+
+func waitAck(t *testing.T, ch chan bool) bool {
+	select {
+	case ok := <-ch:
+		return ok
+	case <-time.After(time.Second):
+		t.Fatal("wait ack timeout")
+	}
+	return false
+}
+
+func TestTypedPubSub(t *testing.T) {
+	var (
+		d     = newFakeDriver()
+		topic = NewTopic[order]("order.created")
+		ctx   = context.Background()
+		got   = make(chan order, 1)
+	)
+
+	err := topic.Subscriber(d).Subscribe(ctx, func(ctx context.Context, val order, meta Meta) error {
+		assert.Equal(t, "order.created", meta.Topic)
+		got <- val
+		return nil
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, topic.Publisher(d).Publish(ctx, order{Id: 1, Price: 9.9}))
+	assert.Equal(t, "order.created", d.topic)
+	assert.Equal(t, order{Id: 1, Price: 9.9}, <-got)
+	assert.True(t, waitAck(t, d.acked))
+}
+
+func TestTypedSubscriberNack(t *testing.T) {
+	var (
+		d     = newFakeDriver()
+		topic = NewTopic[order]("order.created")
+		ctx   = context.Background()
+	)
+
+	err := topic.Subscriber(d).Subscribe(ctx, func(ctx context.Context, val order, meta Meta) error {
+		return errors.New("failed")
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, topic.Publisher(d).Publish(ctx, order{Id: 1}))
+	assert.False(t, waitAck(t, d.acked))
+}
+
+func TestTypedSubscriberDeadLetter(t *testing.T) {
+	var (
+		d     = newFakeDriver()
+		topic = NewTopic[order]("order.created")
+		ctx   = context.Background()
+		dead  = make(chan error, 1)
+	)
+
+	sub := topic.Subscriber(d)
+	sub.DeadLetter = func(ctx context.Context, meta Meta, err error) error {
+		assert.Equal(t, "not json", string(meta.Message.Payload()))
+		dead <- err
+		return nil
+	}
+	err := sub.Subscribe(ctx, func(ctx context.Context, val order, meta Meta) error {
+		t.Error("handler should not be called")
+		return nil
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, d.Publish("order.created", []byte("not json")))
+	assert.Error(t, <-dead)
+	assert.True(t, waitAck(t, d.acked))
+
+	// the message is nacked when it can't be dead lettered
+	sub = topic.Subscriber(d)
+	sub.DeadLetter = DeadLetterTo(&failingPublisher{Driver: d, fails: 1}, "order.dead")
+	err = sub.HandlerFunc(func(ctx context.Context, val order, meta Meta) error {
+		return nil
+	})(ctx, &fakeMessage{payload: []byte("not json")})
+	assert.ErrorContains(t, err, "unavailable")
+}
+
+func TestProtoCodec(t *testing.T) {
+	var topic = NewTopic[*wrapperspb.StringValue]("names", ProtoCodec)
+
+	data, err := topic.Encode(wrapperspb.String("hello"))
+	assert.NoError(t, err)
+
+	val, err := topic.Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", val.GetValue())
+
+	_, err = ProtoCodec.Marshal(order{})
+	assert.Error(t, err)
+}
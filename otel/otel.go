@@ -0,0 +1,119 @@
+package otel
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the instrumentation name of the spans
+const TracerName = "github.com/hysios/x"
+
+// TextMap propagates the W3C traceparent and tracestate, it can be replaced
+// before any message is published
+var TextMap propagation.TextMapPropagator = propagation.TraceContext{}
+
+// Tracer returns the tracer of the global provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// Inject writes the trace context of ctx into headers
+func Inject(ctx context.Context, headers map[string]string) {
+	TextMap.Inject(ctx, propagation.MapCarrier(headers))
+}
+
+// Extract returns ctx with the remote trace context read from headers
+func Extract(ctx context.Context, headers map[string]string) context.Context {
+	return TextMap.Extract(ctx, propagation.MapCarrier(headers))
+}
+
+// InjectArgs writes the trace context of ctx into job arguments
+func InjectArgs(ctx context.Context, args map[string]interface{}) {
+	var headers = make(map[string]string)
+	Inject(ctx, headers)
+
+	for k, v := range headers {
+		args[k] = v
+	}
+}
+
+// ExtractArgs returns ctx with the remote trace context read from job arguments
+func ExtractArgs(ctx context.Context, args map[string]interface{}) context.Context {
+	var headers = make(map[string]string)
+	for _, k := range TextMap.Fields() {
+		if v, ok := args[k].(string); ok {
+			headers[k] = v
+		}
+	}
+
+	return Extract(ctx, headers)
+}
+
+// StartPublish starts a producer span for a message published by system to topic
+func StartPublish(ctx context.Context, system, topic string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(append(attrs,
+			semconv.MessagingSystemKey.String(system),
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingOperationTypePublish,
+		)...),
+	)
+}
+
+// StartConsume starts a consumer span for a message received by system from topic,
+// ctx usually carries the remote trace context returned by Extract
+func StartConsume(ctx context.Context, system, topic string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(append(attrs,
+			semconv.MessagingSystemKey.String(system),
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingOperationTypeDeliver,
+		)...),
+	)
+}
+
+// StartJob starts a span for a run of the job name
+func StartJob(ctx context.Context, name, id string) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, "job "+name,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("job.name", name),
+			attribute.String("job.id", id),
+		),
+	)
+}
+
+// MessageID
+func MessageID(id string) attribute.KeyValue {
+	return semconv.MessagingMessageID(id)
+}
+
+// End records err on span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Propagator is a mq.Propagator for mq.Tracing
+var Propagator propagator
+
+type propagator struct{}
+
+func (propagator) Inject(ctx context.Context, headers map[string]string) {
+	Inject(ctx, headers)
+}
+
+func (propagator) Extract(ctx context.Context, headers map[string]string) context.Context {
+	return Extract(ctx, headers)
+}
@@ -0,0 +1,87 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hysios/x/mq"
+	"github.com/hysios/x/mq/memory"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func recorder(t *testing.T) *tracetest.SpanRecorder {
+	var (
+		rec  = tracetest.NewSpanRecorder()
+		prev = otel.GetTracerProvider()
+	)
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return rec
+}
+
+func TestPropagation(t *testing.T) {
+	var rec = recorder(t)
+
+	ctx, span := StartPublish(context.Background(), "test", "orders")
+	var headers = make(map[string]string)
+	Inject(ctx, headers)
+	End(span, nil)
+	assert.Contains(t, headers, "traceparent")
+
+	var args = map[string]interface{}{"id": 1}
+	InjectArgs(ctx, args)
+	assert.Equal(t, headers["traceparent"], args["traceparent"])
+
+	_, consume := StartConsume(ExtractArgs(context.Background(), args), "test", "orders")
+	End(consume, errors.New("failed"))
+
+	var spans = rec.Ended()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, trace.SpanKindProducer, spans[0].SpanKind())
+	assert.Equal(t, trace.SpanKindConsumer, spans[1].SpanKind())
+	assert.Equal(t, spans[0].SpanContext().TraceID(), spans[1].SpanContext().TraceID())
+	assert.Equal(t, spans[0].SpanContext().SpanID(), spans[1].Parent().SpanID())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+}
+
+func TestMQTracing(t *testing.T) {
+	var (
+		rec         = recorder(t)
+		d           = mq.Wrap(memory.Open(memory.DefaultConfig), mq.Tracing(Propagator))
+		ctx, cancel = context.WithCancel(context.Background())
+		got         = make(chan trace.SpanContext, 1)
+		done        = make(chan error)
+	)
+
+	go func() {
+		done <- mq.Handle(ctx, mq.Bind(d, "orders"), func(ctx context.Context, msg mq.Message) error {
+			got <- trace.SpanContextFromContext(ctx)
+			return nil
+		})
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	pctx, span := Tracer().Start(context.Background(), "request")
+	assert.NoError(t, d.Publish("orders", []byte("o1"), mq.WithContext(pctx)))
+	span.End()
+
+	select {
+	case sc := <-got:
+		assert.Equal(t, span.SpanContext().TraceID(), sc.TraceID())
+		assert.True(t, sc.IsRemote())
+	case <-time.After(time.Second):
+		t.Fatal("receive timeout")
+	}
+
+	cancel()
+	<-done
+	assert.Len(t, rec.Ended(), 1)
+}
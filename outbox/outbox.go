@@ -0,0 +1,112 @@
+package outbox
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hysios/x/cache"
+	"gorm.io/gorm"
+)
+
+// TableName is the table the outbox messages are stored in
+var TableName = "outbox_messages"
+
+// Message is an outgoing message waiting to be relayed
+type Message struct {
+	ID        uint64 `gorm:"primaryKey;autoIncrement"`
+	Aggregate string `gorm:"size:191;index"`
+	Topic     string `gorm:"size:191"`
+	Payload   []byte
+	// Headers are the JSON encoded headers of the message
+	Headers   []byte
+	Attempts  int
+	LastError string
+	NextAt    time.Time
+	CreatedAt time.Time
+	SentAt    *time.Time `gorm:"index"`
+}
+
+func (Message) TableName() string {
+	return TableName
+}
+
+type Option struct {
+	Aggregate string
+	Encoder   cache.Encoder
+	Headers   map[string]string
+}
+
+type Opt func(*Option)
+
+// Aggregate orders the message after the unsent messages of the same aggregate
+func Aggregate(id string) Opt {
+	return func(o *Option) {
+		o.Aggregate = id
+	}
+}
+
+// Header sets the header key of the message, it's relayed by a HeaderPublisher
+func Header(key, value string) Opt {
+	return func(o *Option) {
+		if o.Headers == nil {
+			o.Headers = make(map[string]string)
+		}
+		o.Headers[key] = value
+	}
+}
+
+// WithEncoder
+func WithEncoder(enc cache.Encoder) Opt {
+	return func(o *Option) {
+		o.Encoder = enc
+	}
+}
+
+// Migrate creates the outbox table
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Message{})
+}
+
+// Publish stores a message in the outbox with tx, the Relay sends it once tx commits.
+// A []byte payload is stored as is, anything else is encoded with cache.DefaultEncoder.
+//
+//	db.Transaction(func(tx *gorm.DB) error {
+//		if err := tx.Create(user).Error; err != nil {
+//			return err
+//		}
+//		return outbox.Publish(tx, "user.create", user)
+//	})
+func Publish(tx *gorm.DB, topic string, payload interface{}, opts ...Opt) error {
+	var opt = &Option{
+		Encoder: cache.DefaultEncoder,
+	}
+
+	for _, o := range opts {
+		o(opt)
+	}
+
+	data, ok := payload.([]byte)
+	if !ok {
+		var err error
+		if data, err = opt.Encoder.Marshal(payload); err != nil {
+			return err
+		}
+	}
+
+	var msg = &Message{
+		Aggregate: opt.Aggregate,
+		Topic:     topic,
+		Payload:   data,
+	}
+	if len(opt.Headers) > 0 {
+		headers, err := json.Marshal(opt.Headers)
+		if err != nil {
+			return err
+		}
+		msg.Headers = headers
+	}
+
+	msg.NextAt = time.Now()
+	msg.CreatedAt = msg.NextAt
+	return tx.Create(msg).Error
+}
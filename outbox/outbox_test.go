@@ -0,0 +1,198 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/hysios/x/mq"
+	"github.com/hysios/x/mq/memory"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type User struct {
+	ID   uint
+	Name string
+}
+
+func testDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite memory failed: %v", err)
+	}
+
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+
+	assert.NoError(t, db.AutoMigrate(&User{}))
+	assert.NoError(t, Migrate(db))
+	return db
+}
+
+func createUser(db *gorm.DB, user *User, fail bool) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+
+		if err := Publish(tx, "user.create", user); err != nil {
+			return err
+		}
+
+		if fail {
+			return errors.New("rollback")
+		}
+		return nil
+	})
+}
+
+func TestRelay(t *testing.T) {
+	var (
+		db     = testDB(t)
+		driver = memory.Open(memory.DefaultConfig)
+		ctx    = context.Background()
+	)
+
+	ch, _ := driver.Subscribe("user.create")
+
+	assert.NoError(t, createUser(db, &User{Name: "alice"}, false))
+	assert.Error(t, createUser(db, &User{Name: "bob"}, true))
+
+	relay := NewRelay(db, MQ(driver))
+	sent, err := relay.Flush(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sent)
+
+	msg := <-ch
+	assert.JSONEq(t, `{"ID":1,"Name":"alice"}`, string(msg.Payload()))
+
+	sent, err = relay.Flush(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent)
+}
+
+func TestRelayRetryAndOrdering(t *testing.T) {
+	var (
+		db    = testDB(t)
+		ctx   = context.Background()
+		fail  = true
+		topic []string
+	)
+
+	pub := PublisherFunc(func(t string, payload []byte) error {
+		if fail && string(payload) == "first" {
+			return errors.New("broker down")
+		}
+		topic = append(topic, string(payload))
+		return nil
+	})
+
+	assert.NoError(t, Publish(db, "order", []byte("first"), Aggregate("order-1")))
+	assert.NoError(t, Publish(db, "order", []byte("second"), Aggregate("order-1")))
+	assert.NoError(t, Publish(db, "order", []byte("other"), Aggregate("order-2")))
+
+	relay := NewRelay(db, pub, WithRetry(3, 0, 0))
+	sent, err := relay.Flush(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []string{"other"}, topic)
+
+	var msg Message
+	assert.NoError(t, db.First(&msg, "payload = ?", []byte("first")).Error)
+	assert.Equal(t, 1, msg.Attempts)
+	assert.Equal(t, "broker down", msg.LastError)
+
+	fail = false
+	sent, err = relay.Flush(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, []string{"other", "first", "second"}, topic)
+}
+
+func TestRelayGiveUpAndCleanup(t *testing.T) {
+	var (
+		db  = testDB(t)
+		ctx = context.Background()
+	)
+
+	pub := PublisherFunc(func(topic string, payload []byte) error {
+		if topic == "bad" {
+			return errors.New("rejected")
+		}
+		return nil
+	})
+
+	assert.NoError(t, Publish(db, "bad", []byte("x")))
+	assert.NoError(t, Publish(db, "good", []byte("y")))
+
+	relay := NewRelay(db, pub, WithRetry(2, 0, 0), WithRetention(time.Nanosecond))
+	for i := 0; i < 3; i++ {
+		_, err := relay.Flush(ctx)
+		assert.NoError(t, err)
+	}
+
+	deleted, err := relay.Cleanup(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	var left []Message
+	assert.NoError(t, db.Find(&left).Error)
+	assert.Len(t, left, 1)
+	assert.Equal(t, 2, left[0].Attempts)
+}
+
+func TestMQPublisherOptions(t *testing.T) {
+	var driver = memory.Open(memory.DefaultConfig)
+
+	ch, _ := driver.Subscribe("topic")
+	assert.NoError(t, MQ(driver, mq.QueueTo("q")).Publish("topic", []byte("x")))
+	assert.Equal(t, "x", string((<-ch).Payload()))
+}
+
+func TestRelayHeaders(t *testing.T) {
+	var (
+		db     = testDB(t)
+		driver = memory.Open(memory.DefaultConfig)
+		ctx    = context.Background()
+	)
+
+	ch, _ := driver.Subscribe("user.create")
+	assert.NoError(t, Publish(db, "user.create", []byte("x"), Header("version", "2")))
+
+	sent, err := NewRelay(db, MQ(driver)).Flush(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, "2", mq.HeadersOf(<-ch)["version"])
+}
+
+func TestRelayGiveUpBlocksAggregate(t *testing.T) {
+	var (
+		db   = testDB(t)
+		ctx  = context.Background()
+		sent []string
+	)
+
+	pub := PublisherFunc(func(topic string, payload []byte) error {
+		if string(payload) == "first" {
+			return errors.New("rejected")
+		}
+		sent = append(sent, string(payload))
+		return nil
+	})
+
+	assert.NoError(t, Publish(db, "order", []byte("first"), Aggregate("order-1")))
+	assert.NoError(t, Publish(db, "order", []byte("second"), Aggregate("order-1")))
+	assert.NoError(t, Publish(db, "order", []byte("other"), Aggregate("order-2")))
+
+	relay := NewRelay(db, pub, WithRetry(1, 0, 0))
+	for i := 0; i < 3; i++ {
+		_, err := relay.Flush(ctx)
+		assert.NoError(t, err)
+	}
+
+	// first is given up, second waits behind it
+	assert.Equal(t, []string{"other"}, sent)
+}
@@ -0,0 +1,65 @@
+package outbox
+
+import (
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/hysios/x/events/common"
+	"github.com/hysios/x/mq"
+)
+
+// Publisher sends relayed messages to a broker
+type Publisher interface {
+	Publish(topic string, payload []byte) error
+}
+
+// HeaderPublisher is a Publisher sending the headers of the messages, the
+// headers of the messages relayed by a plain Publisher are dropped
+type HeaderPublisher interface {
+	Publisher
+	PublishHeaders(topic string, payload []byte, headers map[string]string) error
+}
+
+type PublisherFunc func(topic string, payload []byte) error
+
+func (fn PublisherFunc) Publish(topic string, payload []byte) error {
+	return fn(topic, payload)
+}
+
+// MQ relays messages through a mq.Publisher, the headers are sent with mq.Headers
+func MQ(pub mq.Publisher, opts ...mq.PubOpt) Publisher {
+	return &mqPublisher{pub: pub, opts: opts}
+}
+
+type mqPublisher struct {
+	pub  mq.Publisher
+	opts []mq.PubOpt
+}
+
+func (p *mqPublisher) Publish(topic string, payload []byte) error {
+	return p.pub.Publish(topic, payload, p.opts...)
+}
+
+func (p *mqPublisher) PublishHeaders(topic string, payload []byte, headers map[string]string) error {
+	return p.pub.Publish(topic, payload, append([]mq.PubOpt{mq.Headers(headers)}, p.opts...)...)
+}
+
+// Events relays messages through an events publisher, the headers are the message metadata
+func Events(pub common.Publisher) Publisher {
+	return &eventsPublisher{pub: pub}
+}
+
+type eventsPublisher struct {
+	pub common.Publisher
+}
+
+func (p *eventsPublisher) Publish(topic string, payload []byte) error {
+	return p.PublishHeaders(topic, payload, nil)
+}
+
+func (p *eventsPublisher) PublishHeaders(topic string, payload []byte, headers map[string]string) error {
+	var msg = message.NewMessage(watermill.NewUUID(), payload)
+	for k, v := range headers {
+		msg.Metadata.Set(k, v)
+	}
+	return p.pub.Publish(topic, msg)
+}
@@ -0,0 +1,237 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RelayOption struct {
+	// Interval between two polls of the outbox table
+	Interval time.Duration
+	// BatchSize is the max number of messages loaded per poll
+	BatchSize int
+	// MaxAttempts after which a message is given up, it stays in the table with its LastError
+	// and holds back the later messages of its aggregate
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled on every attempt up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Retention is how long sent messages are kept before cleanup, zero keeps them forever
+	Retention time.Duration
+	Log       *zap.Logger
+}
+
+type RelayOpt func(*RelayOption)
+
+var DefaultRelayOption = RelayOption{
+	Interval:    time.Second,
+	BatchSize:   100,
+	MaxAttempts: 10,
+	Backoff:     time.Second,
+	MaxBackoff:  5 * time.Minute,
+	Retention:   24 * time.Hour,
+}
+
+// Relay publishes the outbox messages in insertion order, messages of the same
+// aggregate are never sent before an earlier one of that aggregate succeeded
+type Relay struct {
+	db  *gorm.DB
+	pub Publisher
+	opt RelayOption
+}
+
+// NewRelay
+func NewRelay(db *gorm.DB, pub Publisher, opts ...RelayOpt) *Relay {
+	var opt = DefaultRelayOption
+	opt.Log = zap.NewNop()
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return &Relay{
+		db:  db,
+		pub: pub,
+		opt: opt,
+	}
+}
+
+// WithInterval
+func WithInterval(d time.Duration) RelayOpt {
+	return func(o *RelayOption) {
+		o.Interval = d
+	}
+}
+
+// WithBatchSize
+func WithBatchSize(n int) RelayOpt {
+	return func(o *RelayOption) {
+		o.BatchSize = n
+	}
+}
+
+// WithRetry
+func WithRetry(maxAttempts int, backoff, maxBackoff time.Duration) RelayOpt {
+	return func(o *RelayOption) {
+		o.MaxAttempts = maxAttempts
+		o.Backoff = backoff
+		o.MaxBackoff = maxBackoff
+	}
+}
+
+// WithRetention
+func WithRetention(d time.Duration) RelayOpt {
+	return func(o *RelayOption) {
+		o.Retention = d
+	}
+}
+
+// WithLogger
+func WithLogger(log *zap.Logger) RelayOpt {
+	return func(o *RelayOption) {
+		o.Log = log
+	}
+}
+
+// Run polls the outbox until ctx is done
+func (r *Relay) Run(ctx context.Context) error {
+	var ticker = time.NewTicker(r.opt.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Flush(ctx); err != nil {
+			r.opt.Log.Warn("outbox flush error", zap.Error(err))
+		}
+
+		if _, err := r.Cleanup(ctx); err != nil {
+			r.opt.Log.Warn("outbox cleanup error", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush publishes one batch of pending messages and returns how many were sent.
+// The batch is locked in a transaction, the relays sharing the table publish
+// different rows. A message is held back while an earlier message of its
+// aggregate is unsent, given up or locked by another relay.
+func (r *Relay) Flush(ctx context.Context) (sent int, err error) {
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var (
+			pending []*Message
+			blocked = make(map[string]bool)
+			checked = make(map[string]bool)
+		)
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("sent_at IS NULL AND attempts < ?", r.opt.MaxAttempts).
+			Order("id").
+			Limit(r.opt.BatchSize).
+			Find(&pending).Error; err != nil {
+			return err
+		}
+
+		for _, msg := range pending {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if msg.Aggregate != "" {
+				if blocked[msg.Aggregate] {
+					continue
+				}
+
+				if !checked[msg.Aggregate] {
+					checked[msg.Aggregate] = true
+
+					var earlier int64
+					if err := tx.Model(&Message{}).
+						Where("aggregate = ? AND sent_at IS NULL AND id < ?", msg.Aggregate, msg.ID).
+						Count(&earlier).Error; err != nil {
+						return err
+					}
+					if earlier > 0 {
+						blocked[msg.Aggregate] = true
+						continue
+					}
+				}
+			}
+
+			var now = time.Now()
+			if msg.NextAt.After(now) {
+				blocked[msg.Aggregate] = true
+				continue
+			}
+
+			if err := r.publish(msg); err != nil {
+				r.opt.Log.Debug("outbox publish error", zap.Uint64("id", msg.ID), zap.String("topic", msg.Topic), zap.Error(err))
+				blocked[msg.Aggregate] = true
+
+				if err := tx.Model(msg).Updates(map[string]interface{}{
+					"attempts":   msg.Attempts + 1,
+					"last_error": err.Error(),
+					"next_at":    now.Add(r.backoff(msg.Attempts)),
+				}).Error; err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := tx.Model(msg).Update("sent_at", now).Error; err != nil {
+				return err
+			}
+			sent++
+		}
+		return nil
+	})
+	return sent, err
+}
+
+// publish sends msg with its headers when the publisher supports them
+func (r *Relay) publish(msg *Message) error {
+	hp, ok := r.pub.(HeaderPublisher)
+	if !ok || len(msg.Headers) == 0 {
+		return r.pub.Publish(msg.Topic, msg.Payload)
+	}
+
+	var headers map[string]string
+	if err := json.Unmarshal(msg.Headers, &headers); err != nil {
+		return fmt.Errorf("outbox: decode headers: %w", err)
+	}
+	return hp.PublishHeaders(msg.Topic, msg.Payload, headers)
+}
+
+// Cleanup deletes the sent messages older than the retention
+func (r *Relay) Cleanup(ctx context.Context) (int64, error) {
+	if r.opt.Retention <= 0 {
+		return 0, nil
+	}
+
+	tx := r.db.WithContext(ctx).
+		Where("sent_at IS NOT NULL AND sent_at < ?", time.Now().Add(-r.opt.Retention)).
+		Delete(&Message{})
+	return tx.RowsAffected, tx.Error
+}
+
+// backoff
+func (r *Relay) backoff(attempts int) time.Duration {
+	var d = r.opt.Backoff
+	for i := 0; i < attempts && d < r.opt.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	if r.opt.MaxBackoff > 0 && d > r.opt.MaxBackoff {
+		d = r.opt.MaxBackoff
+	}
+	return d
+}
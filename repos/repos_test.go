@@ -1,9 +1,12 @@
 package repos
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/glebarez/sqlite"
 	"github.com/hysios/x/events"
+	"github.com/hysios/x/events/driver/memory"
 	"gorm.io/gorm"
 )
 
@@ -41,8 +44,10 @@ type uesrEventRepos struct {
 
 // Create
 func (u *uesrEventRepos) Create(t *User) error {
-	// u.bus.Send("user.create", t)
-	return u.Base.Create(t)
+	if err := u.Base.Create(t); err != nil {
+		return err
+	}
+	return u.bus.Send(context.Background(), "user.create", t)
 }
 
 func init() {
@@ -55,17 +60,22 @@ func init() {
 	})
 
 	Extend[User](func(base Base[User, uint], db *gorm.DB) Base[User, uint] {
-		return &uesrEventRepos{Base: base}
+		return &uesrEventRepos{Base: base, bus: events.NewBus(memory.New(memory.DefaultConfig))}
 	})
 }
 
-func ExampleRepo() {
-	db, _ := openDB()
+func Example() {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		panic(err)
+	}
+	db.AutoMigrate(&User{})
+
 	var userRepo = Init[User, uint, Base[User, uint]](db)
 	var user = &User{Name: "张三"}
-	userRepo.Create(user)
-	fmt.Println(user.No)
-	// Output: ZS
-	//
-
+	if err := userRepo.Create(user); err != nil {
+		panic(err)
+	}
+	fmt.Println(user.Id, user.Name)
+	// Output: 1 张三
 }
@@ -0,0 +1,188 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Compatibility between two versions of a schema
+type Compatibility int
+
+const (
+	// None accepts any change
+	None Compatibility = iota
+	// Backward consumers using the new schema can read payloads written with the old one
+	Backward
+	// Forward consumers using the old schema can read payloads written with the new one
+	Forward
+	// Full is both Backward and Forward
+	Full
+)
+
+func (c Compatibility) String() string {
+	switch c {
+	case None:
+		return "none"
+	case Backward:
+		return "backward"
+	case Forward:
+		return "forward"
+	case Full:
+		return "full"
+	default:
+		return fmt.Sprintf("Compatibility(%d)", int(c))
+	}
+}
+
+// ErrIncompatible is returned by Check, the message lists the breaking changes
+var ErrIncompatible = errors.New("schema: incompatible change")
+
+// Check reports whether the change from the schema from to the schema to keeps the compatibility mode.
+// It understands the keywords that matter to the shape of a payload: type,
+// properties, required, items, enum and additionalProperties.
+func Check(from, to []byte, mode Compatibility) error {
+	var o, n node
+	if err := json.Unmarshal(from, &o); err != nil {
+		return fmt.Errorf("schema: from: %w", err)
+	}
+	if err := json.Unmarshal(to, &n); err != nil {
+		return fmt.Errorf("schema: to: %w", err)
+	}
+
+	var problems []string
+	if mode == Backward || mode == Full {
+		problems = append(problems, readable(&n, &o, "$")...)
+	}
+	if mode == Forward || mode == Full {
+		problems = append(problems, readable(&o, &n, "$")...)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrIncompatible, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// node is the part of a JSON Schema Check looks at
+type node struct {
+	Type                 types            `json:"type"`
+	Properties           map[string]*node `json:"properties"`
+	Required             []string         `json:"required"`
+	Items                *node            `json:"items"`
+	Enum                 []interface{}    `json:"enum"`
+	AdditionalProperties json.RawMessage  `json:"additionalProperties"`
+}
+
+// closed reports whether properties other than the declared ones are rejected
+func (n *node) closed() bool {
+	return string(n.AdditionalProperties) == "false"
+}
+
+// types is the type keyword, a name or a list of names
+type types []string
+
+func (t *types) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = types{name}
+		return nil
+	}
+
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	*t = names
+	return nil
+}
+
+func (t types) accepts(name string) bool {
+	for _, n := range t {
+		if n == name || (n == "number" && name == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// readable lists why payloads valid against writer may be invalid against reader
+func readable(reader, writer *node, path string) []string {
+	if reader == nil {
+		return nil
+	}
+	if writer == nil {
+		writer = &node{}
+	}
+
+	var problems []string
+
+	if len(reader.Type) > 0 {
+		if len(writer.Type) == 0 {
+			problems = append(problems, fmt.Sprintf("%s: type %v is not enforced by the writer", path, []string(reader.Type)))
+		}
+		for _, t := range writer.Type {
+			if !reader.Type.accepts(t) {
+				problems = append(problems, fmt.Sprintf("%s: type %s is not accepted by %v", path, t, []string(reader.Type)))
+			}
+		}
+	}
+
+	if len(reader.Enum) > 0 {
+		if len(writer.Enum) == 0 {
+			problems = append(problems, fmt.Sprintf("%s: enum is not enforced by the writer", path))
+		}
+		for _, v := range writer.Enum {
+			if !contains(reader.Enum, v) {
+				problems = append(problems, fmt.Sprintf("%s: enum value %v is not accepted", path, v))
+			}
+		}
+	}
+
+	for _, name := range reader.Required {
+		if !containsString(writer.Required, name) {
+			problems = append(problems, fmt.Sprintf("%s.%s: required field may be missing", path, name))
+		}
+	}
+
+	var names = make([]string, 0, len(writer.Properties))
+	for name := range writer.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if prop, ok := reader.Properties[name]; ok {
+			problems = append(problems, readable(prop, writer.Properties[name], path+"."+name)...)
+		} else if reader.closed() {
+			problems = append(problems, fmt.Sprintf("%s.%s: field is not allowed", path, name))
+		}
+	}
+
+	if reader.Items != nil {
+		problems = append(problems, readable(reader.Items, writer.Items, path+"[]")...)
+	}
+
+	return problems
+}
+
+func contains(values []interface{}, v interface{}) bool {
+	for _, value := range values {
+		if reflect.DeepEqual(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
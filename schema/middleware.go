@@ -0,0 +1,93 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/hysios/x/events/common"
+	"github.com/hysios/x/mq"
+)
+
+// Middleware is a mq middleware validating payloads with r. Publishing an
+// invalid payload fails and the valid ones get the VersionHeader. Consumed
+// messages are validated against the version of their header, the invalid
+// ones go to deadLetter, or are logged when it's nil, and are acked without
+// being handled. They are nacked when deadLetter fails.
+func Middleware(r *Registry, deadLetter mq.DeadLetterFunc) mq.Middleware {
+	return mq.Chain(
+		mq.PublishMiddleware(func(next mq.PublishFunc) mq.PublishFunc {
+			return func(ctx context.Context, topic string, payload []byte, opts ...mq.PubOpt) error {
+				s, err := r.Validate(topic, payload, "")
+				if err != nil {
+					return err
+				}
+
+				if s != nil {
+					opts = append(opts[:len(opts):len(opts)], mq.Header(VersionHeader, strconv.Itoa(s.Version)))
+				}
+				return next(ctx, topic, payload, opts...)
+			}
+		}),
+		mq.ConsumeMiddleware(func(next mq.HandlerFunc) mq.HandlerFunc {
+			return func(ctx context.Context, msg mq.Message) error {
+				var topic = mq.TopicFromContext(ctx)
+
+				if _, err := r.Validate(topic, msg.Payload(), mq.HeadersOf(msg)[VersionHeader]); err != nil {
+					if deadLetter == nil {
+						log.Printf("schema: drop message %s of %s: %v", mq.IDOf(msg), topic, err)
+						return nil
+					}
+					if dlErr := deadLetter(ctx, mq.Meta{Topic: topic, Message: msg}, err); dlErr != nil {
+						return fmt.Errorf("schema: dead letter %s: %w", mq.IDOf(msg), dlErr)
+					}
+					return nil
+				}
+				return next(ctx, msg)
+			}
+		}),
+	)
+}
+
+// HandlerMiddleware is a watermill middleware validating the consumed messages,
+// the invalid ones are logged and acked without being handled
+func HandlerMiddleware(r *Registry) message.HandlerMiddleware {
+	return func(h message.HandlerFunc) message.HandlerFunc {
+		return func(msg *message.Message) ([]*message.Message, error) {
+			var topic = message.SubscribeTopicFromCtx(msg.Context())
+
+			if _, err := r.Validate(topic, msg.Payload, msg.Metadata.Get(VersionHeader)); err != nil {
+				log.Printf("schema: drop message %s of %s: %v", msg.UUID, topic, err)
+				return nil, nil
+			}
+			return h(msg)
+		}
+	}
+}
+
+// Publisher validates the messages before publishing them with pub and sets their VersionHeader
+func Publisher(r *Registry, pub common.Publisher) common.Publisher {
+	return &publisher{Publisher: pub, r: r}
+}
+
+type publisher struct {
+	common.Publisher
+	r *Registry
+}
+
+func (p *publisher) Publish(topic string, messages ...*common.Message) error {
+	for _, msg := range messages {
+		s, err := p.r.Validate(topic, msg.Payload, "")
+		if err != nil {
+			return err
+		}
+
+		if s != nil {
+			msg.Metadata.Set(VersionHeader, strconv.Itoa(s.Version))
+		}
+	}
+
+	return p.Publisher.Publish(topic, messages...)
+}
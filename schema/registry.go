@@ -0,0 +1,147 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// Registry keeps the schema versions of every topic
+type Registry struct {
+	mu     sync.RWMutex
+	topics map[string][]*Schema
+	opt    Option
+}
+
+type Option struct {
+	// Compatibility is checked against the latest version on Register
+	Compatibility Compatibility
+}
+
+type Opt func(*Option)
+
+var DefaultOption = Option{
+	Compatibility: Backward,
+}
+
+// WithCompatibility
+func WithCompatibility(mode Compatibility) Opt {
+	return func(o *Option) {
+		o.Compatibility = mode
+	}
+}
+
+// NewRegistry
+func NewRegistry(opts ...Opt) *Registry {
+	var opt = DefaultOption
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return &Registry{
+		topics: make(map[string][]*Schema),
+		opt:    opt,
+	}
+}
+
+// Default is the registry of the package functions
+var Default = NewRegistry()
+
+// Register adds a JSON Schema version to topic. Registering the latest schema
+// again returns it, a schema incompatible with the latest one is rejected.
+func (r *Registry) Register(topic string, source []byte) (*Schema, error) {
+	s, err := Compile(source)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var versions = r.topics[topic]
+	if n := len(versions); n > 0 {
+		var latest = versions[n-1]
+		if bytes.Equal(latest.Source, s.Source) {
+			return latest, nil
+		}
+
+		if err := Check(latest.Source, s.Source, r.opt.Compatibility); err != nil {
+			return nil, fmt.Errorf("schema: %s v%d: %w", topic, n+1, err)
+		}
+	}
+
+	s.Topic = topic
+	s.Version = len(versions) + 1
+	r.topics[topic] = append(versions, s)
+	return s, nil
+}
+
+// RegisterType registers the schema generated from the type of v
+func (r *Registry) RegisterType(topic string, v interface{}) (*Schema, error) {
+	source, err := FromType(v)
+	if err != nil {
+		return nil, err
+	}
+	return r.Register(topic, source)
+}
+
+// Latest returns the latest schema of topic
+func (r *Registry) Latest(topic string) (*Schema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var versions = r.topics[topic]
+	if len(versions) == 0 {
+		return nil, false
+	}
+	return versions[len(versions)-1], true
+}
+
+// Version returns the version of topic
+func (r *Registry) Version(topic string, version int) (*Schema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var versions = r.topics[topic]
+	if version < 1 || version > len(versions) {
+		return nil, false
+	}
+	return versions[version-1], true
+}
+
+// Validate checks payload against the version of topic, the latest one when
+// version is empty or unknown here, as a newer producer may have registered it
+// in its own registry. Topics without schema accept anything and return a nil schema.
+func (r *Registry) Validate(topic string, payload []byte, version string) (*Schema, error) {
+	var (
+		s  *Schema
+		ok bool
+	)
+
+	if v, err := strconv.Atoi(version); err == nil {
+		s, ok = r.Version(topic, v)
+	}
+	if !ok {
+		if s, ok = r.Latest(topic); !ok {
+			return nil, nil
+		}
+	}
+
+	return s, s.Validate(payload)
+}
+
+// Register registers a JSON Schema in the Default registry
+func Register(topic string, source []byte) (*Schema, error) {
+	return Default.Register(topic, source)
+}
+
+// RegisterType registers the schema of the type of v in the Default registry
+func RegisterType(topic string, v interface{}) (*Schema, error) {
+	return Default.RegisterType(topic, v)
+}
+
+// Validate validates payload with the Default registry
+func Validate(topic string, payload []byte, version string) (*Schema, error) {
+	return Default.Validate(topic, payload, version)
+}
@@ -0,0 +1,167 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// VersionHeader carries the schema version a payload was validated against
+const VersionHeader = "x-schema-version"
+
+// Schema is a compiled JSON Schema registered for a topic
+type Schema struct {
+	Topic   string
+	Version int
+	// Source is the JSON Schema document
+	Source []byte
+
+	compiled *jsonschema.Schema
+}
+
+// Compile compiles a JSON Schema document
+func Compile(source []byte) (*Schema, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, source); err != nil {
+		return nil, fmt.Errorf("schema: %w", err)
+	}
+
+	compiled, err := jsonschema.CompileString("schema.json", buf.String())
+	if err != nil {
+		return nil, fmt.Errorf("schema: %w", err)
+	}
+
+	return &Schema{Source: buf.Bytes(), compiled: compiled}, nil
+}
+
+// Validate checks that payload is JSON valid against the schema
+func (s *Schema) Validate(payload []byte) error {
+	var (
+		v   interface{}
+		dec = json.NewDecoder(bytes.NewReader(payload))
+	)
+	dec.UseNumber()
+
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("schema: %s v%d: %w", s.Topic, s.Version, err)
+	}
+
+	if err := s.compiled.Validate(v); err != nil {
+		return fmt.Errorf("schema: %s v%d: %w", s.Topic, s.Version, err)
+	}
+	return nil
+}
+
+// FromType generates the JSON Schema of the JSON encoding of v. Fields without
+// omitempty are required, pointers are nullable.
+func FromType(v interface{}) ([]byte, error) {
+	var t = reflect.TypeOf(v)
+	if t == nil {
+		return nil, fmt.Errorf("schema: nil type")
+	}
+
+	return json.Marshal(typeSchema(t, make(map[reflect.Type]bool)))
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+	marshal  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// typeSchema returns the schema of t, seen guards against recursive types
+func typeSchema(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawType, t.Kind() != reflect.Ptr && t.Implements(marshal):
+		// custom encodings can be anything
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		var s = typeSchema(t.Elem(), seen)
+		if typ, ok := s["type"].(string); ok {
+			s["type"] = []string{typ, "null"}
+		}
+		return s
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return map[string]interface{}{"type": "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		var (
+			props    = make(map[string]interface{})
+			required []string
+		)
+		structFields(t, seen, props, &required)
+
+		var s = map[string]interface{}{"type": "object", "properties": props}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// structFields adds the fields of t, embedded structs are inlined like encoding/json does
+func structFields(t reflect.Type, seen map[reflect.Type]bool, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		var f = t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && name == "" {
+			var ft = f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				structFields(ft, seen, props, required)
+				continue
+			}
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		props[name] = typeSchema(f.Type, seen)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
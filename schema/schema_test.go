@@ -0,0 +1,185 @@
+package schema
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hysios/x/mq"
+	"github.com/hysios/x/mq/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+type Address struct {
+	City string `json:"city"`
+}
+
+type order struct {
+	Address
+	ID      int               `json:"id"`
+	Price   float64           `json:"price"`
+	Note    *string           `json:"note"`
+	Tags    []string          `json:"tags,omitempty"`
+	Attrs   map[string]string `json:"attrs,omitempty"`
+	Created time.Time         `json:"created"`
+	secret  string
+	Ignored string `json:"-"`
+}
+
+func TestFromType(t *testing.T) {
+	source, err := FromType(order{})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"type": "object",
+		"properties": {
+			"city": {"type": "string"},
+			"id": {"type": "integer"},
+			"price": {"type": "number"},
+			"note": {"type": ["string", "null"]},
+			"tags": {"type": "array", "items": {"type": "string"}},
+			"attrs": {"type": "object", "additionalProperties": {"type": "string"}},
+			"created": {"type": "string", "format": "date-time"}
+		},
+		"required": ["city", "id", "price", "note", "created"]
+	}`, string(source))
+}
+
+func TestRegistry(t *testing.T) {
+	var r = NewRegistry()
+
+	s, err := r.RegisterType("orders", order{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, s.Version)
+
+	// registering the same schema again keeps the version
+	again, err := r.RegisterType("orders", order{})
+	assert.NoError(t, err)
+	assert.Same(t, s, again)
+
+	_, err = r.Validate("orders", []byte(`{"id":1,"price":2.5,"note":null,"city":"x","created":"2024-01-02T00:00:00Z"}`), "")
+	assert.NoError(t, err)
+	_, err = r.Validate("orders", []byte(`{"id":"1"}`), "1")
+	assert.Error(t, err)
+	// an unknown version is validated against the latest one
+	s, err = r.Validate("orders", []byte(`{}`), "9")
+	assert.Error(t, err)
+	assert.Equal(t, 1, s.Version)
+	_, err = r.Validate("orders", []byte(`{"id":1,"price":2.5,"note":null,"city":"x","created":"2024-01-02T00:00:00Z"}`), "9")
+	assert.NoError(t, err)
+
+	// topics without schema accept anything
+	s, err = r.Validate("other", []byte(`not json`), "")
+	assert.NoError(t, err)
+	assert.Nil(t, s)
+
+	// a new required field breaks the consumers of the old payloads
+	_, err = r.Register("orders", []byte(`{"type":"object","required":["id","sku"],"properties":{"id":{"type":"integer"},"sku":{"type":"string"}}}`))
+	assert.ErrorIs(t, err, ErrIncompatible)
+
+	s, err = r.Register("orders", []byte(`{"type":"object","required":["id"],"properties":{"id":{"type":"number"}}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, s.Version)
+}
+
+func TestCheck(t *testing.T) {
+	var (
+		v1 = []byte(`{"type":"object","required":["id"],"properties":{"id":{"type":"integer"},"status":{"enum":["new","paid"]}}}`)
+		// adds an optional field and widens id
+		v2 = []byte(`{"type":"object","required":["id"],"properties":{"id":{"type":"number"},"status":{"enum":["new","paid","refunded"]},"sku":{"type":"string"}}}`)
+		// closes the object and drops status
+		v3 = []byte(`{"type":"object","additionalProperties":false,"required":["id"],"properties":{"id":{"type":"integer"}}}`)
+	)
+
+	assert.NoError(t, Check(v1, v2, Backward))
+	assert.ErrorIs(t, Check(v1, v2, Forward), ErrIncompatible)
+	assert.ErrorIs(t, Check(v1, v2, Full), ErrIncompatible)
+	assert.NoError(t, Check(v1, v2, None))
+
+	err := Check(v1, v3, Backward)
+	assert.ErrorIs(t, err, ErrIncompatible)
+	assert.Contains(t, err.Error(), "$.status: field is not allowed")
+	assert.NoError(t, Check(v1, v3, Forward))
+}
+
+func TestMiddleware(t *testing.T) {
+	var (
+		r           = NewRegistry()
+		d           = mq.Wrap(memory.Open(memory.DefaultConfig), Middleware(r, nil))
+		ctx, cancel = context.WithCancel(context.Background())
+		got         = make(chan mq.Message, 2)
+		done        = make(chan error)
+	)
+	_, err := r.Register("orders", []byte(`{"type":"object","required":["id"],"properties":{"id":{"type":"integer"}}}`))
+	assert.NoError(t, err)
+
+	go func() {
+		done <- mq.Handle(ctx, mq.Bind(d, "orders"), func(ctx context.Context, msg mq.Message) error {
+			got <- msg
+			return nil
+		}, mq.SubscribeWith(mq.Queue("billing")))
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	assert.Error(t, d.Publish("orders", []byte(`{"id":"x"}`)))
+	assert.NoError(t, d.Publish("orders", []byte(`{"id":1}`)))
+
+	select {
+	case msg := <-got:
+		assert.Equal(t, "1", mq.HeadersOf(msg)[VersionHeader])
+	case <-time.After(time.Second):
+		t.Fatal("receive timeout")
+	}
+
+	cancel()
+	<-done
+}
+
+func TestMiddlewareDeadLetter(t *testing.T) {
+	var (
+		r           = NewRegistry()
+		raw         = memory.Open(memory.DefaultConfig)
+		dead        = make(chan mq.Meta, 1)
+		handled     = make(chan mq.Message, 1)
+		ctx, cancel = context.WithCancel(context.Background())
+		done        = make(chan error)
+	)
+	_, err := r.Register("orders", []byte(`{"type":"object","required":["id"]}`))
+	assert.NoError(t, err)
+
+	var d = mq.Wrap(raw, Middleware(r, func(ctx context.Context, meta mq.Meta, err error) error {
+		dead <- meta
+		return nil
+	}))
+
+	go func() {
+		done <- mq.Handle(ctx, mq.Bind(d, "orders"), func(ctx context.Context, msg mq.Message) error {
+			handled <- msg
+			return nil
+		}, mq.SubscribeWith(mq.Queue("billing")))
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// published around the middleware
+	assert.NoError(t, raw.Publish("orders", []byte(`{}`)))
+
+	select {
+	case meta := <-dead:
+		assert.Equal(t, "orders", meta.Topic)
+		assert.Equal(t, []byte(`{}`), meta.Message.Payload())
+	case <-time.After(time.Second):
+		t.Fatal("dead letter timeout")
+	}
+	assert.Empty(t, handled)
+
+	// a version unknown here is validated against the latest schema
+	assert.NoError(t, raw.Publish("orders", []byte(`{"id":1}`), mq.Header(VersionHeader, "7")))
+	select {
+	case msg := <-handled:
+		assert.Equal(t, []byte(`{"id":1}`), msg.Payload())
+	case <-time.After(time.Second):
+		t.Fatal("receive timeout")
+	}
+
+	cancel()
+	<-done
+}